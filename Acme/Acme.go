@@ -0,0 +1,134 @@
+package Acme
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"proxy/Logger"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+var l *Logger.Logger
+
+// certificate manager shared by all https listeners, nil if 'Acme' section is missing
+var manager *autocert.Manager
+
+type Settings struct {
+	// ACME directory of the CA, Let's Encrypt is used by default
+	DirectoryURL string
+	// PEM bundle used to verify directory server, for local test CAs like Pebble
+	DirectoryCA string
+	// contact address of the account
+	Email string
+	// domains certificates are requested for, other server names get static certificate of the listener
+	Domains []string
+	// directory certificates and account key are stored in
+	CacheDir string
+	// days before expiry certificate is renewed, 30 by default
+	RenewBefore int
+}
+
+func (s *Settings) Validate() error {
+	if len(s.Domains) == 0 {
+		return errors.New("Acme settings should contain at least one domain")
+	}
+	if s.CacheDir == "" {
+		return errors.New("Acme settings should contain 'cacheDir' to keep certificates between restarts")
+	}
+	if s.DirectoryURL == "" {
+		s.DirectoryURL = autocert.DefaultACMEDirectory
+	}
+	if s.RenewBefore < 0 {
+		return errors.New("Acme 'renewBefore' can't be negative")
+	}
+	return nil
+}
+
+// Read ACME settings from parsed json value and validates it
+func ReadAcmeFromFile(v interface{}) *Settings {
+	var s Settings
+	if err := mapstructure.Decode(v, &s); err != nil {
+		panic("Can't decode ACME settings. Error: " + err.Error())
+	}
+	if err := s.Validate(); err != nil {
+		panic(err.Error())
+	}
+
+	return &s
+}
+
+// creates certificate manager for settings
+func newManager(s *Settings) (*autocert.Manager, error) {
+	client := &acme.Client{DirectoryURL: s.DirectoryURL}
+	if s.DirectoryCA != "" {
+		data, err := ioutil.ReadFile(s.DirectoryCA)
+		if err != nil {
+			return nil, errors.New("Can't read ACME directoryCA. Error: " + err.Error())
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(data) {
+			return nil, errors.New("ACME directoryCA doesn't contain PEM certificates: " + s.DirectoryCA)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+		client.HTTPClient = &http.Client{Transport: transport}
+	}
+
+	return &autocert.Manager{
+		Prompt:      autocert.AcceptTOS,
+		Cache:       autocert.DirCache(s.CacheDir),
+		HostPolicy:  autocert.HostWhitelist(s.Domains...),
+		RenewBefore: time.Duration(s.RenewBefore) * 24 * time.Hour,
+		Client:      client,
+		Email:       s.Email,
+	}, nil
+}
+
+// Inits certificate manager from 'Acme' section, section is optional
+func InitAcme(file map[string]interface{}) {
+	manager = nil
+	if v, exist := file["Acme"]; exist {
+		l = Logger.New("Acme", 0, nil)
+		m, err := newManager(ReadAcmeFromFile(v))
+		if err != nil {
+			panic(err.Error())
+		}
+		manager = m
+	}
+}
+
+// Enabled reports whether certificates can be managed by ACME
+func Enabled() bool {
+	return manager != nil
+}
+
+// Configure makes TLS configuration obtain certificates from ACME CA and answer TLS-ALPN-01 challenges.
+// Static certificate, if not nil, is used when certificate can't be issued or server name isn't managed
+func Configure(config *tls.Config, static *tls.Certificate) {
+	m := manager
+	config.NextProtos = append(append([]string{}, config.NextProtos...), acme.ALPNProto)
+	config.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, err := m.GetCertificate(hello)
+		if err == nil || static == nil {
+			return cert, err
+		}
+		l.Warning(map[string]string{"server_name": hello.ServerName, "error": err.Error()},
+			"Can't get ACME certificate, static certificate is used")
+		return static, nil
+	}
+}
+
+// Handler answers HTTP-01 challenges and passes other requests to next handler.
+// HTTP-01 challenge is used only if this handler serves plain http listener
+func Handler(next http.Handler) http.Handler {
+	if manager == nil {
+		return next
+	}
+	return manager.HTTPHandler(next)
+}
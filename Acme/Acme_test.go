@@ -0,0 +1,290 @@
+package Acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"proxy/Logger"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+const domain = "example.test"
+
+var idPeAcmeIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+// fakeCA is minimal RFC 8555 server issuing one certificate after validating single challenge
+type fakeCA struct {
+	t   *testing.T
+	srv *httptest.Server
+	// challenge type offered to the client
+	challenge string
+	// address challenge is validated against
+	target string
+
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+
+	mu          sync.Mutex
+	thumbprint  string
+	authzStatus string
+	orderStatus string
+	leaf        []byte
+}
+
+func newFakeCA(t *testing.T, challenge string) *fakeCA {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "Fake ACME CA"},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(24 * time.Hour), IsCA: true,
+		BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}
+	der, _ := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	cert, _ := x509.ParseCertificate(der)
+
+	ca := &fakeCA{t: t, challenge: challenge, cert: cert, key: key}
+	ca.srv = httptest.NewTLSServer(http.HandlerFunc(ca.serve))
+	return ca
+}
+
+func (ca *fakeCA) reply(w http.ResponseWriter, status int, location string, v interface{}) {
+	if location != "" {
+		w.Header().Set("Location", ca.srv.URL+location)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (ca *fakeCA) order() map[string]interface{} {
+	order := map[string]interface{}{"status": ca.orderStatus, "finalize": ca.srv.URL + "/finalize",
+		"identifiers":    []map[string]string{{"type": "dns", "value": domain}},
+		"authorizations": []string{ca.srv.URL + "/authz"}}
+	if ca.leaf != nil {
+		order["certificate"] = ca.srv.URL + "/cert"
+	}
+	return order
+}
+
+func (ca *fakeCA) serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", base64.RawURLEncoding.EncodeToString(big.NewInt(time.Now().UnixNano()).Bytes()))
+	if r.URL.Path == "/dir" {
+		ca.reply(w, http.StatusOK, "", map[string]string{"newNonce": ca.srv.URL + "/nonce",
+			"newAccount": ca.srv.URL + "/account", "newOrder": ca.srv.URL + "/order"})
+		return
+	}
+	if r.URL.Path == "/nonce" {
+		return
+	}
+
+	var jws struct{ Protected, Payload string }
+	json.NewDecoder(r.Body).Decode(&jws)
+	payload, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	switch r.URL.Path {
+	case "/account":
+		protected, _ := base64.RawURLEncoding.DecodeString(jws.Protected)
+		var header struct{ Jwk struct{ X, Y string } }
+		json.Unmarshal(protected, &header)
+		x, _ := base64.RawURLEncoding.DecodeString(header.Jwk.X)
+		y, _ := base64.RawURLEncoding.DecodeString(header.Jwk.Y)
+		ca.thumbprint, _ = acme.JWKThumbprint(&ecdsa.PublicKey{Curve: elliptic.P256(),
+			X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)})
+		ca.reply(w, http.StatusCreated, "/account/1", map[string]string{"status": "valid"})
+	case "/order":
+		ca.authzStatus, ca.orderStatus, ca.leaf = "pending", "pending", nil
+		ca.reply(w, http.StatusCreated, "/order/1", ca.order())
+	case "/order/1":
+		ca.reply(w, http.StatusOK, "/order/1", ca.order())
+	case "/authz":
+		ca.reply(w, http.StatusOK, "", map[string]interface{}{"status": ca.authzStatus,
+			"identifier": map[string]string{"type": "dns", "value": domain},
+			"challenges": []map[string]string{{"type": ca.challenge, "url": ca.srv.URL + "/challenge", "token": "token1"}}})
+	case "/challenge":
+		ca.authzStatus = "invalid"
+		if err := ca.validate("token1." + ca.thumbprint); err != nil {
+			ca.t.Error("Challenge validation failed: ", err)
+		} else {
+			ca.authzStatus, ca.orderStatus = "valid", "ready"
+		}
+		ca.reply(w, http.StatusOK, "", map[string]string{"type": ca.challenge, "url": ca.srv.URL + "/challenge",
+			"token": "token1", "status": ca.authzStatus})
+	case "/finalize":
+		var req struct{ Csr string }
+		json.Unmarshal(payload, &req)
+		der, _ := base64.RawURLEncoding.DecodeString(req.Csr)
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil || ca.orderStatus != "ready" {
+			ca.t.Error("Unexpected finalize request: ", err)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		template := &x509.Certificate{SerialNumber: big.NewInt(2), Subject: pkix.Name{CommonName: domain},
+			DNSNames: csr.DNSNames, NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(90 * 24 * time.Hour),
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}
+		ca.leaf, _ = x509.CreateCertificate(rand.Reader, template, ca.cert, csr.PublicKey, ca.key)
+		ca.orderStatus = "valid"
+		ca.reply(w, http.StatusOK, "/order/1", ca.order())
+	case "/cert":
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: ca.leaf})
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// validate checks that target serves key authorization of the challenge
+func (ca *fakeCA) validate(keyAuth string) error {
+	if ca.challenge == "http-01" {
+		req, _ := http.NewRequest("GET", "http://"+ca.target+"/.well-known/acme-challenge/token1", nil)
+		req.Host = domain
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		if string(body) != keyAuth {
+			return errors.New("unexpected key authorization: " + string(body))
+		}
+		return nil
+	}
+
+	conn, err := tls.Dial("tcp", ca.target, &tls.Config{ServerName: domain, NextProtos: []string{acme.ALPNProto},
+		InsecureSkipVerify: true})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	sum := sha256.Sum256([]byte(keyAuth))
+	expected, _ := asn1.Marshal(sum[:])
+	for _, ext := range conn.ConnectionState().PeerCertificates[0].Extensions {
+		if ext.Id.Equal(idPeAcmeIdentifier) && string(ext.Value) == string(expected) {
+			return nil
+		}
+	}
+	return errors.New("acmeIdentifier extension doesn't match key authorization")
+}
+
+func staticCertificate(t *testing.T) *tls.Certificate {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{SerialNumber: big.NewInt(3), Subject: pkix.Name{CommonName: "static"},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// starts https listener with certificates managed by ACME
+func listen(t *testing.T, static *tls.Certificate) string {
+	config := &tls.Config{NextProtos: []string{"http/1.1"}}
+	Configure(config, static)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go http.Serve(ln, http.NotFoundHandler())
+	return ln.Addr().String()
+}
+
+func serverCertificate(t *testing.T, addr string, config *tls.Config) *x509.Certificate {
+	conn, err := tls.Dial("tcp", addr, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0]
+}
+
+func TestSettings_Validate(t *testing.T) {
+	s := Settings{CacheDir: "certs"}
+	if err := s.Validate(); err == nil {
+		t.Error("Validate() should fail without domains")
+	}
+	s = Settings{Domains: []string{domain}}
+	if err := s.Validate(); err == nil {
+		t.Error("Validate() should fail without cacheDir")
+	}
+	s = Settings{Domains: []string{domain}, CacheDir: "certs"}
+	if err := s.Validate(); err != nil || s.DirectoryURL == "" {
+		t.Error("Let's Encrypt directory should be used by default: ", err)
+	}
+}
+
+func TestIssue(t *testing.T) {
+	Logger.Init(uint32(Logger.LError), Logger.UseStdOut, nil)
+
+	for _, challenge := range []string{"tls-alpn-01", "http-01"} {
+		t.Run(challenge, func(t *testing.T) {
+			dir, _ := ioutil.TempDir("", "acme")
+			defer os.RemoveAll(dir)
+
+			ca := newFakeCA(t, challenge)
+			defer ca.srv.Close()
+			caPath := filepath.Join(dir, "directory.pem")
+			ioutil.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.srv.Certificate().Raw}), 0600)
+
+			InitAcme(map[string]interface{}{"Acme": map[string]interface{}{"directoryURL": ca.srv.URL + "/dir",
+				"directoryCA": caPath, "domains": []interface{}{domain}, "cacheDir": filepath.Join(dir, "certs")}})
+			addr := listen(t, staticCertificate(t))
+			ca.target = addr
+			if challenge == "http-01" {
+				plain := httptest.NewServer(Handler(http.NotFoundHandler()))
+				defer plain.Close()
+				ca.target = plain.Listener.Addr().String()
+			}
+
+			roots := x509.NewCertPool()
+			roots.AddCert(ca.cert)
+			cert := serverCertificate(t, addr, &tls.Config{ServerName: domain, RootCAs: roots})
+			if cert.Issuer.CommonName != "Fake ACME CA" {
+				t.Error("Certificate should be issued by ACME CA, got issuer: " + cert.Issuer.CommonName)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "certs", domain)); err != nil {
+				t.Error("Certificate should be stored in cache directory: ", err)
+			}
+
+			cert = serverCertificate(t, addr, &tls.Config{InsecureSkipVerify: true})
+			if cert.Subject.CommonName != "static" {
+				t.Error("Static certificate should be used without server name")
+			}
+		})
+	}
+}
+
+func TestFallback(t *testing.T) {
+	Logger.Init(uint32(Logger.LError), Logger.UseStdOut, nil)
+	dir, _ := ioutil.TempDir("", "acme")
+	defer os.RemoveAll(dir)
+
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+	InitAcme(map[string]interface{}{"Acme": map[string]interface{}{"directoryURL": unreachable.URL + "/dir",
+		"domains": []interface{}{domain}, "cacheDir": dir}})
+	addr := listen(t, staticCertificate(t))
+
+	cert := serverCertificate(t, addr, &tls.Config{ServerName: domain, InsecureSkipVerify: true})
+	if cert.Subject.CommonName != "static" {
+		t.Error("Static certificate should be used when certificate can't be issued")
+	}
+}
@@ -0,0 +1,120 @@
+package Admin
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net"
+	"net/http"
+	"proxy/Handoff"
+	"proxy/Metrics"
+	"proxy/Shutdown"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mitchellh/mapstructure"
+)
+
+// admin settings, nil if 'Admin' section is missing
+var settings *Settings
+
+// engine that serves admin operations, nil if admin listener isn't configured
+var Engine *gin.Engine
+
+type Settings struct {
+	// address admin listener is bound to, localhost by default
+	Addr string
+	Port int
+	// if specified, every admin request should contain 'Authorization: Bearer <token>' header.
+	// It's required unless listener is bound to loopback address
+	Token string
+}
+
+func (s *Settings) Validate() error {
+	if s.Port <= 0 || s.Port > 65535 {
+		return errors.New("Admin listener should have valid 'port'")
+	}
+	if s.Addr == "" {
+		s.Addr = "127.0.0.1"
+	}
+	// cache purge and log level changes would be open to everyone who can reach the address
+	if s.Token == "" && !loopback(s.Addr) {
+		return errors.New("Admin listener bound to non-loopback address " + s.Addr + " should have 'token'")
+	}
+	return nil
+}
+
+func loopback(addr string) bool {
+	if addr == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(addr)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Read admin settings from parsed json value and validates it
+func ReadAdminFromFile(v interface{}) *Settings {
+	var s Settings
+	if err := mapstructure.Decode(v, &s); err != nil {
+		panic("Can't decode admin settings. Error: " + err.Error())
+	}
+	if err := s.Validate(); err != nil {
+		panic(err.Error())
+	}
+
+	return &s
+}
+
+// checks admin token of the request
+func tokenMiddleware(token string) gin.HandlerFunc {
+	expected := []byte("Bearer " + token)
+	return func(c *gin.Context) {
+		if subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), expected) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid admin token"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// readiness probe fails once shutdown starts, so load balancers stop sending new traffic
+func ready(c *gin.Context) {
+	if Shutdown.Draining() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "draining"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ready"})
+}
+
+// Inits admin engine from 'Admin' section. Returns nil if section is missing
+func InitAdmin(file map[string]interface{}) *gin.Engine {
+	v, exist := file["Admin"]
+	if !exist {
+		return nil
+	}
+
+	settings = ReadAdminFromFile(v)
+	Engine = gin.New()
+	// readiness is registered before token check, so probes of load balancers don't need the token
+	Engine.GET("/ready", ready)
+	if settings.Token != "" {
+		Engine.Use(tokenMiddleware(settings.Token))
+	}
+	Engine.GET("/metrics", gin.WrapH(Metrics.Handler()))
+
+	return Engine
+}
+
+// Address admin listener should be bound to
+func Address() string {
+	return settings.Addr + ":" + strconv.Itoa(settings.Port)
+}
+
+// Listen creates admin listener, listener of previous process is taken over if it's handed off
+func Listen() (net.Listener, error) {
+	return Handoff.Listen("tcp", Address())
+}
+
+// Serves admin engine on the listener, blocks until listener fails
+func Serve(ln net.Listener) error {
+	return http.Serve(ln, Engine)
+}
@@ -0,0 +1,96 @@
+package Admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"proxy/Metrics"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSettings_Validate(t *testing.T) {
+	s := Settings{Port: 9000}
+	if err := s.Validate(); err != nil {
+		t.Error(err)
+	}
+	if s.Addr != "127.0.0.1" {
+		t.Error("Admin listener should be bound to localhost by default")
+	}
+
+	s.Port = 0
+	if err := s.Validate(); err == nil {
+		t.Error("Validate() should fail without port")
+	}
+
+	s = Settings{Port: 9000, Addr: "0.0.0.0"}
+	if err := s.Validate(); err == nil {
+		t.Error("Validate() should fail without token on non-loopback address")
+	}
+	s.Token = "secret"
+	if err := s.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestInitAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	if InitAdmin(map[string]interface{}{}) != nil {
+		t.Error("Admin engine shouldn't be created without 'Admin' section")
+	}
+
+	engine := InitAdmin(map[string]interface{}{"Admin": map[string]interface{}{"port": 9000, "token": "secret"}})
+	if engine == nil {
+		t.Fatal("Admin engine should be created")
+	}
+	if Address() != "127.0.0.1:9000" {
+		t.Error("Unexpected admin address: " + Address())
+	}
+	engine.GET("/ping", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest("GET", "/ping", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Error("Request without token should be rejected")
+	}
+
+	req := httptest.NewRequest("GET", "/ping", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Error("Request with valid token should be accepted")
+	}
+}
+
+func TestReady(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := InitAdmin(map[string]interface{}{"Admin": map[string]interface{}{"port": 9000, "token": "secret"}})
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest("GET", "/ready", nil))
+	if w.Code != http.StatusOK {
+		t.Error("Readiness probe shouldn't require token and should succeed before shutdown, got: ", w.Code)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	Metrics.NewCounter("admin_test_total", "Test counter.").With().Inc()
+	engine := InitAdmin(map[string]interface{}{"Admin": map[string]interface{}{"port": 9000, "token": "secret"}})
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Error("Metrics should require admin token, got: ", w.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "admin_test_total 1\n") {
+		t.Error("Metrics should be exposed in text format: ", w.Code, w.Body.String())
+	}
+}
@@ -1,9 +1,22 @@
 package Authentication
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
 	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"proxy/Logger"
 	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
 )
 
 func handleInitializationPanicExpected(t *testing.T, message string) {
@@ -145,3 +158,87 @@ func TestReadAuthFromFile(t *testing.T) {
 		ReadAuthFromFile(mock["Auth"])
 	}()
 }
+
+func newClientCertificate(t *testing.T, cn string, dns string) *x509.Certificate {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{SerialNumber: big.NewInt(42), Subject: pkix.Name{CommonName: cn, Organization: []string{"Partner"}},
+		DNSNames: []string{dns}, NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert
+}
+
+func TestMtlsMiddleware(t *testing.T) {
+	Logger.Init(uint32(Logger.LError), Logger.UseStdOut, nil)
+	lauth = Logger.New("Authentication", 0, nil)
+	gin.SetMode(gin.TestMode)
+
+	trusted := newClientCertificate(t, "billing", "billing.partner.com")
+	pinned := newClientCertificate(t, "pinned", "pinned.local")
+
+	auth := Authentication{Name: "partners", Auth_type: Mtls, Subjects: []string{"CN=*,O=Partner"},
+		Sans: []string{"*.partner.com"}, Fingerprints: []string{fingerprint(pinned)}}
+	if err := auth.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Authentication{Name: "empty", Auth_type: Mtls}).Validate(); err == nil {
+		t.Error("Validate() should fail without certificate patterns")
+	}
+	if err := (&Authentication{Name: "bad", Auth_type: Mtls, Fingerprints: []string{"AB:CD"}}).Validate(); err == nil {
+		t.Error("Validate() should fail for invalid fingerprint")
+	}
+
+	engine := gin.New()
+	engine.GET("/", RegisterMiddleware(auth), func(c *gin.Context) {
+		c.String(http.StatusOK, Claims(c)["common_name"])
+	})
+
+	serve := func(cert *x509.Certificate, verified bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		if cert != nil {
+			req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+			if verified {
+				req.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+			}
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := serve(nil, false); w.Code != http.StatusUnauthorized {
+		t.Error("Request without certificate should be rejected, got: ", w.Code)
+	}
+	if w := serve(trusted, true); w.Code != http.StatusOK || w.Body.String() != "billing" {
+		t.Error("Verified certificate matching patterns should be authorized, got: ", w.Code, w.Body.String())
+	}
+	if w := serve(trusted, false); w.Code != http.StatusForbidden {
+		t.Error("Patterns shouldn't authorize unverified certificate, got: ", w.Code)
+	}
+	if w := serve(pinned, false); w.Code != http.StatusOK {
+		t.Error("Pinned certificate should be authorized, got: ", w.Code)
+	}
+	if w := serve(newClientCertificate(t, "other", "other.com"), true); w.Code != http.StatusOK {
+		// subject pattern matches any common name of the Partner organization
+		t.Error("Certificate matching subject pattern should be authorized, got: ", w.Code)
+	}
+}
+
+func TestDecision(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	if name, decision := Decision(c); name != "" || decision != "" {
+		t.Error("Request without auth shouldn't have decision")
+	}
+
+	markAuth("public")(c)
+	if name, decision := Decision(c); name != "public" || decision != "denied" {
+		t.Error("Request without claims should be denied, got: " + decision)
+	}
+	c.Set(ClaimsKey, map[string]string{})
+	if _, decision := Decision(c); decision != "allowed" {
+		t.Error("Request with claims should be allowed, got: " + decision)
+	}
+}
@@ -2,10 +2,16 @@ package Authentication
 
 //TODO: better way for import
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"proxy/Forwarding"
 	"proxy/Logger"
+	"proxy/RequestID"
+	"proxy/Tracing"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/mitchellh/mapstructure"
@@ -19,12 +25,31 @@ type Authentication struct {
 	Auth_type string
 	Url_path string
 	Req_headers []string
+	// patterns of 'mtls' auth type, '*' matches any characters. Request is authorized if certificate matches any of them
+	Subjects []string
+	Sans []string
+	// SHA-256 fingerprints of allowed client certificates
+	Fingerprints []string
 }
 
 var lauth *Logger.Logger
 var AuthMiddlewares = map[string]*gin.RouterGroup{}
 
+// handlers that check request with auth by its name, endpoints put them after middlewares that should see
+// responses of denied requests, e.g. CORS
+var AuthHandlers = map[string]gin.HandlersChain{}
+
+// key of gin.Context value with claims returned by auth service
+const ClaimsKey = "auth_claims"
+
+// key of gin.Context value with name of auth that checks the request
+const NameKey = "auth_name"
+
 func (auth *Authentication) Validate() error {
+	if auth.Auth_type == Mtls {
+		return auth.validateMtls()
+	}
+
 	var rv string = ""
 	if auth.Name == "" {
 		rv += "name, "
@@ -40,7 +65,7 @@ func (auth *Authentication) Validate() error {
 	if auth.Auth_type == "" {
 		rv += "auth_type, "
 	} else if auth.Auth_type != "epp" {
-		return errors.New("Supported auth type is only 'epp'")
+		return errors.New("Supported auth types are 'epp' and 'mtls'")
 	}
 	if auth.Url_path == "" {
 		rv += "url_path, "
@@ -84,21 +109,26 @@ func RegisterAuth(cl *gin.Engine, file map[string]interface{}) {
 	for _,a := range auths {
 		middle := RegisterMiddleware(a)
 
+		handlers := gin.HandlersChain{markAuth(a.Name), middle}
 		tmp := cl.Group("/")
-		tmp.Use(middle)
+		tmp.Use(handlers...)
 		AuthMiddlewares[a.Name] = tmp
+		AuthHandlers[a.Name] = handlers
 	}
 }
 
 func DefaultAuthMiddleware(auth Authentication) gin.HandlerFunc {
 
 	return func(c *gin.Context) {
+		// entries are marked with id of the request
+		log := lauth.WithContext(c.Request.Context())
 
 		//init client and request
 		cl := http.Client{}
 		req, err := http.NewRequest("GET", auth.Auth_scheme + "://" + auth.Auth_addr + auth.Url_path, nil)
 		if err != nil {
-			lauth.Error(map[string]string{"Error": err.Error()}, "Error while creating new 'Request'")
+			log.Error(map[string]string{"Error": err.Error()}, "Error while creating new 'Request'")
+			decisions.With(auth.Name, outcomeError).Inc()
 			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
@@ -108,40 +138,116 @@ func DefaultAuthMiddleware(auth Authentication) gin.HandlerFunc {
 			if v := c.Request.Header.Get(h); len(v) != 0 {
 				req.Header.Add(h, v)
 			} else {
-				lauth.Error(
+				log.Error(
 					map[string]string{"Missing header": h, "Required headers": strings.Join(auth.Req_headers[:], ",")},
 				"Missing required header")
+				decisions.With(auth.Name, outcomeDenied).Inc()
 				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Missing required header: " + h})
 				return
 			}
 		}
 
-		// send request to auth server
+		// auth service sees the same client address as upstream
+		req.Header.Set("X-Forwarded-For", Forwarding.ClientIP(c.Request))
+		// auth service logs can be correlated with proxy and upstream logs
+		if id := RequestID.FromContext(c.Request.Context()); id != "" {
+			req.Header.Set(RequestID.Global.Header, id)
+		}
+
+		// send request to auth server, it's traced as child of the request span
+		span, req := Tracing.StartClient(c.Request.Context(), req, "auth " + auth.Name)
+		span.SetAttribute("auth.name", auth.Name)
+		start := time.Now()
 		resp, err := cl.Do(req)
+		subrequestDuration.With(auth.Name).Observe(time.Since(start).Seconds())
 		if err != nil {
-			lauth.Error(map[string]string{"Error": err.Error()}, "Error when trying to send auth request")
+			span.SetError(err.Error())
+			span.End()
+			log.Error(map[string]string{"Error": err.Error()}, "Error when trying to send auth request")
+			decisions.With(auth.Name, outcomeError).Inc()
 			code := http.StatusInternalServerError
 			if resp != nil { code = resp.StatusCode }
 			c.AbortWithStatusJSON(code, gin.H{"error": err.Error()})
 			return
-		} else if resp.StatusCode >= 300 { //need to check status not only for 200
+		}
+		span.SetStatusCode(resp.StatusCode)
+		span.End()
+		if resp.StatusCode >= 300 { //need to check status not only for 200
 
 			bodyB, _ := ioutil.ReadAll(resp.Body)
-			lauth.Error(map[string]string{"Status": resp.Status, "Response": string(bodyB)},
+			log.Error(map[string]string{"Status": resp.Status, "Response": string(bodyB)},
 			"Unsuccessful code return form auth service")
+			decisions.With(auth.Name, outcomeDenied).Inc()
 			c.AbortWithStatusJSON(resp.StatusCode, gin.H{"Status": resp.Status, "body": resp.Body})
 			return
 		}
 
 		//process if authorized
+		decisions.With(auth.Name, outcomeAllowed).Inc()
+		c.Set(ClaimsKey, readClaims(resp))
+		resp.Body.Close()
 		c.Next()
 	}
 }
 
+// reads claims from auth service response. Claims are top level scalar values of json object in response body
+func readClaims(resp *http.Response) map[string]string {
+	claims := map[string]string{}
+
+	var body map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return claims
+	}
+	for k, v := range body {
+		switch val := v.(type) {
+		case string:
+			claims[k] = val
+		case float64, bool:
+			claims[k] = fmt.Sprint(val)
+		}
+	}
+
+	return claims
+}
+
+// returns claims of the authorized request, empty map if request wasn't authorized by auth service
+func Claims(c *gin.Context) map[string]string {
+	if v, ok := c.Get(ClaimsKey); ok {
+		if claims, ok := v.(map[string]string); ok {
+			return claims
+		}
+	}
+	return map[string]string{}
+}
+
+// remembers name of auth that checks the request, so its decision can be reported
+func markAuth(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(NameKey, name)
+	}
+}
+
+// Decision returns name of auth that checked the request and its decision: 'allowed' or 'denied'.
+// Empty values are returned if request wasn't checked
+func Decision(c *gin.Context) (string, string) {
+	v, ok := c.Get(NameKey)
+	if !ok {
+		return "", ""
+	}
+	name, _ := v.(string)
+	if _, allowed := c.Get(ClaimsKey); allowed {
+		return name, "allowed"
+	}
+	return name, "denied"
+}
+
 func RegisterMiddleware(auth Authentication) gin.HandlerFunc {
 	// currently supported only type 'endpoint per permission
 	if auth.Auth_type == "epp" {
 		return DefaultAuthMiddleware(auth)
 	}
+	if auth.Auth_type == Mtls {
+		return MtlsMiddleware(auth)
+	}
 	panic("Unsupported auth_type is used: " + auth.Auth_type )
 }
\ No newline at end of file
@@ -0,0 +1,17 @@
+package Authentication
+
+import "proxy/Metrics"
+
+// outcomes of auth checks: request is allowed, denied or couldn't be checked because auth service failed
+const (
+	outcomeAllowed = "allowed"
+	outcomeDenied  = "denied"
+	outcomeError   = "error"
+)
+
+var (
+	decisions = Metrics.NewCounter("proxy_auth_decisions_total",
+		"Requests checked by auth, outcome is allowed, denied or error.", "auth", "outcome")
+	subrequestDuration = Metrics.NewHistogram("proxy_auth_request_duration_seconds",
+		"Time auth service took to answer subrequest.", Metrics.DefaultBuckets, "auth")
+)
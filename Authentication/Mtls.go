@@ -0,0 +1,130 @@
+package Authentication
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// auth type authorizing requests by client certificate presented on TLS handshake
+const Mtls = "mtls"
+
+func (auth *Authentication) validateMtls() error {
+	if auth.Name == "" {
+		return errors.New("Missing required fields: name")
+	}
+	if len(auth.Subjects) == 0 && len(auth.Sans) == 0 && len(auth.Fingerprints) == 0 {
+		return errors.New("'mtls' auth " + auth.Name + " should contain at least one of: subjects, sans, fingerprints")
+	}
+	for _, f := range auth.Fingerprints {
+		if len(normalizeFingerprint(f)) != sha256.Size*2 {
+			return errors.New("Invalid SHA-256 fingerprint in auth " + auth.Name + ": " + f)
+		}
+	}
+	return nil
+}
+
+// fingerprints are compared in lower case hex without separators
+func normalizeFingerprint(f string) string {
+	return strings.ToLower(strings.Replace(f, ":", "", -1))
+}
+
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// compiles patterns where '*' matches any sequence of characters, matching is case insensitive
+func compilePatterns(patterns []string) []*regexp.Regexp {
+	var rv []*regexp.Regexp
+	for _, p := range patterns {
+		parts := strings.Split(p, "*")
+		for i := range parts {
+			parts[i] = regexp.QuoteMeta(parts[i])
+		}
+		rv = append(rv, regexp.MustCompile("(?i)^"+strings.Join(parts, ".*")+"$"))
+	}
+	return rv
+}
+
+func matchAny(patterns []*regexp.Regexp, values []string) bool {
+	for _, p := range patterns {
+		for _, v := range values {
+			if p.MatchString(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// subject is matched both by its common name and by full distinguished name, e.g. 'CN=client,O=Partner'
+func subjectNames(cert *x509.Certificate) []string {
+	return []string{cert.Subject.CommonName, cert.Subject.String()}
+}
+
+func sanNames(cert *x509.Certificate) []string {
+	rv := append([]string{}, cert.DNSNames...)
+	rv = append(rv, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		rv = append(rv, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		rv = append(rv, uri.String())
+	}
+	return rv
+}
+
+// returns leaf client certificate of the request and whether it was verified by listener CA
+func peerCertificate(req *http.Request) (*x509.Certificate, bool) {
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return nil, false
+	}
+	return req.TLS.PeerCertificates[0], len(req.TLS.VerifiedChains) > 0
+}
+
+// MtlsMiddleware authorizes requests by client certificate. Subject and SAN patterns are applied only to certificates
+// verified by listener CA, while fingerprints pin exact certificates and are applied to any presented certificate.
+// Certificate details are available as claims: subject, common_name, serial, fingerprint
+func MtlsMiddleware(auth Authentication) gin.HandlerFunc {
+	subjects, sans := compilePatterns(auth.Subjects), compilePatterns(auth.Sans)
+	fingerprints := map[string]bool{}
+	for _, f := range auth.Fingerprints {
+		fingerprints[normalizeFingerprint(f)] = true
+	}
+
+	return func(c *gin.Context) {
+		log := lauth.WithContext(c.Request.Context())
+		cert, verified := peerCertificate(c.Request)
+		if cert == nil {
+			log.Error(map[string]string{"Auth": auth.Name}, "Request without client certificate")
+			decisions.With(auth.Name, outcomeDenied).Inc()
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Client certificate is required"})
+			return
+		}
+
+		fp := fingerprint(cert)
+		if !fingerprints[fp] && !(verified && (matchAny(subjects, subjectNames(cert)) || matchAny(sans, sanNames(cert)))) {
+			log.Error(map[string]string{"Auth": auth.Name, "Subject": cert.Subject.String(), "Fingerprint": fp},
+				"Client certificate isn't allowed")
+			decisions.With(auth.Name, outcomeDenied).Inc()
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "Client certificate isn't allowed"})
+			return
+		}
+
+		decisions.With(auth.Name, outcomeAllowed).Inc()
+		c.Set(ClaimsKey, map[string]string{
+			"subject":     cert.Subject.String(),
+			"common_name": cert.Subject.CommonName,
+			"serial":      cert.SerialNumber.String(),
+			"fingerprint": fp,
+		})
+		c.Next()
+	}
+}
@@ -0,0 +1,250 @@
+package Cache
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+const (
+	MemoryStore = "memory"
+	DiskStore   = "disk"
+
+	// default limits of the store
+	defaultMaxEntries    = 10000
+	defaultMaxSize       = 256 << 20
+	defaultMaxObjectSize = 1 << 20
+
+	// values of X-Cache header
+	Hit         = "HIT"
+	Miss        = "MISS"
+	Stale       = "STALE"
+	Revalidated = "REVALIDATED"
+	Bypass      = "BYPASS"
+)
+
+// store shared by all cached endpoints
+var DefaultStore Store
+
+var settings *Settings
+
+// global cache settings that are read from 'Cache' section
+type Settings struct {
+	// type of the store: memory or disk
+	Store string
+	// max amount of entries in memory store
+	Max_entries int
+	// max summary size of entries in bytes
+	Max_size int
+	// directory where disk store keeps entries
+	Disk_path string
+	// responses bigger than this amount of bytes aren't cached
+	Max_object_size int
+	// upstream response header with space or comma separated list of tags (surrogate keys) used for purging
+	Tag_header string
+}
+
+// per endpoint cache settings
+type EndpointSettings struct {
+	Enabled bool
+	// freshness lifetime in seconds used for responses without explicit expiration time, 0 means not cache them
+	Default_ttl int
+	// request headers which values are part of the cache key
+	Key_headers []string
+	// cookies which values are part of the cache key
+	Key_cookies []string
+	// query parameters which aren't part of the cache key, '*' excludes whole query
+	Ignore_query []string
+}
+
+func (s *Settings) Validate() error {
+	if s.Store == "" {
+		s.Store = MemoryStore
+	} else if s.Store != MemoryStore && s.Store != DiskStore {
+		return errors.New("Unsupported cache store: " + s.Store + ". Supported are 'memory' and 'disk'")
+	}
+
+	if s.Store == DiskStore && s.Disk_path == "" {
+		return errors.New("'disk_path' should be specified for disk cache store")
+	}
+
+	if s.Max_entries < 0 || s.Max_size < 0 || s.Max_object_size < 0 {
+		return errors.New("Cache limits can't be negative")
+	}
+	if s.Max_entries == 0 {
+		s.Max_entries = defaultMaxEntries
+	}
+	if s.Max_size == 0 {
+		s.Max_size = defaultMaxSize
+	}
+	if s.Max_object_size == 0 {
+		s.Max_object_size = defaultMaxObjectSize
+	}
+
+	return nil
+}
+
+func (s *EndpointSettings) Validate() error {
+	if s.Default_ttl < 0 {
+		return errors.New("Cache 'default_ttl' can't be negative")
+	}
+	return nil
+}
+
+// Read cache settings from parsed json value and validates it
+func ReadCacheFromFile(v interface{}) *Settings {
+	var s Settings
+	if err := mapstructure.Decode(v, &s); err != nil {
+		panic("Can't decode cache settings. Error: " + err.Error())
+	}
+	if err := s.Validate(); err != nil {
+		panic(err.Error())
+	}
+
+	return &s
+}
+
+// creates store described by settings
+func NewStore(s *Settings) Store {
+	if s.Store == DiskStore {
+		store, err := NewDiskStore(s.Disk_path, s.Max_entries, int64(s.Max_size))
+		if err != nil {
+			panic("Can't create disk cache store. Error: " + err.Error())
+		}
+		return store
+	}
+	return NewMemoryStore(s.Max_entries, int64(s.Max_size))
+}
+
+// Inits cache store from 'Cache' section, section is optional.
+// Memory store with default limits is used by cached endpoints if section is missing
+func InitCache(file map[string]interface{}) {
+	if v, exist := file["Cache"]; exist {
+		settings = ReadCacheFromFile(v)
+	} else {
+		settings = &Settings{}
+		settings.Validate()
+	}
+
+	DefaultStore = NewStore(settings)
+}
+
+// returns store that should be used by endpoints, creates default one if cache wasn't init
+func getStore() Store {
+	if DefaultStore == nil {
+		InitCache(map[string]interface{}{})
+	}
+	return DefaultStore
+}
+
+// builds primary cache key of the request according to endpoint settings
+func (s *EndpointSettings) key(req *http.Request) string {
+	var b strings.Builder
+	b.WriteString(req.Host)
+	b.WriteString(req.URL.Path)
+
+	if query := s.query(req.URL); query != "" {
+		b.WriteString("?")
+		b.WriteString(query)
+	}
+
+	for _, h := range s.Key_headers {
+		b.WriteString("|" + http.CanonicalHeaderKey(h) + "=" + strings.Join(req.Header.Values(h), ","))
+	}
+	for _, name := range s.Key_cookies {
+		value := ""
+		if cookie, err := req.Cookie(name); err == nil {
+			value = cookie.Value
+		}
+		b.WriteString("|cookie:" + name + "=" + value)
+	}
+
+	return b.String()
+}
+
+// returns query of url in canonical form without ignored parameters
+func (s *EndpointSettings) query(u *url.URL) string {
+	values := u.Query()
+	for _, p := range s.Ignore_query {
+		if p == "*" {
+			return ""
+		}
+		values.Del(p)
+	}
+	// Encode sorts parameters by key
+	for _, v := range values {
+		sort.Strings(v)
+	}
+	return values.Encode()
+}
+
+// key of the response variant selected by request headers listed in Vary
+func variantKey(primary string, vary []string, req *http.Request) string {
+	var b strings.Builder
+	b.WriteString(primary)
+	for _, h := range vary {
+		b.WriteString("|vary:" + h + "=" + strings.Join(req.Header.Values(h), ","))
+	}
+	return b.String()
+}
+
+// returns list of canonical header names from Vary header, nil if response doesn't vary
+func varyHeaders(h http.Header) []string {
+	var rv []string
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if name != "" {
+				rv = append(rv, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+// Entry is a stored response. Entry without body and with Vary only
+// points to variants that are stored under variant keys
+type Entry struct {
+	Key    string
+	Host   string
+	Url    string
+	Status int
+	Header http.Header
+	Body   []byte
+	// time when response was received
+	Stored time.Time
+	// list of headers that select variant, set only for variants pointer
+	Vary []string
+	// surrogate keys taken from 'tag_header' of the response
+	Tags []string
+}
+
+// approximate amount of bytes occupied by entry
+func (e *Entry) Size() int64 {
+	size := len(e.Key) + len(e.Url) + len(e.Body)
+	for k, v := range e.Header {
+		size += len(k)
+		for _, s := range v {
+			size += len(s)
+		}
+	}
+	return int64(size)
+}
+
+// copy of the entry without body
+func (e *Entry) meta() *Entry {
+	m := *e
+	m.Body = nil
+	return &m
+}
+
+// parses list of tags from header value
+func parseTags(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool { return r == ' ' || r == ',' })
+}
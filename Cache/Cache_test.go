@@ -0,0 +1,401 @@
+package Cache
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestHandler(s *EndpointSettings) *Handler {
+	settings = &Settings{}
+	settings.Validate()
+	DefaultStore = NewMemoryStore(settings.Max_entries, int64(settings.Max_size))
+	return New(s)
+}
+
+func serve(h *Handler, upstream http.HandlerFunc, method string, url string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.Serve(w, req, upstream)
+	return w
+}
+
+func TestSettings_Validate(t *testing.T) {
+	s := Settings{}
+	if err := s.Validate(); err != nil {
+		t.Error(err)
+	}
+	if s.Store != MemoryStore || s.Max_entries == 0 || s.Max_size == 0 || s.Max_object_size == 0 {
+		t.Error("Validate() should fill default values")
+	}
+
+	s.Store = "redis"
+	if err := s.Validate(); err == nil {
+		t.Error("Validate() should fail for unsupported store")
+	}
+
+	s.Store = DiskStore
+	if err := s.Validate(); err == nil {
+		t.Error("Validate() should fail if disk store has no 'disk_path'")
+	}
+
+	s.Disk_path = "cache"
+	s.Max_size = -5
+	if err := s.Validate(); err == nil {
+		t.Error("Validate() should fail for negative limits")
+	}
+
+	e := EndpointSettings{Enabled: true, Default_ttl: -1}
+	if err := e.Validate(); err == nil {
+		t.Error("Validate() should fail for negative 'default_ttl'")
+	}
+}
+
+func TestCacheHitAndMiss(t *testing.T) {
+	h := newTestHandler(&EndpointSettings{Enabled: true, Ignore_query: []string{"utm"}})
+	var calls int32
+	upstream := func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("payload"))
+	}
+
+	w := serve(h, upstream, "GET", "/items?b=2&a=1", nil)
+	if w.Header().Get("X-Cache") != Miss || w.Body.String() != "payload" {
+		t.Error("First request should be a miss")
+	}
+
+	w = serve(h, upstream, "GET", "/items?a=1&b=2&utm=x", nil)
+	if w.Header().Get("X-Cache") != Hit || w.Body.String() != "payload" {
+		t.Error("Second request should be served from the cache")
+	}
+	if w.Header().Get("Age") == "" {
+		t.Error("Age header should be set for cached responses")
+	}
+
+	w = serve(h, upstream, "GET", "/items?a=1&b=2", map[string]string{"If-None-Match": `"v1"`})
+	if w.Code != http.StatusNotModified {
+		t.Error("Conditional request matching cached ETag should get 304")
+	}
+
+	w = serve(h, upstream, "HEAD", "/items?a=1&b=2", nil)
+	if w.Header().Get("X-Cache") != Hit || w.Body.Len() != 0 {
+		t.Error("HEAD request should be served from the cache without body")
+	}
+
+	if calls != 1 {
+		t.Error("Upstream should be called once, called: ", calls)
+	}
+
+	// unsafe method invalidates entry
+	serve(h, upstream, "POST", "/items?a=1&b=2", nil)
+	w = serve(h, upstream, "GET", "/items?a=1&b=2", nil)
+	if w.Header().Get("X-Cache") != Miss {
+		t.Error("Entry should be invalidated after successful POST")
+	}
+}
+
+func TestRequestIDNotCached(t *testing.T) {
+	h := newTestHandler(&EndpointSettings{Enabled: true})
+	upstream := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("payload"))
+	}
+	// request id is set by the proxy before the cache is reached
+	serveWithID := func(id string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		w.Header().Set("X-Request-Id", id)
+		h.Serve(w, httptest.NewRequest("GET", "/items", nil), http.HandlerFunc(upstream))
+		return w
+	}
+
+	if w := serveWithID("first"); w.Header().Get("X-Cache") != Miss || w.Header().Get("X-Request-Id") != "first" {
+		t.Error("Miss should keep id of its request: ", w.Header())
+	}
+	if w := serveWithID("second"); w.Header().Get("X-Cache") != Hit || w.Header().Get("X-Request-Id") != "second" {
+		t.Error("Hit should keep id of its request: ", w.Header())
+	}
+	if e, _ := h.store.Get(h.settings.key(httptest.NewRequest("GET", "/items", nil))); e == nil ||
+		e.Header.Get("X-Request-Id") != "" {
+		t.Error("Request id shouldn't be stored")
+	}
+}
+
+func TestNotStorable(t *testing.T) {
+	h := newTestHandler(&EndpointSettings{Enabled: true})
+	var calls int32
+	cacheControl := "no-store"
+	upstream := func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", cacheControl)
+		w.Write([]byte("payload"))
+	}
+
+	serve(h, upstream, "GET", "/a", nil)
+	serve(h, upstream, "GET", "/a", nil)
+	if calls != 2 {
+		t.Error("Response with 'no-store' shouldn't be cached")
+	}
+
+	cacheControl = "private, max-age=60"
+	serve(h, upstream, "GET", "/b", nil)
+	serve(h, upstream, "GET", "/b", nil)
+	if calls != 4 {
+		t.Error("Private response shouldn't be cached")
+	}
+
+	cacheControl = "max-age=60"
+	serve(h, upstream, "GET", "/c", map[string]string{"Authorization": "token"})
+	serve(h, upstream, "GET", "/c", map[string]string{"Authorization": "token"})
+	if calls != 6 {
+		t.Error("Response to authorized request shouldn't be cached without 'public'")
+	}
+}
+
+func TestRevalidation(t *testing.T) {
+	h := newTestHandler(&EndpointSettings{Enabled: true})
+	var calls int32
+	fail := false
+	upstream := func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if fail {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=0, stale-if-error=60")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("payload"))
+	}
+
+	serve(h, upstream, "GET", "/a", nil)
+	w := serve(h, upstream, "GET", "/a", nil)
+	if w.Header().Get("X-Cache") != Revalidated || w.Body.String() != "payload" || w.Code != http.StatusOK {
+		t.Error("Stale entry should be revalidated with upstream")
+	}
+
+	fail = true
+	w = serve(h, upstream, "GET", "/a", nil)
+	if w.Header().Get("X-Cache") != Stale || w.Body.String() != "payload" {
+		t.Error("Stale entry should be served when upstream fails and 'stale-if-error' allows it")
+	}
+}
+
+func TestRevalidationObjectSize(t *testing.T) {
+	h := newTestHandler(&EndpointSettings{Enabled: true})
+	h.maxObjectSize = 8
+	upstream := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=0")
+		w.Header().Set("ETag", `"v2"`)
+		if r.Header.Get("If-None-Match") == "" {
+			w.Header().Set("ETag", `"v1"`)
+			w.Write([]byte("small"))
+			return
+		}
+		w.Write([]byte("large "))
+		w.Write([]byte("payload"))
+	}
+
+	serve(h, upstream, "GET", "/a", nil)
+	w := serve(h, upstream, "GET", "/a", nil)
+	if w.Header().Get("X-Cache") != Miss || w.Header().Get("ETag") != `"v2"` || w.Body.String() != "large payload" {
+		t.Error("Changed response should be passed to the client: ", w.Header(), w.Body.String())
+	}
+	req := httptest.NewRequest("GET", "/a", nil)
+	if e, ok := h.lookup(h.settings.key(req), req); !ok || string(e.Body) != "small" {
+		t.Error("Response larger than object size shouldn't be stored")
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	h := newTestHandler(&EndpointSettings{Enabled: true})
+	var calls int32
+	upstream := func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "max-age=0, stale-while-revalidate=60")
+		w.Header().Set("Last-Modified", "Mon, 19 Oct 2026 10:00:00 GMT")
+		if n == 1 {
+			w.Write([]byte("old"))
+		} else {
+			w.Write([]byte("new"))
+		}
+	}
+
+	serve(h, upstream, "GET", "/a", nil)
+	w := serve(h, upstream, "GET", "/a", nil)
+	if w.Header().Get("X-Cache") != Stale || w.Body.String() != "old" {
+		t.Error("Stale entry should be served while being revalidated")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if e, ok := h.store.Get(h.settings.key(httptest.NewRequest("GET", "/a", nil))); ok && string(e.Body) == "new" {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("Entry should be updated by background revalidation")
+}
+
+func TestVary(t *testing.T) {
+	h := newTestHandler(&EndpointSettings{Enabled: true})
+	upstream := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		w.Write([]byte(r.Header.Get("Accept-Language")))
+	}
+
+	serve(h, upstream, "GET", "/a", map[string]string{"Accept-Language": "en"})
+	serve(h, upstream, "GET", "/a", map[string]string{"Accept-Language": "uk"})
+
+	w := serve(h, upstream, "GET", "/a", map[string]string{"Accept-Language": "en"})
+	if w.Header().Get("X-Cache") != Hit || w.Body.String() != "en" {
+		t.Error("Variant for 'en' should be served from the cache")
+	}
+	w = serve(h, upstream, "GET", "/a", map[string]string{"Accept-Language": "uk"})
+	if w.Header().Get("X-Cache") != Hit || w.Body.String() != "uk" {
+		t.Error("Variant for 'uk' should be served from the cache")
+	}
+
+	// all variants are invalidated, not only the Vary entry
+	serve(h, upstream, "POST", "/a", nil)
+	serve(h, upstream, "GET", "/a", map[string]string{"Accept-Language": "uk"})
+	w = serve(h, upstream, "GET", "/a", map[string]string{"Accept-Language": "en"})
+	if w.Header().Get("X-Cache") != Miss {
+		t.Error("Variant stored before POST shouldn't be served")
+	}
+}
+
+func TestRequestCoalescing(t *testing.T) {
+	h := newTestHandler(&EndpointSettings{Enabled: true})
+	var calls int32
+	release := make(chan struct{})
+	upstream := func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("payload"))
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if w := serve(h, upstream, "GET", "/a", nil); w.Body.String() != "payload" {
+				t.Error("Every coalesced request should get the response")
+			}
+		}()
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Error("Concurrent misses should be coalesced into one upstream request, made: ", calls)
+	}
+}
+
+func TestDiskStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewDiskStore(dir, 2, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := http.Header{"Content-Type": []string{"text/plain"}}
+	store.Set("a", &Entry{Key: "a", Status: 200, Header: header, Body: []byte("first"), Stored: time.Now()})
+	store.Set("b", &Entry{Key: "b", Status: 200, Header: header, Body: []byte("second"), Stored: time.Now()})
+
+	// entries should survive restart
+	store, err = NewDiskStore(dir, 2, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, ok := store.Get("a"); !ok || string(e.Body) != "first" || e.Header.Get("Content-Type") != "text/plain" {
+		t.Error("Entry should be restored from disk")
+	}
+
+	// 'b' is least recently used now
+	store.Set("c", &Entry{Key: "c", Status: 200, Header: header, Body: []byte("third"), Stored: time.Now()})
+	if _, ok := store.Get("b"); ok {
+		t.Error("Least recently used entry should be evicted")
+	}
+
+	store.Delete("a")
+	if _, ok := store.Get("a"); ok {
+		t.Error("Deleted entry shouldn't be returned")
+	}
+}
+
+func TestPurge(t *testing.T) {
+	h := newTestHandler(&EndpointSettings{Enabled: true})
+	settings.Tag_header = "Surrogate-Key"
+	upstream := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Surrogate-Key", "all "+r.URL.Query().Get("tag"))
+		w.Write([]byte("payload"))
+	}
+
+	serve(h, upstream, "GET", "/news/1?tag=news", nil)
+	serve(h, upstream, "GET", "/news/2?tag=news", nil)
+	serve(h, upstream, "GET", "/sport/1?tag=sport", nil)
+	serve(h, upstream, "GET", "/sport/2?tag=sport", nil)
+
+	if entries := Entries(h.store, "/news", ""); len(entries) != 2 {
+		t.Error("Two entries should be listed with '/news' prefix")
+	}
+	if entries := Entries(h.store, "", "sport"); len(entries) != 2 || entries[0].Size == 0 {
+		t.Error("Two entries with size should be listed with 'sport' tag")
+	}
+
+	if _, err := Purge(h.store, &PurgeRequest{Url: "/a", Tag: "b"}); err == nil {
+		t.Error("Purge should fail if more then one criteria specified")
+	}
+
+	if n, _ := Purge(h.store, &PurgeRequest{Url: "/news/1?tag=news"}); n != 1 {
+		t.Error("Purge by url should remove one entry, removed: ", n)
+	}
+	if n, _ := Purge(h.store, &PurgeRequest{Prefix: "/news"}); n != 1 {
+		t.Error("Purge by prefix should remove one entry, removed: ", n)
+	}
+	if n, _ := Purge(h.store, &PurgeRequest{Tag: "sport", Host: "other.com"}); n != 0 {
+		t.Error("Purge shouldn't remove entries of other host, removed: ", n)
+	}
+	if n, _ := Purge(h.store, &PurgeRequest{Tag: "sport"}); n != 2 {
+		t.Error("Purge by tag should remove two entries, removed: ", n)
+	}
+
+	varying := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Vary", "Accept-Language")
+		upstream(w, r)
+	}
+	serve(h, varying, "GET", "/weather?tag=weather", map[string]string{"Accept-Language": "en"})
+	serve(h, varying, "GET", "/weather?tag=weather", map[string]string{"Accept-Language": "de"})
+	if n, _ := Purge(h.store, &PurgeRequest{Tag: "weather"}); n != 2 || len(Entries(h.store, "/weather", "")) != 0 {
+		t.Error("Purge by tag should remove variants and their Vary entry, removed: ", n)
+	}
+
+	serve(h, upstream, "GET", "/news/1?tag=news", nil)
+	serve(h, varying, "GET", "/weather?tag=weather", map[string]string{"Accept-Language": "en"})
+	if n, _ := Purge(h.store, &PurgeRequest{All: true}); n != 2 || len(Entries(h.store, "", "")) != 0 {
+		t.Error("Purge of all entries should clear the store")
+	}
+}
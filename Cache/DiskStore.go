@@ -0,0 +1,179 @@
+package Cache
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const diskEntryExt = ".entry"
+
+// diskStore keeps every entry in a separate file under dir.
+// Index of keys with entries without body is kept in memory and restored from files on start
+type diskStore struct {
+	mu    sync.Mutex
+	dir   string
+	index *lru
+}
+
+func NewDiskStore(dir string, maxEntries int, maxSize int64) (Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	s := &diskStore{dir: dir}
+	s.index = newLru(maxEntries, maxSize, func(item *lruItem) {
+		os.Remove(s.path(item.key))
+	})
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// restores index from files that are already in dir, least recently modified files are evicted first
+func (s *diskStore) load() error {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), diskEntryExt) {
+			continue
+		}
+
+		path := filepath.Join(s.dir, f.Name())
+		e, err := readEntry(path)
+		if err != nil || path != s.path(e.Key) {
+			os.Remove(path)
+			continue
+		}
+		s.index.add(&lruItem{key: e.Key, size: e.Size(), entry: e.meta()})
+	}
+
+	return nil
+}
+
+func (s *diskStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+diskEntryExt)
+}
+
+func readEntry(path string) (*Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var e Entry
+	if err := gob.NewDecoder(f).Decode(&e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// writes entry to temporary file and moves it to the final place, so readers never see partial entries
+func writeEntry(path string, e *Entry) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "tmp-")
+	if err != nil {
+		return err
+	}
+
+	if err = gob.NewEncoder(tmp).Encode(e); err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func (s *diskStore) Get(key string) (*Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.index.get(key); !ok {
+		return nil, false
+	}
+
+	e, err := readEntry(s.path(key))
+	if err != nil {
+		s.index.remove(key)
+		return nil, false
+	}
+	return e, true
+}
+
+func (s *diskStore) Set(key string, e *Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := writeEntry(s.path(key), e); err != nil {
+		s.index.remove(key)
+		return
+	}
+	s.index.add(&lruItem{key: key, size: e.Size(), entry: e.meta()})
+}
+
+func (s *diskStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.index.remove(key); ok {
+		os.Remove(s.path(key))
+	}
+}
+
+func (s *diskStore) Range(f func(e *Entry, size int64) bool) {
+	s.mu.Lock()
+	var items []*lruItem
+	s.index.each(func(item *lruItem) bool {
+		items = append(items, item)
+		return true
+	})
+	s.mu.Unlock()
+
+	for _, item := range items {
+		if !f(item.entry, item.size) {
+			return
+		}
+	}
+}
+
+func (s *diskStore) DeleteFunc(f func(e *Entry) bool) []*Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rv []*Entry
+	for _, item := range s.index.removeFunc(f) {
+		os.Remove(s.path(item.key))
+		rv = append(rv, item.entry)
+	}
+	return rv
+}
+
+func (s *diskStore) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.index.each(func(item *lruItem) bool {
+		os.Remove(s.path(item.key))
+		return true
+	})
+	s.index = newLru(s.index.maxEntries, s.index.maxSize, s.index.onEvict)
+}
@@ -0,0 +1,141 @@
+package Cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parses Cache-Control header to map of directives with lower-cased names
+func parseCacheControl(h http.Header) map[string]string {
+	rv := map[string]string{}
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			d = strings.TrimSpace(d)
+			if d == "" {
+				continue
+			}
+			name, value := d, ""
+			if i := strings.Index(d, "="); i != -1 {
+				name, value = d[:i], strings.Trim(d[i+1:], "\"")
+			}
+			rv[strings.ToLower(name)] = value
+		}
+	}
+	return rv
+}
+
+// returns value of directive in seconds, false if directive is missing or invalid
+func directiveSeconds(cc map[string]string, name string) (time.Duration, bool) {
+	v, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	sec, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || sec < 0 {
+		return 0, false
+	}
+	return time.Duration(sec) * time.Second, true
+}
+
+// statuses that can be cached with heuristic freshness (RFC 9110 15.1)
+var heuristicStatuses = map[int]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true, 308: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// returns freshness lifetime of the response and whether it was specified explicitly by upstream.
+// defaultTtl is used for heuristically cacheable responses without explicit expiration
+func freshnessLifetime(status int, h http.Header, stored time.Time, defaultTtl time.Duration) (time.Duration, bool) {
+	cc := parseCacheControl(h)
+	// shared cache should prefer s-maxage
+	if v, ok := directiveSeconds(cc, "s-maxage"); ok {
+		return v, true
+	}
+	if v, ok := directiveSeconds(cc, "max-age"); ok {
+		return v, true
+	}
+	if expires := h.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			// invalid Expires means already expired
+			return 0, true
+		}
+		date := stored
+		if d, err := http.ParseTime(h.Get("Date")); err == nil {
+			date = d
+		}
+		if t.Before(date) {
+			return 0, true
+		}
+		return t.Sub(date), true
+	}
+
+	if heuristicStatuses[status] && defaultTtl > 0 {
+		return defaultTtl, false
+	}
+	return 0, false
+}
+
+// returns current age of the entry (RFC 9111 4.2.3)
+func (e *Entry) age(now time.Time) time.Duration {
+	var initial time.Duration
+	if d, err := http.ParseTime(e.Header.Get("Date")); err == nil && e.Stored.After(d) {
+		initial = e.Stored.Sub(d)
+	}
+	if sec, err := strconv.ParseInt(e.Header.Get("Age"), 10, 64); err == nil {
+		if v := time.Duration(sec) * time.Second; v > initial {
+			initial = v
+		}
+	}
+	return initial + now.Sub(e.Stored)
+}
+
+// checks whether response can be stored by a shared cache (RFC 9111 3)
+func storable(req *http.Request, status int, h http.Header, defaultTtl time.Duration) bool {
+	if req.Method != http.MethodGet || status < 200 || status == http.StatusPartialContent ||
+		status == http.StatusNotModified {
+		return false
+	}
+	if _, ok := parseCacheControl(req.Header)["no-store"]; ok {
+		return false
+	}
+
+	cc := parseCacheControl(h)
+	if _, ok := cc["no-store"]; ok {
+		return false
+	}
+	if _, ok := cc["private"]; ok {
+		return false
+	}
+	// responses setting cookies are personal even if upstream forgot to mark them private
+	if h.Get("Set-Cookie") != "" {
+		return false
+	}
+	for _, v := range varyHeaders(h) {
+		if v == "*" {
+			return false
+		}
+	}
+
+	if req.Header.Get("Authorization") != "" {
+		_, public := cc["public"]
+		_, sMaxAge := cc["s-maxage"]
+		_, mustRevalidate := cc["must-revalidate"]
+		if !public && !sMaxAge && !mustRevalidate {
+			return false
+		}
+	}
+
+	lifetime, explicit := freshnessLifetime(status, h, time.Now(), defaultTtl)
+	if !explicit && !heuristicStatuses[status] {
+		return false
+	}
+	// response without lifetime is still useful if it can be revalidated
+	return lifetime > 0 || hasValidators(h)
+}
+
+func hasValidators(h http.Header) bool {
+	return h.Get("ETag") != "" || h.Get("Last-Modified") != ""
+}
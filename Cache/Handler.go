@@ -0,0 +1,465 @@
+package Cache
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"proxy/RequestID"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Handler serves requests of one endpoint from the cache and passes misses to upstream
+type Handler struct {
+	settings      *EndpointSettings
+	store         Store
+	maxObjectSize int
+
+	mu sync.Mutex
+	// requests to upstream that are in progress by primary key
+	inflight map[string]chan struct{}
+}
+
+// Creates cache handler for endpoint that uses shared store
+func New(s *EndpointSettings) *Handler {
+	store := getStore()
+	return &Handler{settings: s, store: store, maxObjectSize: settings.Max_object_size,
+		inflight: map[string]chan struct{}{}}
+}
+
+func (h *Handler) defaultTtl() time.Duration {
+	return time.Duration(h.settings.Default_ttl) * time.Second
+}
+
+// Serve responds from the cache if possible, otherwise passes request to upstream and stores the response
+func (h *Handler) Serve(w http.ResponseWriter, req *http.Request, upstream http.Handler) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		h.serveUnsafe(w, req, upstream)
+		return
+	}
+
+	reqCC := parseCacheControl(req.Header)
+	if _, ok := reqCC["no-store"]; ok {
+		w.Header().Set("X-Cache", Bypass)
+		upstream.ServeHTTP(w, req)
+		return
+	}
+
+	primary := h.settings.key(req)
+	e, ok := h.lookup(primary, req)
+	if !ok {
+		if req.Method == http.MethodHead {
+			w.Header().Set("X-Cache", Miss)
+			upstream.ServeHTTP(w, req)
+			return
+		}
+
+		// wait for the same request made by someone else, it's response could be stored already
+		if done, leader := h.acquire(primary); !leader {
+			select {
+			case <-done:
+			case <-req.Context().Done():
+				return
+			}
+			if e, ok = h.lookup(primary, req); !ok {
+				h.fetch(w, req, primary, upstream)
+				return
+			}
+		} else {
+			defer h.release(primary)
+			h.fetch(w, req, primary, upstream)
+			return
+		}
+	}
+
+	now := time.Now()
+	age := e.age(now)
+	lifetime, _ := freshnessLifetime(e.Status, e.Header, e.Stored, h.defaultTtl())
+	respCC := parseCacheControl(e.Header)
+
+	fresh := age < lifetime
+	if _, ok := respCC["no-cache"]; ok {
+		fresh = false
+	}
+	if _, ok := reqCC["no-cache"]; ok {
+		fresh = false
+	}
+	if maxAge, ok := directiveSeconds(reqCC, "max-age"); ok && age > maxAge {
+		fresh = false
+	}
+	if fresh {
+		h.serveEntry(w, req, e, Hit, now)
+		return
+	}
+
+	_, mustRevalidate := respCC["must-revalidate"]
+	_, proxyRevalidate := respCC["proxy-revalidate"]
+	mustRevalidate = mustRevalidate || proxyRevalidate
+
+	// serve stale response while it's being revalidated in background
+	if swr, ok := directiveSeconds(respCC, "stale-while-revalidate"); ok && !mustRevalidate && age < lifetime+swr {
+		if _, leader := h.acquire(primary); leader {
+			// request is cloned before the handler returns, gin reuses it afterwards
+			bg := req.Clone(detachedContext{req.Context()})
+			bg.Method = http.MethodGet
+			go func() {
+				defer h.release(primary)
+				h.revalidate(&discardWriter{header: http.Header{}}, bg, e, upstream, 0)
+			}()
+		}
+		h.serveEntry(w, req, e, Stale, now)
+		return
+	}
+
+	staleIfError := time.Duration(-1)
+	if sie, ok := directiveSeconds(respCC, "stale-if-error"); ok && !mustRevalidate && age < lifetime+sie {
+		staleIfError = sie
+	}
+	h.revalidate(w, req, e, upstream, staleIfError)
+}
+
+// returns stored response that matches request
+func (h *Handler) lookup(primary string, req *http.Request) (*Entry, bool) {
+	e, ok := h.store.Get(primary)
+	if !ok {
+		return nil, false
+	}
+	if e.Vary != nil {
+		return h.store.Get(variantKey(primary, e.Vary, req))
+	}
+	return e, true
+}
+
+// registers request to upstream for key. Returns true if caller is the first one and should call release.
+// Otherwise returned channel is closed once first request is finished
+func (h *Handler) acquire(key string) (chan struct{}, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if done, ok := h.inflight[key]; ok {
+		return done, false
+	}
+	done := make(chan struct{})
+	h.inflight[key] = done
+	return done, true
+}
+
+func (h *Handler) release(key string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	close(h.inflight[key])
+	delete(h.inflight, key)
+}
+
+// passes request to upstream, writes response to the client and stores it if possible
+func (h *Handler) fetch(w http.ResponseWriter, req *http.Request, primary string, upstream http.Handler) {
+	tee := &teeWriter{ResponseWriter: w, limit: h.maxObjectSize}
+	upstream.ServeHTTP(tee, req)
+
+	if tee.header == nil || tee.overflow {
+		return
+	}
+	h.storeResponse(req, primary, tee.status, tee.header, tee.buf.Bytes(), time.Now())
+}
+
+// revalidates stale entry with upstream using its validators.
+// staleIfError shows how long stale entry can be served if upstream fails, negative if not allowed
+func (h *Handler) revalidate(w http.ResponseWriter, req *http.Request, e *Entry, upstream http.Handler,
+	staleIfError time.Duration) {
+
+	cond := req.Clone(req.Context())
+	cond.Method = http.MethodGet
+	cond.Header.Del("If-None-Match")
+	cond.Header.Del("If-Modified-Since")
+	if etag := e.Header.Get("ETag"); etag != "" {
+		cond.Header.Set("If-None-Match", etag)
+	}
+	if lm := e.Header.Get("Last-Modified"); lm != "" {
+		cond.Header.Set("If-Modified-Since", lm)
+	}
+
+	// 304 is answered with stored response, so is 5xx if stale one is allowed. Other responses are passed
+	// to the client as they come and kept only until they exceed the object size limit, as misses are
+	rw := &revalidationWriter{teeWriter: teeWriter{ResponseWriter: w, limit: h.maxObjectSize},
+		clean: w.Header().Clone(), head: req.Method == http.MethodHead,
+		hold: func(status int) bool {
+			return status == http.StatusNotModified && hasValidators(e.Header) || status >= 500 && staleIfError >= 0
+		}}
+	upstream.ServeHTTP(rw, cond)
+	now := time.Now()
+	primary := h.settings.key(req)
+
+	if rw.held && rw.status == http.StatusNotModified {
+		updated := *e
+		updated.Header = e.Header.Clone()
+		for k, v := range rw.header {
+			if k != "Content-Length" && !perRequest(k) {
+				updated.Header[k] = v
+			}
+		}
+		updated.Header.Del("Age")
+		updated.Stored = now
+		h.store.Set(updated.Key, &updated)
+		h.serveEntry(w, req, &updated, Revalidated, now)
+		return
+	}
+
+	if rw.held {
+		h.serveEntry(w, req, e, Stale, now)
+		return
+	}
+
+	if rw.header == nil || rw.overflow {
+		return
+	}
+	h.storeResponse(cond, primary, rw.status, rw.header, rw.buf.Bytes(), now)
+}
+
+// stores response under primary key or under variant key if response has Vary header
+func (h *Handler) storeResponse(req *http.Request, primary string, status int, header http.Header, body []byte,
+	stored time.Time) {
+
+	if !storable(req, status, header, h.defaultTtl()) {
+		return
+	}
+
+	header = header.Clone()
+	for k := range header {
+		if perRequest(k) {
+			delete(header, k)
+		}
+	}
+	e := &Entry{Key: primary, Host: req.Host, Url: req.URL.RequestURI(), Status: status, Header: header,
+		Body: body, Stored: stored}
+	if settings.Tag_header != "" {
+		e.Tags = parseTags(header.Get(settings.Tag_header))
+	}
+
+	if vary := varyHeaders(header); len(vary) > 0 {
+		h.store.Set(primary, &Entry{Key: primary, Host: e.Host, Url: e.Url, Stored: stored, Vary: vary})
+		e.Key = variantKey(primary, vary, req)
+	}
+	h.store.Set(e.Key, e)
+}
+
+// unsafe methods invalidate stored responses of the target uri once upstream succeeds (RFC 9111 4.4)
+func (h *Handler) serveUnsafe(w http.ResponseWriter, req *http.Request, upstream http.Handler) {
+	sw := &statusWriter{ResponseWriter: w}
+	upstream.ServeHTTP(sw, req)
+
+	// variants are removed with the Vary entry, otherwise they'd be reachable again once it's stored anew
+	if sw.status < 400 {
+		primary := h.settings.key(req)
+		h.store.DeleteFunc(func(e *Entry) bool {
+			return e.Key == primary || strings.HasPrefix(e.Key, primary+"|vary:")
+		})
+	}
+}
+
+// writes stored response to the client. Answers conditional requests with 304
+func (h *Handler) serveEntry(w http.ResponseWriter, req *http.Request, e *Entry, xcache string, now time.Time) {
+	header := w.Header()
+	for k, v := range e.Header {
+		if !perRequest(k) {
+			header[k] = append([]string(nil), v...)
+		}
+	}
+	header.Set("Age", strconv.FormatInt(int64(e.age(now)/time.Second), 10))
+	header.Set("X-Cache", xcache)
+
+	if notModified(req, e.Header) {
+		header.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	header.Set("Content-Length", strconv.Itoa(len(e.Body)))
+	w.WriteHeader(e.Status)
+	if req.Method != http.MethodHead {
+		w.Write(e.Body)
+	}
+}
+
+// checks conditional headers of the client request against stored response
+func notModified(req *http.Request, h http.Header) bool {
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		etag := strings.TrimPrefix(h.Get("ETag"), "W/")
+		if etag == "" {
+			return false
+		}
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := req.Header.Get("If-Modified-Since"); ims != "" {
+		since, err1 := http.ParseTime(ims)
+		modified, err2 := http.ParseTime(h.Get("Last-Modified"))
+		return err1 == nil && err2 == nil && !modified.After(since)
+	}
+
+	return false
+}
+
+// headers the proxy sets for each request, they aren't stored and served with cached responses
+func perRequest(key string) bool {
+	key = http.CanonicalHeaderKey(key)
+	return key == "X-Cache" || key == http.CanonicalHeaderKey(RequestID.Global.Header)
+}
+
+func copyHeader(dst, src http.Header) {
+	for k, v := range src {
+		dst[k] = append([]string(nil), v...)
+	}
+}
+
+// teeWriter writes response to the client and keeps a copy of it until limit is reached
+type teeWriter struct {
+	http.ResponseWriter
+	limit    int
+	status   int
+	header   http.Header
+	buf      bytes.Buffer
+	overflow bool
+}
+
+func (w *teeWriter) WriteHeader(code int) {
+	// informational responses are passed as is
+	if code < 200 {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.header != nil {
+		return
+	}
+	w.status = code
+	w.header = w.ResponseWriter.Header().Clone()
+	w.ResponseWriter.Header().Set("X-Cache", Miss)
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *teeWriter) Write(data []byte) (int, error) {
+	if w.header == nil {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.keep(data)
+	return w.ResponseWriter.Write(data)
+}
+
+// keeps copy of data, copy is dropped once it exceeds the limit
+func (w *teeWriter) keep(data []byte) {
+	if w.overflow {
+		return
+	}
+	if w.buf.Len()+len(data) > w.limit {
+		w.overflow = true
+		w.buf = bytes.Buffer{}
+	} else {
+		w.buf.Write(data)
+	}
+}
+
+func (w *teeWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// revalidationWriter passes response of revalidation to the client like teeWriter does, except responses
+// hold returns true for: they aren't written, so stored response can be served instead
+type revalidationWriter struct {
+	teeWriter
+	hold func(status int) bool
+	// header of the client before upstream wrote to it, it's restored if response is held
+	clean http.Header
+	held  bool
+	// client made HEAD request, body is only kept for the cache
+	head bool
+}
+
+func (w *revalidationWriter) WriteHeader(code int) {
+	if code < 200 || w.header != nil || !w.hold(code) {
+		w.teeWriter.WriteHeader(code)
+		return
+	}
+	w.held, w.status = true, code
+	header := w.ResponseWriter.Header()
+	w.header = header.Clone()
+	for k := range header {
+		delete(header, k)
+	}
+	copyHeader(header, w.clean)
+}
+
+func (w *revalidationWriter) Write(data []byte) (int, error) {
+	if w.header == nil {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.held {
+		return len(data), nil
+	}
+	if w.head {
+		w.keep(data)
+		return len(data), nil
+	}
+	return w.teeWriter.Write(data)
+}
+
+func (w *revalidationWriter) Flush() {
+	if !w.held {
+		w.teeWriter.Flush()
+	}
+}
+
+// discardWriter is used for background revalidation which response isn't sent anywhere
+type discardWriter struct {
+	header http.Header
+}
+
+func (d *discardWriter) Header() http.Header         { return d.header }
+func (d *discardWriter) WriteHeader(int)             {}
+func (d *discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+
+// statusWriter remembers status of the response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// detachedContext keeps values of the parent context but isn't canceled with it,
+// so background revalidation outlives the client request that triggered it
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
@@ -0,0 +1,166 @@
+package Cache
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// describes which entries should be purged, only one criteria should be specified
+type PurgeRequest struct {
+	// exact request uri (path with query) of the entry
+	Url string `json:"url"`
+	// restricts 'url' and 'prefix' to the given host, any host if empty
+	Host string `json:"host"`
+	// request uri prefix of the entries
+	Prefix string `json:"prefix"`
+	// surrogate key of the entries
+	Tag string `json:"tag"`
+	// purges whole cache
+	All bool `json:"all"`
+}
+
+// short description of the stored entry used for inspection
+type EntryInfo struct {
+	Key    string      `json:"key"`
+	Host   string      `json:"host"`
+	Url    string      `json:"url"`
+	Status int         `json:"status,omitempty"`
+	Size   int64       `json:"size"`
+	Age    int64       `json:"age"`
+	Stored time.Time   `json:"stored"`
+	Tags   []string    `json:"tags,omitempty"`
+	Vary   []string    `json:"vary,omitempty"`
+	Header http.Header `json:"header,omitempty"`
+}
+
+func (r *PurgeRequest) Validate() error {
+	criteria := 0
+	for _, v := range []string{r.Url, r.Prefix, r.Tag} {
+		if v != "" {
+			criteria++
+		}
+	}
+	if r.All {
+		criteria++
+	}
+
+	if criteria != 1 {
+		return errors.New("Exactly one of 'url', 'prefix', 'tag' or 'all' should be specified")
+	}
+	return nil
+}
+
+func (r *PurgeRequest) matches(e *Entry) bool {
+	if r.Host != "" && r.Host != e.Host {
+		return false
+	}
+	switch {
+	case r.Url != "":
+		return e.Url == r.Url
+	case r.Prefix != "":
+		return strings.HasPrefix(e.Url, r.Prefix)
+	case r.Tag != "":
+		for _, t := range e.Tags {
+			if t == r.Tag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Purge removes entries that match request from the store and returns amount of removed entries
+func Purge(store Store, r *PurgeRequest) (int, error) {
+	if err := r.Validate(); err != nil {
+		return 0, err
+	}
+
+	// Vary entries only point to variants, they aren't counted
+	if r.All {
+		count := 0
+		store.Range(func(e *Entry, size int64) bool {
+			if e.Vary == nil {
+				count++
+			}
+			return true
+		})
+		store.Clear()
+		return count, nil
+	}
+
+	count := 0
+	for _, e := range store.DeleteFunc(r.matches) {
+		if e.Vary == nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func entryInfo(e *Entry, size int64, now time.Time) EntryInfo {
+	info := EntryInfo{Key: e.Key, Host: e.Host, Url: e.Url, Status: e.Status, Size: size, Stored: e.Stored,
+		Tags: e.Tags, Vary: e.Vary}
+	if e.Header != nil {
+		info.Age = int64(e.age(now) / time.Second)
+	} else {
+		info.Age = int64(now.Sub(e.Stored) / time.Second)
+	}
+	return info
+}
+
+// Entries returns info about stored entries which request uri starts with prefix and have given tag.
+// Empty prefix and tag match all entries
+func Entries(store Store, prefix string, tag string) []EntryInfo {
+	rv := []EntryInfo{}
+	now := time.Now()
+	store.Range(func(e *Entry, size int64) bool {
+		if prefix != "" && !strings.HasPrefix(e.Url, prefix) {
+			return true
+		}
+		if tag != "" && !(&PurgeRequest{Tag: tag}).matches(e) {
+			return true
+		}
+		rv = append(rv, entryInfo(e, size, now))
+		return true
+	})
+	return rv
+}
+
+// Registers cache inspection and purge operations on admin router group
+func RegisterAdmin(group *gin.RouterGroup) {
+	group.GET("/entries", func(c *gin.Context) {
+		entries := Entries(getStore(), c.Query("prefix"), c.Query("tag"))
+		c.JSON(http.StatusOK, gin.H{"count": len(entries), "entries": entries})
+	})
+
+	group.GET("/entry", func(c *gin.Context) {
+		store := getStore()
+		key := c.Query("key")
+		e, ok := store.Get(key)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Entry not found: " + key})
+			return
+		}
+		info := entryInfo(e, e.Size(), time.Now())
+		info.Header = e.Header
+		c.JSON(http.StatusOK, info)
+	})
+
+	group.POST("/purge", func(c *gin.Context) {
+		var r PurgeRequest
+		if err := c.ShouldBindJSON(&r); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		count, err := Purge(getStore(), &r)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"purged": count})
+	})
+}
@@ -0,0 +1,198 @@
+package Cache
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+)
+
+// Store keeps cached entries by key.
+// Implementations should be safe for concurrent use
+type Store interface {
+	Get(key string) (*Entry, bool)
+	Set(key string, e *Entry)
+	Delete(key string)
+	// calls f for every entry until f returns false. Body of passed entry may be missing,
+	// size is the amount of bytes entry occupies in the store
+	Range(f func(e *Entry, size int64) bool)
+	// removes entries f returns true for and Vary entries pointing to their variants under one lock,
+	// so the variants can't be reached through the pointers meanwhile. Returns removed entries
+	DeleteFunc(f func(e *Entry) bool) []*Entry
+	// removes all entries
+	Clear()
+}
+
+// lru keeps keys in order of usage and evicts least recently used ones when limits are exceeded.
+// It isn't safe for concurrent use, owner should guard it
+type lru struct {
+	maxEntries int
+	maxSize    int64
+	size       int64
+	order      *list.List
+	items      map[string]*list.Element
+	// called for every evicted item
+	onEvict func(item *lruItem)
+}
+
+type lruItem struct {
+	key   string
+	size  int64
+	entry *Entry
+}
+
+func newLru(maxEntries int, maxSize int64, onEvict func(item *lruItem)) *lru {
+	return &lru{
+		maxEntries: maxEntries,
+		maxSize:    maxSize,
+		order:      list.New(),
+		items:      map[string]*list.Element{},
+		onEvict:    onEvict,
+	}
+}
+
+func (c *lru) get(key string) (*lruItem, bool) {
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*lruItem), true
+}
+
+func (c *lru) add(item *lruItem) {
+	if el, ok := c.items[item.key]; ok {
+		c.size -= el.Value.(*lruItem).size
+		el.Value = item
+		c.order.MoveToFront(el)
+	} else {
+		c.items[item.key] = c.order.PushFront(item)
+	}
+	c.size += item.size
+
+	for c.order.Len() > 0 && (c.order.Len() > c.maxEntries || c.size > c.maxSize) {
+		oldest := c.order.Back()
+		c.removeElement(oldest)
+		if c.onEvict != nil {
+			c.onEvict(oldest.Value.(*lruItem))
+		}
+	}
+}
+
+func (c *lru) remove(key string) (*lruItem, bool) {
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.removeElement(el)
+	return el.Value.(*lruItem), true
+}
+
+// calls f for every item from most to least recently used one until f returns false
+func (c *lru) each(f func(item *lruItem) bool) {
+	for el := c.order.Front(); el != nil; el = el.Next() {
+		if !f(el.Value.(*lruItem)) {
+			return
+		}
+	}
+}
+
+// removes items f returns true for and items of Vary entries whose variants are removed
+func (c *lru) removeFunc(f func(e *Entry) bool) []*lruItem {
+	var removed []*lruItem
+	primaries := map[string]bool{}
+	for el := c.order.Front(); el != nil; {
+		next := el.Next()
+		item := el.Value.(*lruItem)
+		if f(item.entry) {
+			c.removeElement(el)
+			removed = append(removed, item)
+			if i := strings.Index(item.key, "|vary:"); i != -1 {
+				primaries[item.key[:i]] = true
+			}
+		}
+		el = next
+	}
+	for key := range primaries {
+		if item, ok := c.get(key); ok && item.entry.Vary != nil {
+			c.remove(key)
+			removed = append(removed, item)
+		}
+	}
+	return removed
+}
+
+func (c *lru) removeElement(el *list.Element) {
+	item := el.Value.(*lruItem)
+	c.order.Remove(el)
+	delete(c.items, item.key)
+	c.size -= item.size
+}
+
+// memoryStore keeps entries in memory evicting least recently used ones
+type memoryStore struct {
+	mu    sync.Mutex
+	cache *lru
+}
+
+func NewMemoryStore(maxEntries int, maxSize int64) Store {
+	return &memoryStore{cache: newLru(maxEntries, maxSize, nil)}
+}
+
+func (s *memoryStore) Get(key string) (*Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, ok := s.cache.get(key)
+	if !ok {
+		return nil, false
+	}
+	return item.entry, true
+}
+
+func (s *memoryStore) Set(key string, e *Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cache.add(&lruItem{key: key, size: e.Size(), entry: e})
+}
+
+func (s *memoryStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cache.remove(key)
+}
+
+func (s *memoryStore) Range(f func(e *Entry, size int64) bool) {
+	s.mu.Lock()
+	var items []*lruItem
+	s.cache.each(func(item *lruItem) bool {
+		items = append(items, item)
+		return true
+	})
+	s.mu.Unlock()
+
+	for _, item := range items {
+		if !f(item.entry, item.size) {
+			return
+		}
+	}
+}
+
+func (s *memoryStore) DeleteFunc(f func(e *Entry) bool) []*Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rv []*Entry
+	for _, item := range s.cache.removeFunc(f) {
+		rv = append(rv, item.entry)
+	}
+	return rv
+}
+
+func (s *memoryStore) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cache = newLru(s.cache.maxEntries, s.cache.maxSize, nil)
+}
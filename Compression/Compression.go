@@ -0,0 +1,257 @@
+package Compression
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+	"github.com/mitchellh/mapstructure"
+)
+
+const (
+	Gzip    = "gzip"
+	Deflate = "deflate"
+	Brotli  = "br"
+	Zstd    = "zstd"
+
+	// responses smaller than this amount of bytes aren't compressed if 'min_size' isn't specified
+	defaultMinSize = 1024
+)
+
+// global compression settings, nil if 'Compression' section is missing in settings file
+var Global *Settings
+
+var defaultAlgorithms = []string{Brotli, Zstd, Gzip}
+
+var defaultContentTypes = []string{
+	"text/*",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/x-www-form-urlencoded",
+	"image/svg+xml",
+}
+
+type Settings struct {
+	Enabled bool
+	// supported algorithms in order of server preference: br, zstd, gzip, deflate
+	Algorithms []string
+	// compression level per algorithm, algorithm default is used if missing or 0
+	Levels map[string]int
+	// minimal response size in bytes to be compressed
+	Min_size int
+	// media types to be compressed, 'type/*' matches whole type
+	Content_types []string
+	// decompress request bodies sent with Content-Encoding before proxying them
+	Decompress_request bool
+}
+
+func (s *Settings) Validate() error {
+	if len(s.Algorithms) == 0 {
+		s.Algorithms = defaultAlgorithms
+	}
+	for _, a := range s.Algorithms {
+		if a != Gzip && a != Deflate && a != Brotli && a != Zstd {
+			return errors.New("Unsupported compression algorithm: " + a)
+		}
+	}
+
+	for a, level := range s.Levels {
+		min, max := levelRange(a)
+		if min == max {
+			return errors.New("Compression level specified for unsupported algorithm: " + a)
+		}
+		if level < min || level > max {
+			return errors.New("Compression level for " + a + " should be within [" + strconv.Itoa(min) +
+				", " + strconv.Itoa(max) + "]")
+		}
+	}
+
+	if s.Min_size < 0 {
+		return errors.New("Compression 'min_size' can't be negative")
+	} else if s.Min_size == 0 {
+		s.Min_size = defaultMinSize
+	}
+
+	if len(s.Content_types) == 0 {
+		s.Content_types = defaultContentTypes
+	}
+
+	return nil
+}
+
+// returns allowed levels range of algorithm
+func levelRange(algorithm string) (int, int) {
+	switch algorithm {
+	case Gzip, Deflate:
+		return flate.HuffmanOnly, flate.BestCompression
+	case Brotli:
+		return brotli.BestSpeed, brotli.BestCompression
+	case Zstd:
+		return 1, 22
+	}
+	return 0, 0
+}
+
+// Read compression settings from parsed json value and validates it
+func ReadCompressionFromFile(v interface{}) *Settings {
+	var s Settings
+	if err := mapstructure.Decode(v, &s); err != nil {
+		panic("Can't decode compression settings. Error: " + err.Error())
+	}
+	if err := s.Validate(); err != nil {
+		panic(err.Error())
+	}
+
+	return &s
+}
+
+// Inits global compression settings from 'Compression' section, section is optional
+func InitCompression(file map[string]interface{}) {
+	if v, exist := file["Compression"]; exist {
+		Global = ReadCompressionFromFile(v)
+	}
+}
+
+// chooses algorithm based on Accept-Encoding header of the request.
+// Algorithm with highest q value is chosen, server order resolves ties. Empty string if none accepted
+func negotiate(acceptEncoding string, algorithms []string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	accepted := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		q := 1.0
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				if v, err := strconv.ParseFloat(p[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		accepted[name] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, a := range algorithms {
+		q, ok := accepted[a]
+		if !ok {
+			q, ok = accepted["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = a, q
+		}
+	}
+
+	return best
+}
+
+func newEncoder(algorithm string, level int, w io.Writer) io.WriteCloser {
+	switch algorithm {
+	case Gzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		enc, _ := gzip.NewWriterLevel(w, level)
+		return enc
+	case Deflate:
+		if level == 0 {
+			level = flate.DefaultCompression
+		}
+		enc, _ := flate.NewWriter(w, level)
+		return enc
+	case Brotli:
+		if level == 0 {
+			level = brotli.DefaultCompression
+		}
+		return brotli.NewWriterLevel(w, level)
+	case Zstd:
+		opts := []zstd.EOption{}
+		if level != 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		enc, _ := zstd.NewWriter(w, opts...)
+		return enc
+	}
+	return nil
+}
+
+// returns reader that decodes body of given encoding
+func newDecoder(encoding string, body io.Reader) (io.ReadCloser, error) {
+	switch encoding {
+	case Gzip:
+		return gzip.NewReader(body)
+	case Deflate:
+		return flate.NewReader(body), nil
+	case Brotli:
+		return ioutil.NopCloser(brotli.NewReader(body)), nil
+	case Zstd:
+		dec, err := zstd.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	}
+	return nil, errors.New("Unsupported content encoding: " + encoding)
+}
+
+// replaces body of encoded request with decoded one
+func decompressRequest(req *http.Request) error {
+	encoding := strings.ToLower(strings.TrimSpace(req.Header.Get("Content-Encoding")))
+	if encoding == "" || encoding == "identity" || req.Body == nil {
+		return nil
+	}
+
+	body, err := newDecoder(encoding, req.Body)
+	if err != nil {
+		return err
+	}
+
+	req.Body = body
+	req.ContentLength = -1
+	req.Header.Del("Content-Encoding")
+	req.Header.Del("Content-Length")
+	return nil
+}
+
+// Creates middleware that compresses responses according to given settings
+func Middleware(s *Settings) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.Decompress_request {
+			if err := decompressRequest(c.Request); err != nil {
+				c.AbortWithStatusJSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		if c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+
+		w := &compressWriter{
+			ResponseWriter: c.Writer,
+			settings:       s,
+			encoding:       negotiate(c.Request.Header.Get("Accept-Encoding"), s.Algorithms),
+		}
+		c.Writer = w
+		defer func() {
+			w.finish()
+			c.Writer = w.ResponseWriter
+		}()
+
+		c.Next()
+	}
+}
@@ -0,0 +1,187 @@
+package Compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSettings_Validate(t *testing.T) {
+	s := Settings{Enabled: true}
+	if err := s.Validate(); err != nil {
+		t.Error(err)
+	}
+	if s.Min_size != defaultMinSize || len(s.Algorithms) == 0 || len(s.Content_types) == 0 {
+		t.Error("Validate() should fill default values")
+	}
+
+	s.Algorithms = []string{"gzip", "lzma"}
+	if err := s.Validate(); err == nil {
+		t.Error("Validate() should fail for unsupported algorithm")
+	}
+
+	s.Algorithms = []string{"gzip"}
+	s.Levels = map[string]int{"gzip": 15}
+	if err := s.Validate(); err == nil {
+		t.Error("Validate() should fail for level out of range")
+	}
+
+	s.Levels = map[string]int{"br": 11, "zstd": 3}
+	if err := s.Validate(); err != nil {
+		t.Error(err)
+	}
+
+	s.Min_size = -1
+	if err := s.Validate(); err == nil {
+		t.Error("Validate() should fail for negative 'min_size'")
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	algorithms := []string{Brotli, Zstd, Gzip}
+
+	cases := map[string]string{
+		"":                     "",
+		"gzip":                 Gzip,
+		"gzip, br":             Brotli,
+		"br;q=0.5, gzip":       Gzip,
+		"identity":             "",
+		"*":                    Brotli,
+		"br;q=0, *;q=0.1":      Zstd,
+		"deflate, GZIP;q=0.9":  Gzip,
+		"zstd;q=0.8, br;q=0.8": Brotli,
+	}
+
+	for header, expected := range cases {
+		if rv := negotiate(header, algorithms); rv != expected {
+			t.Error("Accept-Encoding '" + header + "' negotiated '" + rv + "' instead of '" + expected + "'")
+		}
+	}
+}
+
+func newTestEngine(s *Settings, contentType string, body string, headers map[string]string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.POST("/", Middleware(s), func(c *gin.Context) {
+		for k, v := range headers {
+			c.Header(k, v)
+		}
+		c.Header("Content-Type", contentType)
+		c.Status(http.StatusOK)
+		c.Writer.Write([]byte(body))
+	})
+	return engine
+}
+
+func TestMiddleware(t *testing.T) {
+	s := &Settings{Enabled: true, Algorithms: []string{Gzip}, Min_size: 10}
+	s.Validate()
+	body := strings.Repeat("{\"key\": \"value\"}", 100)
+
+	// compressed response
+	engine := newTestEngine(s, "application/json; charset=utf-8", body, map[string]string{"ETag": `"abc"`})
+	req := httptest.NewRequest("POST", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	if w.Header().Get("Content-Encoding") != Gzip {
+		t.Fatal("Response should be compressed with gzip")
+	}
+	if w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Error("Vary header should contain Accept-Encoding")
+	}
+	if w.Header().Get("ETag") != `W/"abc"` {
+		t.Error("ETag of compressed response should be weak")
+	}
+	r, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, _ := ioutil.ReadAll(r)
+	if string(decoded) != body {
+		t.Error("Decompressed body doesn't match the original one")
+	}
+
+	// client doesn't accept compression
+	req = httptest.NewRequest("POST", "/", nil)
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if w.Header().Get("Content-Encoding") != "" || w.Body.String() != body {
+		t.Error("Response shouldn't be compressed if client doesn't accept it")
+	}
+	if w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Error("Vary header should be set for compressible responses")
+	}
+
+	// response is too small
+	engine = newTestEngine(s, "application/json", "{}", nil)
+	req = httptest.NewRequest("POST", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if w.Header().Get("Content-Encoding") != "" || w.Body.String() != "{}" {
+		t.Error("Response smaller then 'min_size' shouldn't be compressed")
+	}
+
+	// content type isn't in allow list
+	engine = newTestEngine(s, "image/png", body, nil)
+	req = httptest.NewRequest("POST", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if w.Header().Get("Content-Encoding") != "" {
+		t.Error("Response with not allowed content type shouldn't be compressed")
+	}
+
+	// already encoded response
+	engine = newTestEngine(s, "text/plain", body, map[string]string{"Content-Encoding": "br"})
+	req = httptest.NewRequest("POST", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if w.Header().Get("Content-Encoding") != "br" || w.Body.String() != body {
+		t.Error("Already encoded response shouldn't be compressed again")
+	}
+}
+
+func TestRequestDecompression(t *testing.T) {
+	s := &Settings{Enabled: true, Decompress_request: true}
+	s.Validate()
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	var received string
+	engine.POST("/", Middleware(s), func(c *gin.Context) {
+		b, _ := ioutil.ReadAll(c.Request.Body)
+		received = string(b)
+		c.Status(http.StatusOK)
+	})
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte("uploaded data"))
+	zw.Close()
+
+	req := httptest.NewRequest("POST", "/", &buf)
+	req.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if received != "uploaded data" {
+		t.Error("Request body should be decompressed. Received: " + received)
+	}
+
+	req = httptest.NewRequest("POST", "/", strings.NewReader("data"))
+	req.Header.Set("Content-Encoding", "compress")
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Error("Unsupported request encoding should be rejected")
+	}
+}
@@ -0,0 +1,141 @@
+package Compression
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"proxy/Headers"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// compressWriter buffers beginning of the response until 'min_size' bytes are collected,
+// then decides whether response should be compressed and passes everything further to encoder or
+// to the underlying writer
+type compressWriter struct {
+	gin.ResponseWriter
+	settings *Settings
+	// negotiated algorithm, empty if client doesn't accept any of supported
+	encoding string
+	buf      []byte
+	decided  bool
+	encoder  io.WriteCloser
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if w.decided {
+		return w.writeDecided(data)
+	}
+
+	w.buf = append(w.buf, data...)
+	if len(w.buf) >= w.settings.Min_size {
+		w.decide(true)
+		buf := w.buf
+		w.buf = nil
+		if _, err := w.writeDecided(buf); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(data), nil
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *compressWriter) writeDecided(data []byte) (int, error) {
+	if w.encoder != nil {
+		return w.encoder.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// Flush sends everything written so far to the client, even if 'min_size' isn't reached yet
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(len(w.buf) > 0)
+		buf := w.buf
+		w.buf = nil
+		w.writeDecided(buf)
+	}
+
+	if f, ok := w.encoder.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// decides whether response should be compressed and prepares headers accordingly.
+// bigEnough shows if response reached minimal size to be compressed
+func (w *compressWriter) decide(bigEnough bool) {
+	w.decided = true
+	if !bigEnough || !w.compressible() {
+		return
+	}
+
+	h := w.Header()
+	Headers.AddVary(h, "Accept-Encoding")
+	if w.encoding == "" {
+		return
+	}
+
+	h.Set("Content-Encoding", w.encoding)
+	h.Del("Content-Length")
+	h.Del("Accept-Ranges")
+	// compressed representation isn't byte to byte equal to the original one
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+
+	w.encoder = newEncoder(w.encoding, w.settings.Levels[w.encoding], w.ResponseWriter)
+}
+
+// checks if response status and headers allow compression
+func (w *compressWriter) compressible() bool {
+	status := w.Status()
+	if status < 200 || status == http.StatusNoContent || status == http.StatusPartialContent ||
+		status == http.StatusNotModified {
+		return false
+	}
+
+	h := w.Header()
+	if ce := h.Get("Content-Encoding"); ce != "" && ce != "identity" {
+		return false
+	}
+	if strings.Contains(strings.ToLower(h.Get("Cache-Control")), "no-transform") {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	for _, allowed := range w.settings.Content_types {
+		if allowed == mediaType {
+			return true
+		}
+		if strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writes buffered data and closes encoder, called once handler is finished
+func (w *compressWriter) finish() {
+	if !w.decided {
+		w.decide(len(w.buf) >= w.settings.Min_size)
+		buf := w.buf
+		w.buf = nil
+		if len(buf) > 0 {
+			w.writeDecided(buf)
+		}
+	}
+
+	if w.encoder != nil {
+		w.encoder.Close()
+	}
+}
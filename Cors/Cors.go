@@ -0,0 +1,287 @@
+package Cors
+
+import (
+	"errors"
+	"net/http"
+	"proxy/Headers"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mitchellh/mapstructure"
+)
+
+// global CORS settings, nil if 'Cors' section is missing in settings file
+var Global *Settings
+
+var defaultAllowedHeaders = []string{"Accept", "Accept-Language", "Authorization", "Content-Language",
+	"Content-Type", "X-Requested-With"}
+
+type Settings struct {
+	Enabled bool
+	// allowed origins: exact value, '*' for any origin, wildcard like 'https://*.example.com'
+	// or regular expression starting with '^'
+	Allowed_origins []string
+	// methods allowed in preflight, methods of the endpoint are used if empty
+	Allowed_methods []string
+	// request headers allowed in preflight, '*' allows any requested header
+	Allowed_headers []string
+	// response headers that browser exposes to the script
+	Exposed_headers   []string
+	Allow_credentials bool
+	// seconds preflight response can be cached by browser, 0 means header isn't sent
+	Max_age int
+
+	// compiled representation of allowed origins
+	anyOrigin bool
+	exact     map[string]bool
+	patterns  []*regexp.Regexp
+}
+
+func (s *Settings) Validate() error {
+	if len(s.Allowed_origins) == 0 {
+		return errors.New("CORS 'allowed_origins' should contain at least one origin")
+	}
+	if s.Max_age < 0 {
+		return errors.New("CORS 'max_age' can't be negative")
+	}
+
+	s.anyOrigin = false
+	s.exact = map[string]bool{}
+	s.patterns = nil
+	for _, o := range s.Allowed_origins {
+		switch {
+		case o == "*":
+			s.anyOrigin = true
+		case strings.HasPrefix(o, "^"):
+			re, err := regexp.Compile(o)
+			if err != nil {
+				return errors.New("Invalid CORS origin expression: " + o + ". Error: " + err.Error())
+			}
+			s.patterns = append(s.patterns, re)
+		case strings.Contains(o, "*"):
+			parts := strings.Split(o, "*")
+			for i := range parts {
+				parts[i] = regexp.QuoteMeta(strings.ToLower(parts[i]))
+			}
+			s.patterns = append(s.patterns, regexp.MustCompile("^"+strings.Join(parts, "[^/]*")+"$"))
+		default:
+			s.exact[strings.ToLower(o)] = true
+		}
+	}
+
+	// browsers reject credentialed responses with wildcard origin
+	if s.anyOrigin && s.Allow_credentials {
+		return errors.New("CORS 'allow_credentials' can't be used together with '*' origin")
+	}
+
+	for _, m := range s.Allowed_methods {
+		if m != strings.ToUpper(m) || m == "" {
+			return errors.New("CORS method should be upper-cased: " + m)
+		}
+	}
+
+	if len(s.Allowed_headers) == 0 {
+		s.Allowed_headers = defaultAllowedHeaders
+	}
+
+	return nil
+}
+
+// Read CORS settings from parsed json value and validates it
+func ReadCorsFromFile(v interface{}) *Settings {
+	var s Settings
+	if err := mapstructure.Decode(v, &s); err != nil {
+		panic("Can't decode CORS settings. Error: " + err.Error())
+	}
+	if err := s.Validate(); err != nil {
+		panic(err.Error())
+	}
+
+	return &s
+}
+
+// Inits global CORS settings from 'Cors' section, section is optional
+func InitCors(file map[string]interface{}) {
+	if v, exist := file["Cors"]; exist {
+		Global = ReadCorsFromFile(v)
+	}
+}
+
+func (s *Settings) originAllowed(origin string) bool {
+	if s.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if s.exact[origin] {
+		return true
+	}
+	for _, re := range s.patterns {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Settings) headersAllowed(requested string) bool {
+	for _, h := range s.Allowed_headers {
+		if h == "*" {
+			return true
+		}
+	}
+	for _, r := range strings.Split(requested, ",") {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		allowed := false
+		for _, h := range s.Allowed_headers {
+			if strings.EqualFold(h, r) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// sets Access-Control-Allow-Origin and credentials headers for allowed origin
+func (s *Settings) setOriginHeaders(h http.Header, origin string) {
+	if s.anyOrigin {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+		Headers.AddVary(h, "Origin")
+	}
+	if s.Allow_credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// Creates handler that answers preflight OPTIONS requests.
+// methods are used as allowed methods if settings don't specify them
+func Preflight(s *Settings, methods []string) gin.HandlerFunc {
+	allowed := s.Allowed_methods
+	if len(allowed) == 0 {
+		allowed = methods
+	}
+	allowedMethods := strings.Join(allowed, ", ")
+
+	return func(c *gin.Context) {
+		h := c.Writer.Header()
+		origin := c.GetHeader("Origin")
+		requestMethod := c.GetHeader("Access-Control-Request-Method")
+
+		// plain OPTIONS request isn't a preflight
+		if origin == "" || requestMethod == "" {
+			h.Set("Allow", allowedMethods+", OPTIONS")
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		Headers.AddVary(h, "Origin")
+		Headers.AddVary(h, "Access-Control-Request-Method")
+		Headers.AddVary(h, "Access-Control-Request-Headers")
+
+		methodAllowed := false
+		for _, m := range allowed {
+			if m == requestMethod {
+				methodAllowed = true
+				break
+			}
+		}
+		requestHeaders := c.GetHeader("Access-Control-Request-Headers")
+		if !s.originAllowed(origin) || !methodAllowed || !s.headersAllowed(requestHeaders) {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+
+		s.setOriginHeaders(h, origin)
+		h.Set("Access-Control-Allow-Methods", allowedMethods)
+		if requestHeaders != "" {
+			// requested headers are checked above, so they can be reflected as is
+			h.Set("Access-Control-Allow-Headers", requestHeaders)
+		}
+		if s.Max_age > 0 {
+			h.Set("Access-Control-Max-Age", strconv.Itoa(s.Max_age))
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
+
+// Creates middleware that adds CORS headers to responses of actual (not preflight) requests.
+// CORS headers set by upstream are replaced by the proxy policy
+func Middleware(s *Settings) gin.HandlerFunc {
+	exposed := strings.Join(s.Exposed_headers, ", ")
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+
+		allowed := s.originAllowed(origin)
+		c.Writer = &corsWriter{ResponseWriter: c.Writer, apply: func(h http.Header) {
+			for k := range h {
+				if strings.HasPrefix(k, "Access-Control-") {
+					h.Del(k)
+				}
+			}
+			// shared caches must keep responses of different origins apart, denied ones included
+			if !s.anyOrigin {
+				Headers.AddVary(h, "Origin")
+			}
+			if !allowed {
+				return
+			}
+			s.setOriginHeaders(h, origin)
+			if exposed != "" {
+				h.Set("Access-Control-Expose-Headers", exposed)
+			}
+		}}
+
+		c.Next()
+	}
+}
+
+// corsWriter applies CORS headers right before response headers are sent
+type corsWriter struct {
+	gin.ResponseWriter
+	apply   func(h http.Header)
+	applied bool
+}
+
+func (w *corsWriter) prepare() {
+	if !w.applied {
+		w.applied = true
+		w.apply(w.Header())
+	}
+}
+
+func (w *corsWriter) WriteHeader(code int) {
+	if code >= 200 {
+		w.prepare()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *corsWriter) WriteHeaderNow() {
+	w.prepare()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *corsWriter) Write(data []byte) (int, error) {
+	w.prepare()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *corsWriter) WriteString(s string) (int, error) {
+	w.prepare()
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,151 @@
+package Cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSettings_Validate(t *testing.T) {
+	s := Settings{Enabled: true}
+	if err := s.Validate(); err == nil {
+		t.Error("Validate() should fail without allowed origins")
+	}
+
+	s.Allowed_origins = []string{"*"}
+	s.Allow_credentials = true
+	if err := s.Validate(); err == nil {
+		t.Error("Validate() should fail for credentials with '*' origin")
+	}
+
+	s.Allowed_origins = []string{"^https://(a|b\\.example\\.com$"}
+	s.Allow_credentials = false
+	if err := s.Validate(); err == nil {
+		t.Error("Validate() should fail for invalid origin expression")
+	}
+
+	s.Allowed_origins = []string{"https://example.com", "https://*.example.org", "^https://app[0-9]+\\.net$"}
+	if err := s.Validate(); err != nil {
+		t.Error(err)
+	}
+
+	cases := map[string]bool{
+		"https://example.com":           true,
+		"https://EXAMPLE.com":           true,
+		"http://example.com":            false,
+		"https://api.example.org":       true,
+		"https://example.org":           false,
+		"https://app12.net":             true,
+		"https://app.net":               false,
+		"https://evil.com/.example.org": false,
+	}
+	for origin, expected := range cases {
+		if s.originAllowed(origin) != expected {
+			t.Error("Unexpected result for origin: " + origin)
+		}
+	}
+}
+
+func newTestEngine(s *Settings) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.OPTIONS("/api", Preflight(s, []string{"GET", "POST"}))
+	engine.GET("/api", Middleware(s), func(c *gin.Context) {
+		// upstream's own CORS policy should be replaced
+		c.Header("Access-Control-Allow-Origin", "*")
+		// Vary of upstream shouldn't be repeated
+		c.Header("Vary", "Accept-Encoding, Origin")
+		c.String(http.StatusOK, "ok")
+	})
+	return engine
+}
+
+func TestPreflight(t *testing.T) {
+	s := &Settings{Enabled: true, Allowed_origins: []string{"https://example.com"}, Allow_credentials: true,
+		Max_age: 600, Allowed_headers: []string{"Content-Type", "X-Token"}}
+	s.Validate()
+	engine := newTestEngine(s)
+
+	req := httptest.NewRequest("OPTIONS", "/api", nil)
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "content-type, x-token")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Fatal("Preflight should be answered with 204, got: ", w.Code)
+	}
+	h := w.Header()
+	if h.Get("Access-Control-Allow-Origin") != "https://example.com" ||
+		h.Get("Access-Control-Allow-Credentials") != "true" ||
+		h.Get("Access-Control-Allow-Methods") != "GET, POST" ||
+		h.Get("Access-Control-Allow-Headers") != "content-type, x-token" ||
+		h.Get("Access-Control-Max-Age") != "600" {
+		t.Error("Unexpected preflight headers: ", h)
+	}
+
+	req.Header.Set("Access-Control-Request-Method", "DELETE")
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Error("Preflight for not allowed method should be rejected")
+	}
+
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	req.Header.Set("Access-Control-Request-Headers", "X-Other")
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Error("Preflight for not allowed header should be rejected")
+	}
+
+	req.Header.Del("Access-Control-Request-Headers")
+	req.Header.Set("Origin", "https://evil.com")
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Error("Preflight from not allowed origin should be rejected")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	s := &Settings{Enabled: true, Allowed_origins: []string{"https://*.example.com"},
+		Exposed_headers: []string{"X-Total"}}
+	s.Validate()
+	engine := newTestEngine(s)
+
+	req := httptest.NewRequest("GET", "/api", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if w.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		w.Header().Get("Access-Control-Expose-Headers") != "X-Total" ||
+		strings.Join(w.Header().Values("Vary"), ";") != "Accept-Encoding, Origin" {
+		t.Error("Unexpected CORS headers: ", w.Header())
+	}
+
+	req.Header.Set("Origin", "https://evil.com")
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Error("CORS headers shouldn't be sent for not allowed origin")
+	}
+	if w.Body.String() != "ok" {
+		t.Error("Request should still be processed")
+	}
+
+	// denied response varies by origin as well, so caches don't serve it to allowed origins
+	plain := gin.New()
+	plain.GET("/plain", Middleware(s), func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	req = httptest.NewRequest("GET", "/plain", nil)
+	req.Header.Set("Origin", "https://evil.com")
+	w = httptest.NewRecorder()
+	plain.ServeHTTP(w, req)
+	if w.Header().Get("Vary") != "Origin" {
+		t.Error("Response for not allowed origin should vary by origin: ", w.Header())
+	}
+}
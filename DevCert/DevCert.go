@@ -0,0 +1,257 @@
+package DevCert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// default location of development CA, certificates signed by it are trusted once it's added to the system store
+const (
+	CACertPath = "TLS/ca.pem"
+	CAKeyPath  = "TLS/ca-key.pem"
+)
+
+const (
+	caValidity   = 10 * 365 * 24 * time.Hour
+	leafValidity = 365 * 24 * time.Hour
+)
+
+// CA signs development certificates
+type CA struct {
+	Cert *x509.Certificate
+	Key  *ecdsa.PrivateKey
+}
+
+func serialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+}
+
+func encode(der []byte, key *ecdsa.PrivateKey) ([]byte, []byte, error) {
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), nil
+}
+
+// NewCA creates self signed development CA
+func NewCA() (*CA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "ReverseProxy development CA " + host, Organization: []string{"ReverseProxy development"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(caValidity),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		MaxPathLenZero:        true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{Cert: cert, Key: key}, nil
+}
+
+// LoadCA reads CA certificate and EC key written by Save
+func LoadCA(certPath, keyPath string) (*CA, error) {
+	pair, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, errors.New("Can't load development CA. Error: " + err.Error())
+	}
+	key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("Development CA key should be EC key: " + keyPath)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	if !cert.IsCA {
+		return nil, errors.New("Certificate isn't CA: " + certPath)
+	}
+	return &CA{Cert: cert, Key: key}, nil
+}
+
+// LoadOrCreateCA reads CA from the files, new CA is created and saved if they don't exist.
+// Second value reports whether CA was created
+func LoadOrCreateCA(certPath, keyPath string) (*CA, bool, error) {
+	if _, err := os.Stat(certPath); err == nil {
+		ca, err := LoadCA(certPath, keyPath)
+		return ca, false, err
+	}
+	ca, err := NewCA()
+	if err != nil {
+		return nil, false, err
+	}
+	return ca, true, ca.Save(certPath, keyPath)
+}
+
+// Save writes CA certificate and key in PEM format
+func (ca *CA) Save(certPath, keyPath string) error {
+	certPem, keyPem, err := encode(ca.Cert.Raw, ca.Key)
+	if err != nil {
+		return err
+	}
+	return WriteFiles(certPath, keyPath, certPem, keyPem)
+}
+
+// Issue creates leaf certificate for hosts, IP addresses are put into IP SANs. Certificate and key are PEM encoded
+func (ca *CA) Issue(hosts []string) ([]byte, []byte, error) {
+	if len(hosts) == 0 {
+		return nil, nil, errors.New("At least one host is required for development certificate")
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: hosts[0], Organization: []string{"ReverseProxy development"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(leafValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Cert, &key.PublicKey, ca.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPem, keyPem, err := encode(der, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	// chain includes CA, so clients that trust it can verify the leaf
+	return append(certPem, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Cert.Raw})...), keyPem, nil
+}
+
+// Certificate issues in-memory leaf certificate for hosts
+func (ca *CA) Certificate(hosts []string) (*tls.Certificate, error) {
+	certPem, keyPem, err := ca.Issue(hosts)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(certPem, keyPem)
+	return &cert, err
+}
+
+// WriteFiles writes certificate and key, key is readable only by the owner
+func WriteFiles(certPath, keyPath string, certPem, keyPem []byte) error {
+	for _, path := range []string{certPath, keyPath} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+	}
+	if err := ioutil.WriteFile(certPath, certPem, 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(keyPath, keyPem, 0600)
+}
+
+// Hosts collects names the proxy is reached by from settings: loopback names, 'Addr', host of 'ProxyAddr',
+// ACME domains and server names of tcp listeners
+func Hosts(file map[string]interface{}) []string {
+	var hosts []string
+	seen := map[string]bool{}
+	add := func(h string) {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if h == "" || h == "0.0.0.0" || h == "::" || seen[h] {
+			return
+		}
+		seen[h] = true
+		hosts = append(hosts, h)
+	}
+	for _, h := range []string{"localhost", "127.0.0.1", "::1"} {
+		add(h)
+	}
+
+	if v, ok := file["Addr"].(string); ok {
+		add(v)
+	}
+	if v, ok := file["ProxyAddr"].(string); ok {
+		if host, _, err := net.SplitHostPort(v); err == nil {
+			add(host)
+		} else {
+			add(v)
+		}
+	}
+	if acme, ok := file["Acme"].(map[string]interface{}); ok {
+		for _, d := range stringList(field(acme, "domains")) {
+			add(d)
+		}
+	}
+	if protocols, ok := file["Protocols"].([]interface{}); ok {
+		for _, v := range protocols {
+			p, ok := v.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if sni, ok := field(p, "sni").(map[string]interface{}); ok {
+				for name := range sni {
+					add(name)
+				}
+			}
+		}
+	}
+	return hosts
+}
+
+// settings keys are matched case-insensitively, as mapstructure does
+func field(m map[string]interface{}, name string) interface{} {
+	for k, v := range m {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return nil
+}
+
+func stringList(v interface{}) []string {
+	var rv []string
+	list, _ := v.([]interface{})
+	for _, item := range list {
+		if s, ok := item.(string); ok {
+			rv = append(rv, s)
+		}
+	}
+	return rv
+}
@@ -0,0 +1,62 @@
+package DevCert
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHosts(t *testing.T) {
+	file := map[string]interface{}{
+		"Addr":      "192.168.0.101",
+		"ProxyAddr": "api.local:8080",
+		"Acme":      map[string]interface{}{"Domains": []interface{}{"example.test", "API.local"}},
+		"Protocols": []interface{}{map[string]interface{}{"type": "tcp", "sni": map[string]interface{}{"db.local": []interface{}{}}}},
+	}
+	expected := []string{"localhost", "127.0.0.1", "::1", "192.168.0.101", "api.local", "example.test", "db.local"}
+	hosts := Hosts(file)
+	if len(hosts) != len(expected) {
+		t.Fatal("Unexpected hosts: ", hosts)
+	}
+	for i := range expected {
+		if hosts[i] != expected[i] {
+			t.Error("Unexpected hosts: ", hosts)
+		}
+	}
+}
+
+func TestIssue(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "devcert")
+	defer os.RemoveAll(dir)
+	certPath, keyPath := filepath.Join(dir, "TLS", "ca.pem"), filepath.Join(dir, "TLS", "ca-key.pem")
+
+	ca, created, err := LoadOrCreateCA(certPath, keyPath)
+	if err != nil || !created {
+		t.Fatal("CA should be created: ", err)
+	}
+	if info, err := os.Stat(keyPath); err != nil || info.Mode().Perm() != 0600 {
+		t.Error("CA key should be readable only by the owner")
+	}
+	loaded, created, err := LoadOrCreateCA(certPath, keyPath)
+	if err != nil || created || !loaded.Cert.Equal(ca.Cert) {
+		t.Fatal("Existing CA should be reused: ", err)
+	}
+
+	cert, err := loaded.Certificate([]string{"localhost", "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Cert)
+	for _, name := range []string{"localhost", "127.0.0.1"} {
+		if _, err := leaf.Verify(x509.VerifyOptions{DNSName: name, Roots: roots}); err != nil {
+			t.Error("Certificate should be valid for "+name+": ", err)
+		}
+	}
+	if len(cert.Certificate) != 2 {
+		t.Error("Chain should include CA certificate")
+	}
+}
@@ -0,0 +1,144 @@
+package Endpoint
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptrace"
+	auth "proxy/Authentication"
+	"proxy/Forwarding"
+	"proxy/Logger"
+	"proxy/RequestID"
+	"proxy/Tracing"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type accessKey struct{}
+
+// upstream part of access log entry, filled by transport while request is proxied
+type upstreamRecord struct {
+	mu      sync.Mutex
+	addr    string
+	status  int
+	latency time.Duration
+	// connections request was sent over, transport sends request again if reused connection turns out broken
+	attempts int
+}
+
+func recordFromContext(ctx context.Context) *upstreamRecord {
+	r, _ := ctx.Value(accessKey{}).(*upstreamRecord)
+	return r
+}
+
+// returns record of the request, it's created by the first of access log and metrics middlewares
+func ensureRecord(c *gin.Context) *upstreamRecord {
+	if r := recordFromContext(c.Request.Context()); r != nil {
+		return r
+	}
+	r := &upstreamRecord{}
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), accessKey{}, r))
+	return r
+}
+
+// recordingTransport saves upstream address, status and time to response headers of proxied request
+// and measures upstream latency and connection pool usage. Each attempt is traced
+type recordingTransport struct {
+	next http.RoundTripper
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	next := t.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	upstream := req.URL.Host
+	// transport gets connection for every attempt of its own
+	var conns int32
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		GetConn: func(string) { atomic.AddInt32(&conns, 1) },
+	}))
+	// every attempt is client span of its own, upstream gets its trace context
+	span, req := Tracing.StartClient(req.Context(), req, req.Method)
+	inFlight := upstreamInFlight.With(upstream)
+	inFlight.Inc()
+	start := time.Now()
+	resp, err := next.RoundTrip(traceConnections(req))
+	latency := time.Since(start)
+	inFlight.Dec()
+	upstreamDuration.With(upstream).Observe(latency.Seconds())
+	if err != nil {
+		span.SetError(err.Error())
+	} else {
+		span.SetStatusCode(resp.StatusCode)
+	}
+	span.End()
+
+	r := recordFromContext(req.Context())
+	if r == nil {
+		return resp, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if n := int(atomic.LoadInt32(&conns)); n > 1 {
+		r.attempts += n
+	} else {
+		r.attempts++
+	}
+	r.addr = upstream
+	r.latency += latency
+	if resp != nil {
+		r.status = resp.StatusCode
+	}
+	return resp, err
+}
+
+// AccessLog writes access log entry after each request, it should be used before auth middlewares
+// to see their decisions. Nothing is done if access log is disabled
+func AccessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		logger := Logger.Access()
+		if logger == nil {
+			c.Next()
+			return
+		}
+
+		start := time.Now()
+		record := ensureRecord(c)
+		c.Next()
+
+		req := c.Request
+		entry := &Logger.AccessEntry{
+			Time:      start,
+			ClientIP:  Forwarding.ClientIP(req),
+			Method:    req.Method,
+			Path:      req.RequestURI,
+			Protocol:  req.Proto,
+			Host:      req.Host,
+			Status:    c.Writer.Status(),
+			Bytes:     c.Writer.Size(),
+			Duration:  time.Since(start),
+			Referer:   req.Referer(),
+			UserAgent: req.UserAgent(),
+			Route:     c.FullPath(),
+			RequestID: RequestID.FromContext(req.Context()),
+		}
+		entry.AuthName, entry.Auth = auth.Decision(c)
+		claims := auth.Claims(c)
+		entry.User = claims["sub"]
+		if entry.User == "" {
+			entry.User = claims["common_name"]
+		}
+
+		record.mu.Lock()
+		entry.Upstream, entry.UpstreamStatus, entry.UpstreamLatency = record.addr, record.status, record.latency
+		if record.attempts > 1 {
+			entry.Retries = record.attempts - 1
+		}
+		record.mu.Unlock()
+
+		logger.Log(entry)
+	}
+}
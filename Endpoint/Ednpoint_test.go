@@ -1,10 +1,42 @@
 package Endpoint
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"os"
+	"path/filepath"
+	auth "proxy/Authentication"
+	"proxy/Cache"
+	"proxy/Cors"
+	"proxy/Headers"
+	"proxy/Logger"
+	"proxy/Metrics"
+	"proxy/Protocol"
+	"proxy/RequestID"
+	"strings"
 	"testing"
+	"time"
 )
 
+func initTestEnvironment() {
+	if l == nil {
+		Logger.Init(uint32(Logger.LError), Logger.UseStdOut, nil)
+		l = Logger.New("Endpoint", 0, nil)
+	}
+	Protocol.Protocols = []Protocol.Protocol{{Type: "http", Port: 8080}}
+	gin.SetMode(gin.TestMode)
+}
+
 func TestEndpointSettings_Validate(t *testing.T) {
+	initTestEnvironment()
+
 	var end EndpointSettings = EndpointSettings{Entry_url: "ad", Redir_url: "Asd", Redir_addr: "adA",
 		Methods: []string{"POST", "GET"}, Use_auth: true, Auth_name: "adasd"}
 
@@ -47,3 +79,288 @@ func TestEndpointSettings_Validate(t *testing.T) {
 	err = end.Validate()
 	if err != nil {t.Error(err)}
 }
+
+func TestStreamingEndpoint(t *testing.T) {
+	initTestEnvironment()
+
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != "identity" {
+			t.Error("Compression should be disabled for streaming endpoints")
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	engine := gin.New()
+	registerEndpoint(engine, &EndpointSettings{Entry_url: "/events", Redir_url: "/", Protocol: "http",
+		Redir_addr: strings.TrimPrefix(upstream.URL, "http://"), Methods: []string{"GET"}, Streaming: true})
+	front := httptest.NewServer(engine)
+	defer front.Close()
+
+	req, _ := http.NewRequest("GET", front.URL + "/events", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {t.Fatal(err)}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("X-Accel-Buffering") != "no" {t.Error("X-Accel-Buffering header should be set to 'no'")}
+
+	// event must be delivered while upstream still holds the stream open
+	line := make(chan string, 1)
+	go func() {
+		s, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		line <- s
+	}()
+
+	select {
+	case s := <-line:
+		if s != "data: first\n" {t.Error("Unexpected event received: " + s)}
+	case <-time.After(2 * time.Second):
+		t.Error("Event wasn't flushed to the client")
+	}
+}
+
+func TestStreamingIdleTimeout(t *testing.T) {
+	initTestEnvironment()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer upstream.Close()
+
+	engine := gin.New()
+	registerEndpoint(engine, &EndpointSettings{Entry_url: "/events", Redir_url: "/", Protocol: "http",
+		Redir_addr: strings.TrimPrefix(upstream.URL, "http://"), Methods: []string{"GET"}, Streaming: true,
+		Stream_idle_timeout: 1})
+
+	done := make(chan struct{})
+	go func() {
+		engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/events", nil))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("Idle stream should be cut after 'stream_idle_timeout'")
+	}
+}
+
+func TestHeaderRules(t *testing.T) {
+	initTestEnvironment()
+
+	var received http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header
+		w.Header().Set("Server", "backend")
+		w.Header().Set("X-Internal-Debug", "1")
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	rules := &Headers.Settings{
+		Request:  []Headers.Rule{{Action: "set", Name: "X-Route", Value: "${route}"}, {Action: "remove", Name: "Cookie"}},
+		Response: []Headers.Rule{{Action: "remove", Name: "Server"}, {Action: "remove", Name: "X-Internal-*"}},
+	}
+	if err := rules.Validate(); err != nil {t.Fatal(err)}
+
+	engine := gin.New()
+	registerEndpoint(engine, &EndpointSettings{Entry_url: "/api", Redir_url: "/", Protocol: "http",
+		Redir_addr: strings.TrimPrefix(upstream.URL, "http://"), Methods: []string{"GET"}, Headers: rules})
+
+	front := httptest.NewServer(engine)
+	defer front.Close()
+
+	req, _ := http.NewRequest("GET", front.URL + "/api", nil)
+	req.Header.Set("Cookie", "session=1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {t.Fatal(err)}
+	resp.Body.Close()
+
+	if received.Get("X-Route") != "/api" || received.Get("Cookie") != "" {
+		t.Error("Request rules should be applied before proxying, received: ", received)
+	}
+	if resp.Header.Get("Server") != "" || resp.Header.Get("X-Internal-Debug") != "" {
+		t.Error("Response rules should be applied to upstream response: ", resp.Header)
+	}
+}
+
+func TestHeaderRulesCached(t *testing.T) {
+	initTestEnvironment()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Server", "backend")
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	rules := &Headers.Settings{Response: []Headers.Rule{{Action: "remove", Name: "Server"},
+		{Action: "set", Name: "X-User", Value: "${claim:sub}"}}}
+	if err := rules.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	Cache.InitCache(map[string]interface{}{})
+	cache := &Cache.EndpointSettings{Enabled: true}
+	if err := cache.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	engine := gin.New()
+	// claims of authenticated user
+	engine.Use(func(c *gin.Context) { c.Set(auth.ClaimsKey, map[string]string{"sub": c.GetHeader("X-Test-User")}) })
+	registerEndpoint(engine, &EndpointSettings{Entry_url: "/cached", Redir_url: "/", Protocol: "http",
+		Redir_addr: strings.TrimPrefix(upstream.URL, "http://"), Methods: []string{"GET"}, Headers: rules,
+		Cache: cache})
+
+	// the first user fills the cache, the second one gets stored response
+	for i, user := range []string{"alice", "bob"} {
+		xcache := []string{Cache.Miss, Cache.Hit}[i]
+		req := httptest.NewRequest("GET", "/cached", nil)
+		req.Header.Set("X-Test-User", user)
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		if w.Header().Get("X-User") != user || w.Header().Get("Server") != "" || w.Header().Get("X-Cache") != xcache {
+			t.Error("Response of "+user+" should carry its own claim: ", w.Header())
+		}
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	initTestEnvironment()
+	dir, _ := ioutil.TempDir("", "access")
+	defer os.RemoveAll(dir)
+	Logger.InitAccess(&Logger.AccessSettings{File: filepath.Join(dir, "access.log")})
+	defer Logger.InitAccess(nil)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// upstream that echoes request id shouldn't make it duplicated
+		w.Header().Set("X-Request-Id", r.Header.Get("X-Request-Id"))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+	upstreamAddr := strings.TrimPrefix(upstream.URL, "http://")
+
+	engine := gin.New()
+	engine.Use(RequestID.Middleware(&RequestID.Global), AccessLog())
+	registerEndpoint(engine, &EndpointSettings{Entry_url: "/api", Redir_url: "/", Protocol: "http",
+		Redir_addr: upstreamAddr, Methods: []string{"GET"}})
+	front := httptest.NewServer(engine)
+	defer front.Close()
+
+	req, _ := http.NewRequest("GET", front.URL + "/api?q=1", nil)
+	req.Header.Set("X-Request-Id", "req-1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {t.Fatal(err)}
+	resp.Body.Close()
+	if v := resp.Header.Values("X-Request-Id"); len(v) != 1 || v[0] != "req-1" {
+		t.Error("Incoming request id should be returned once: ", v)
+	}
+
+	data, _ := ioutil.ReadFile(filepath.Join(dir, "access.log"))
+	var entry map[string]interface{}
+	if err := json.Unmarshal(data, &entry); err != nil {t.Fatal("Access log should contain json entry: " + string(data))}
+	if entry["status"] != float64(201) || entry["bytes"] != float64(2) || entry["path"] != "/api?q=1" ||
+		entry["route"] != "/api" || entry["request_id"] != "req-1" || entry["client_ip"] != "127.0.0.1" {
+		t.Error("Unexpected access log entry: ", entry)
+	}
+	if entry["upstream"] != upstreamAddr || entry["upstream_status"] != float64(201) || entry["retries"] != float64(0) {
+		t.Error("Upstream should be reported: ", entry)
+	}
+}
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestAccessLogRetries(t *testing.T) {
+	// transport that sends request again over new connection, as it does when reused one is broken
+	retrying := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		trace := httptrace.ContextClientTrace(req.Context())
+		trace.GetConn(req.URL.Host)
+		trace.GetConn(req.URL.Host)
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader("")), Request: req}, nil
+	})
+	record := &upstreamRecord{}
+	req := httptest.NewRequest("GET", "http://upstream/", nil)
+	req = req.WithContext(context.WithValue(req.Context(), accessKey{}, record))
+	if _, err := (&recordingTransport{next: retrying}).RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	if record.attempts != 2 || record.addr != "upstream" {
+		t.Error("Attempts made by transport should be recorded: ", record.attempts)
+	}
+}
+
+func TestRequestMetrics(t *testing.T) {
+	initTestEnvironment()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer upstream.Close()
+	upstreamAddr := strings.TrimPrefix(upstream.URL, "http://")
+
+	engine := gin.New()
+	engine.Use(RequestMetrics())
+	registerEndpoint(engine, &EndpointSettings{Entry_url: "/metered/:id", Redir_url: "/", Protocol: "http",
+		Redir_addr: upstreamAddr, Methods: []string{"GET"}})
+	front := httptest.NewServer(engine)
+	defer front.Close()
+
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(front.URL + "/metered/1")
+		if err != nil {t.Fatal(err)}
+		resp.Body.Close()
+	}
+
+	w := httptest.NewRecorder()
+	Metrics.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	out := w.Body.String()
+	for _, line := range []string{
+		`proxy_http_requests_total{route="/metered/:id",method="GET",status_class="4xx",upstream="` + upstreamAddr + `"} 2`,
+		`proxy_http_request_duration_seconds_count{route="/metered/:id",method="GET",status_class="4xx",upstream="` + upstreamAddr + `"} 2`,
+		`proxy_http_requests_in_flight{route="/metered/:id",method="GET"} 0`,
+		`proxy_upstream_request_duration_seconds_count{upstream="` + upstreamAddr + `"} 2`,
+		`proxy_upstream_connections_total{upstream="` + upstreamAddr + `",state="new"} 1`,
+		`proxy_upstream_connections_total{upstream="` + upstreamAddr + `",state="reused"} 1`,
+	} {
+		if !strings.Contains(out, line + "\n") {
+			t.Error("Metrics should contain: " + line)
+		}
+	}
+}
+
+func TestCorsOnDeniedRequest(t *testing.T) {
+	initTestEnvironment()
+	auth.AuthHandlers["deny"] = gin.HandlersChain{func(c *gin.Context) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "denied"})
+	}}
+	defer delete(auth.AuthHandlers, "deny")
+	cors := &Cors.Settings{Enabled: true, Allowed_origins: []string{"https://app.example.com"}}
+	if err := cors.Validate(); err != nil {t.Fatal(err)}
+
+	engine := gin.New()
+	registerEndpoint(engine, &EndpointSettings{Entry_url: "/private", Redir_url: "/", Protocol: "http",
+		Redir_addr: "127.0.0.1:1", Methods: []string{"GET"}, Use_auth: true, Auth_name: "deny", Cors: cors})
+
+	req := httptest.NewRequest("GET", "/private", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized || w.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Error("Response denied by auth should carry CORS headers: ", w.Code, w.Header())
+	}
+}
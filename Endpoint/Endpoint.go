@@ -1,18 +1,32 @@
 package Endpoint
 
 import (
+	"context"
 	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/mitchellh/mapstructure"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	auth "proxy/Authentication"
+	"proxy/Cache"
+	"proxy/Compression"
+	"proxy/Cors"
+	"proxy/Forwarding"
+	"proxy/Headers"
 	"proxy/Logger"
 	"proxy/Protocol"
+	"proxy/ProxyProtocol"
+	"proxy/RequestID"
+	"strconv"
+	"time"
 )
 
 var l *Logger.Logger
 
+// entry urls that already have OPTIONS route answering CORS preflight
+var preflightRoutes = map[string]bool{}
+
 //json description of the struct isn't obligatory
 type EndpointSettings struct {
 	Entry_url string
@@ -22,6 +36,24 @@ type EndpointSettings struct {
 	Auth_name string
 	Protocol string
 	Methods []string
+	// total deadline for proxied request in seconds, 0 means no deadline. Not used for streaming endpoints
+	Timeout int
+	// flush every upstream write to the client immediately (SSE, chunked streams)
+	Streaming bool
+	// seconds without any data from upstream after which streaming request is cut
+	Stream_idle_timeout int
+	// overrides global 'Compression' settings for this endpoint
+	Compression *Compression.Settings
+	// response caching, disabled by default
+	Cache *Cache.EndpointSettings
+	// overrides global 'Cors' settings for this endpoint
+	Cors *Cors.Settings
+	// header rules applied after global 'Headers' rules
+	Headers *Headers.Settings
+	// send Host requested by the client to upstream, also enabled by global 'Forwarding' settings
+	Preserve_host bool
+	// PROXY protocol version ('v1' or 'v2') sent at the start of every upstream connection, empty to disable
+	Send_proxy_protocol string
 }
 
 func (endSet *EndpointSettings) Validate() error {
@@ -40,11 +72,20 @@ func (endSet *EndpointSettings) Validate() error {
 	}
 
 	if endSet.Protocol == "" {
-		endSet.Protocol = Protocol.Protocols[0].Type
+		// tcp and udp listeners don't serve endpoints
+		for i := range Protocol.Protocols {
+			if Protocol.Protocols[i].IsHTTP() {
+				endSet.Protocol = Protocol.Protocols[i].Type
+				break
+			}
+		}
+		if endSet.Protocol == "" {
+			panic("Endpoint " + endSet.Entry_url + " requires http or https protocol")
+		}
 	} else {
 		found := false
 		for _,v := range Protocol.Protocols {
-			if v.Type == endSet.Protocol {
+			if v.Type == endSet.Protocol && v.IsHTTP() {
 				found = true
 				break
 			}
@@ -53,6 +94,42 @@ func (endSet *EndpointSettings) Validate() error {
 		if found == false {panic("Endpoint " + endSet.Entry_url + "  use invalid protocol: " + endSet.Protocol)}
 	}
 
+	if endSet.Timeout < 0 || endSet.Stream_idle_timeout < 0 {
+		return errors.New("Timeouts can't be negative under entry: " + endSet.Entry_url)
+	}
+
+	if endSet.Compression != nil {
+		if err := endSet.Compression.Validate(); err != nil {
+			return errors.New(err.Error() + " under entry: " + endSet.Entry_url)
+		}
+	}
+
+	if endSet.Cache != nil {
+		if err := endSet.Cache.Validate(); err != nil {
+			return errors.New(err.Error() + " under entry: " + endSet.Entry_url)
+		}
+		if endSet.Cache.Enabled && endSet.Streaming {
+			return errors.New("Streaming endpoint can't be cached: " + endSet.Entry_url)
+		}
+	}
+
+	if endSet.Cors != nil && endSet.Cors.Enabled {
+		if err := endSet.Cors.Validate(); err != nil {
+			return errors.New(err.Error() + " under entry: " + endSet.Entry_url)
+		}
+	}
+
+	if endSet.Headers != nil {
+		if err := endSet.Headers.Validate(); err != nil {
+			return errors.New(err.Error() + " under entry: " + endSet.Entry_url)
+		}
+	}
+
+	if endSet.Send_proxy_protocol != "" && endSet.Send_proxy_protocol != "v1" && endSet.Send_proxy_protocol != "v2" {
+		return errors.New("Unsupported PROXY protocol version: " + endSet.Send_proxy_protocol + " under entry: " +
+			endSet.Entry_url + ". Supported: v1, v2")
+	}
+
 	if endSet.Use_auth && endSet.Auth_name == "" {
 		return errors.New("Auth name should be specified if 'use_auth' is true")
 	}
@@ -62,39 +139,189 @@ func (endSet *EndpointSettings) Validate() error {
 
 func registerEndpoint(engine *gin.Engine, settings *EndpointSettings) {
 
-	var groupRoute *gin.RouterGroup = nil
+	var authHandlers gin.HandlersChain
 	if settings.Use_auth {
-		if v, ok := auth.AuthMiddlewares[settings.Auth_name]; ok {
-			groupRoute = v
+		if v, ok := auth.AuthHandlers[settings.Auth_name]; ok {
+			authHandlers = v
 		} else {
 			panic("Trying to register endpoint with unexisted auth name: " + settings.Auth_name)
 		}
 	}
 
-	redirectionMethod := func(c *gin.Context) {
-		l.Info(map[string]string{}, "Request made for Url: " + settings.Entry_url)
+	var cache *Cache.Handler
+	if settings.Cache != nil && settings.Cache.Enabled {
+		cache = Cache.New(settings.Cache)
+	}
+
+	headerRules := Headers.Merge(Headers.Global, settings.Headers)
+	responseRules, clientRules := headerRules.Response, []Headers.Rule(nil)
+	if cache != nil {
+		// values of one client must not be stored with the response and served to others
+		responseRules, clientRules = Headers.SplitPerRequest(headerRules.Response)
+	}
+
+	var transport http.RoundTripper
+	if settings.Send_proxy_protocol == "v1" {
+		transport = ProxyProtocol.NewTransport(1)
+	} else if settings.Send_proxy_protocol == "v2" {
+		transport = ProxyProtocol.NewTransport(2)
+	}
+	usesProxyProtocol := transport != nil
+	// upstream of the request is reported to access log
+	transport = &recordingTransport{next: transport}
 
+	// proxies request to the endpoint upstream
+	upstream := func(w http.ResponseWriter, req *http.Request) {
 		// still unclear what is the difference between req.Url.Host and req.Host
 		director := func(req *http.Request) {
 			req.URL.Host = settings.Redir_addr
 			req.URL.Path = settings.Redir_url
 			req.URL.Scheme = settings.Protocol
 
-			req.Host = settings.Redir_addr
+			Forwarding.Global.Apply(req)
+			if !settings.Preserve_host && !Forwarding.Global.Preserve_host {
+				req.Host = settings.Redir_addr
+			}
+
+			// compressed upstream response would be buffered by compressor on the upstream side
+			if settings.Streaming {
+				req.Header.Set("Accept-Encoding", "identity")
+			}
+
+			Headers.Apply(headerRules.Request, req.Header, Headers.FromContext(req.Context()))
+			// Host header is taken from req.Host, so rules changing it should be moved there
+			if host := req.Header.Get("Host"); host != "" {
+				req.Host = host
+				req.Header.Del("Host")
+			}
 		}
 
-		proxy := &httputil.ReverseProxy{Director: director}
-		proxy.ServeHTTP(c.Writer, c.Request)
+		modifyResponse := func(resp *http.Response) error {
+			// client gets request id set by the proxy, not the one echoed by upstream
+			if RequestID.FromContext(resp.Request.Context()) != "" {
+				resp.Header.Del(RequestID.Global.Header)
+			}
+			Headers.Apply(responseRules, resp.Header, Headers.FromContext(resp.Request.Context()))
+			return nil
+		}
+
+		proxy := &httputil.ReverseProxy{Director: director, ModifyResponse: modifyResponse, Transport: transport,
+			ErrorHandler: proxyError}
+		if usesProxyProtocol {
+			req = req.WithContext(ProxyProtocol.NewContext(req.Context(), clientAddr(req), localAddr(req)))
+		}
+
+		if settings.Streaming {
+			var cancel context.CancelFunc
+			req, cancel = configureStreaming(proxy, w, req, settings)
+			defer cancel()
+		} else if settings.Timeout > 0 {
+			ctx, cancel := context.WithTimeout(req.Context(), time.Duration(settings.Timeout) * time.Second)
+			defer cancel()
+			req = req.WithContext(ctx)
+		}
+
+		proxy.ServeHTTP(w, Forwarding.Global.Prepare(req))
 	}
 
-	for _, method := range settings.Methods {
+	redirectionMethod := func(c *gin.Context) {
+		clientIP := Forwarding.ClientIP(c.Request)
+		// requests are reported by access log, this one is only for debugging
+		l.WithContext(c.Request.Context()).Debug(map[string]string{"client_ip": clientIP},
+			"Request made for Url: " + settings.Entry_url)
 
-		if groupRoute == nil {
-			engine.Handle(method, settings.Entry_url, redirectionMethod)
-		} else {
-			groupRoute.Handle(method, settings.Entry_url, redirectionMethod)
+		values := &Headers.Context{
+			ClientIP:  clientIP,
+			RequestID: RequestID.FromContext(c.Request.Context()),
+			Route:     settings.Entry_url,
+			Host:      c.Request.Host,
+			Method:    c.Request.Method,
+			Path:      c.Request.URL.Path,
+			Claims:    auth.Claims(c),
+		}
+		req := c.Request.WithContext(Headers.NewContext(c.Request.Context(), values))
+
+		if cache != nil {
+			var w http.ResponseWriter = c.Writer
+			if len(clientRules) != 0 {
+				w = Headers.NewResponseWriter(w, clientRules, values)
+			}
+			cache.Serve(w, req, http.HandlerFunc(upstream))
+			return
 		}
+		upstream(c.Writer, req)
+	}
+
+	handlers := endpointMiddlewares(settings, authHandlers)
+	handlers = append(handlers, redirectionMethod)
+
+	// preflight requests carry no credentials, so they are answered before authentication
+	if cors := corsSettings(settings); cors != nil && !preflightRoutes[settings.Entry_url] {
+		engine.OPTIONS(settings.Entry_url, Cors.Preflight(cors, settings.Methods))
+		preflightRoutes[settings.Entry_url] = true
+	}
+
+	for _, method := range settings.Methods {
+		engine.Handle(method, settings.Entry_url, handlers...)
+	}
+}
+
+// answers request that couldn't be proxied, error is logged with id of the request
+func proxyError(w http.ResponseWriter, req *http.Request, err error) {
+	l.WithContext(req.Context()).WithFields(Logger.String("upstream", req.URL.Host), Logger.Err(err)).
+		Error(nil, "Can't proxy request to upstream")
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+// address of the client that made request, used as source of PROXY protocol header
+func clientAddr(req *http.Request) net.Addr {
+	port := 0
+	if _, p, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		port, _ = strconv.Atoi(p)
+	}
+	return &net.TCPAddr{IP: net.ParseIP(Forwarding.ClientIP(req)), Port: port}
+}
+
+// address of the listener that accepted request, nil if it's unknown
+func localAddr(req *http.Request) net.Addr {
+	if addr, ok := req.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		return addr
+	}
+	return nil
+}
+
+// returns CORS settings that should be applied to the endpoint, nil if CORS is disabled
+func corsSettings(settings *EndpointSettings) *Cors.Settings {
+	cors := Cors.Global
+	if settings.Cors != nil {
+		cors = settings.Cors
 	}
+	if cors == nil || !cors.Enabled {
+		return nil
+	}
+	return cors
+}
+
+// builds list of middlewares that should be executed before proxying request to the endpoint.
+// CORS goes before auth, so browsers can read responses of denied requests
+func endpointMiddlewares(settings *EndpointSettings, authHandlers gin.HandlersChain) []gin.HandlerFunc {
+	var rv []gin.HandlerFunc
+
+	if cors := corsSettings(settings); cors != nil {
+		rv = append(rv, Cors.Middleware(cors))
+	}
+	rv = append(rv, authHandlers...)
+
+	compression := Compression.Global
+	if settings.Compression != nil {
+		compression = settings.Compression
+	}
+	// streamed responses must reach the client as is
+	if compression != nil && compression.Enabled && !settings.Streaming {
+		rv = append(rv, Compression.Middleware(compression))
+	}
+
+	return rv
 }
 
 func RegisterEndpoints(cl *gin.Engine, file map[string]interface{}) {
@@ -120,11 +347,12 @@ func readEndpointsFromFile(cl *gin.Engine, file interface{}) {
 		endpoints = append(endpoints, tmp)
 	}
 
-	for _, endp := range endpoints {
-		err := endp.Validate()
+	// settings are captured by handlers, so each endpoint should get its own pointer
+	for i := range endpoints {
+		err := endpoints[i].Validate()
 		if err != nil {
 			panic(err.Error())
 		}
-		registerEndpoint(cl, &endp)
+		registerEndpoint(cl, &endpoints[i])
 	}
 }
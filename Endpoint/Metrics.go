@@ -0,0 +1,75 @@
+package Endpoint
+
+import (
+	"net/http"
+	"net/http/httptrace"
+	"proxy/Metrics"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	requests = Metrics.NewCounter("proxy_http_requests_total",
+		"Requests served by the proxy.", "route", "method", "status_class", "upstream")
+	requestDuration = Metrics.NewHistogram("proxy_http_request_duration_seconds",
+		"Time requests took, including auth and upstream.", Metrics.DefaultBuckets, "route", "method", "status_class", "upstream")
+	requestsInFlight = Metrics.NewGauge("proxy_http_requests_in_flight",
+		"Requests that are being served.", "route", "method")
+
+	upstreamDuration = Metrics.NewHistogram("proxy_upstream_request_duration_seconds",
+		"Time upstreams took to return response headers.", Metrics.DefaultBuckets, "upstream")
+	upstreamInFlight = Metrics.NewGauge("proxy_upstream_requests_in_flight",
+		"Requests that are being sent to upstreams.", "upstream")
+	upstreamConnections = Metrics.NewCounter("proxy_upstream_connections_total",
+		"Connections taken from pool for upstream requests, state is new or reused.", "upstream", "state")
+	upstreamIdle = Metrics.NewHistogram("proxy_upstream_connection_idle_seconds",
+		"Time reused connections were idle in the pool.", Metrics.DefaultBuckets, "upstream")
+	upstreamDialErrors = Metrics.NewCounter("proxy_upstream_dial_errors_total",
+		"Connections to upstreams that couldn't be made.", "upstream")
+)
+
+// RequestMetrics counts requests and their latencies by route, method, status class and upstream.
+// Upstream is the last one request was sent to, it's empty if response was made by the proxy itself
+func RequestMetrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route, method := c.FullPath(), c.Request.Method
+		inFlight := requestsInFlight.With(route, method)
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		start := time.Now()
+		record := ensureRecord(c)
+		c.Next()
+
+		record.mu.Lock()
+		upstream := record.addr
+		record.mu.Unlock()
+		labels := []string{route, method, Metrics.StatusClass(c.Writer.Status()), upstream}
+		requests.With(labels...).Inc()
+		requestDuration.With(labels...).Observe(time.Since(start).Seconds())
+	}
+}
+
+// traceConnections adds trace of connection pool usage to upstream request
+func traceConnections(req *http.Request) *http.Request {
+	upstream := req.URL.Host
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			state := "new"
+			if info.Reused {
+				state = "reused"
+			}
+			upstreamConnections.With(upstream, state).Inc()
+			if info.WasIdle {
+				upstreamIdle.With(upstream).Observe(info.IdleTime.Seconds())
+			}
+		},
+		ConnectDone: func(network, addr string, err error) {
+			if err != nil {
+				upstreamDialErrors.With(upstream).Inc()
+			}
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
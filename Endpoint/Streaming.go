@@ -0,0 +1,86 @@
+package Endpoint
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"time"
+)
+
+// idle timeout (in seconds) applied to streaming endpoints when 'stream_idle_timeout' isn't specified
+const defaultStreamIdleTimeout = 300
+
+// idleTimeoutTransport cancels the upstream request when no data was received
+// from upstream for longer than timeout. Used instead of the total request deadline for
+// streaming endpoints, so long living streams (SSE etc.) aren't cut while events are flowing.
+type idleTimeoutTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+	cancel  context.CancelFunc
+}
+
+// idleTimeoutBody resets idle timer on each read from upstream response body
+type idleTimeoutBody struct {
+	io.ReadCloser
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (t *idleTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	timer := time.AfterFunc(t.timeout, t.cancel)
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		timer.Stop()
+		return nil, err
+	}
+
+	resp.Body = &idleTimeoutBody{ReadCloser: resp.Body, timer: timer, timeout: t.timeout}
+	return resp, nil
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.timer.Reset(b.timeout)
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	return b.ReadCloser.Close()
+}
+
+// prepares proxy and request for streaming mode:
+// every write is flushed to the client immediately and request is cut only after being idle for 'stream_idle_timeout' seconds.
+// Returned cancel function should be called once request is served.
+func configureStreaming(proxy *httputil.ReverseProxy, w http.ResponseWriter, req *http.Request,
+	settings *EndpointSettings) (*http.Request, context.CancelFunc) {
+
+	timeout := settings.Stream_idle_timeout
+	if timeout <= 0 {
+		timeout = defaultStreamIdleTimeout
+	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+	req = req.WithContext(ctx)
+
+	base := proxy.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	proxy.FlushInterval = -1
+	proxy.Transport = &idleTimeoutTransport{
+		base:    base,
+		timeout: time.Duration(timeout) * time.Second,
+		cancel:  cancel,
+	}
+
+	// ask intermediate proxies (nginx etc.) not to buffer the response
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	return req, cancel
+}
@@ -0,0 +1,338 @@
+package Forwarding
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+const (
+	// incoming forwarding headers of trusted proxies are kept and this hop is appended to them
+	Append = "append"
+	// incoming forwarding headers are replaced by values describing the original client
+	Overwrite = "overwrite"
+	// forwarding headers aren't sent to upstream at all
+	Strip = "strip"
+)
+
+// global forwarding policy, append mode without trusted proxies is used if 'Forwarding' section is missing
+var Global = defaultSettings()
+
+type Settings struct {
+	// one of: append, overwrite, strip
+	Mode string
+	// send Host requested by the client to upstream instead of upstream address
+	Preserve_host bool
+	// also send RFC 7239 Forwarded header
+	Forwarded bool
+	// addresses or CIDRs of proxies in front of this one, which forwarding headers can be trusted
+	Trusted_proxies []string
+	// headers carrying details of client TLS certificate to upstream
+	Client_cert_headers *CertHeaders
+
+	trusted []*net.IPNet
+}
+
+// names of headers with client certificate details, detail isn't sent if its header name is empty.
+// Headers with these names sent by the client are always removed
+type CertHeaders struct {
+	// url encoded PEM of the certificate
+	Pem     string
+	Subject string
+	Serial  string
+	// SHA-256 fingerprint in hex
+	Fingerprint string
+}
+
+func defaultSettings() *Settings {
+	s := &Settings{}
+	s.Validate()
+	return s
+}
+
+func (s *Settings) Validate() error {
+	if s.Mode == "" {
+		s.Mode = Append
+	} else if s.Mode != Append && s.Mode != Overwrite && s.Mode != Strip {
+		return errors.New("Unsupported forwarding mode: " + s.Mode + ". Supported: append, overwrite, strip")
+	}
+
+	nets, err := ParseCIDRs(s.Trusted_proxies)
+	if err != nil {
+		return err
+	}
+	s.trusted = nets
+
+	return nil
+}
+
+// parses list of addresses and CIDRs. Single address is treated as network with one host
+func ParseCIDRs(list []string) ([]*net.IPNet, error) {
+	var rv []*net.IPNet
+	for _, v := range list {
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, errors.New("Invalid address: " + v)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			rv = append(rv, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, errors.New("Invalid CIDR: " + v + ". Error: " + err.Error())
+		}
+		rv = append(rv, n)
+	}
+	return rv, nil
+}
+
+// checks whether ip belongs to one of networks
+func ContainsIP(nets []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Read forwarding settings from parsed json value and validates it
+func ReadForwardingFromFile(v interface{}) *Settings {
+	var s Settings
+	if err := mapstructure.Decode(v, &s); err != nil {
+		panic("Can't decode forwarding settings. Error: " + err.Error())
+	}
+	if err := s.Validate(); err != nil {
+		panic(err.Error())
+	}
+
+	return &s
+}
+
+// Inits global forwarding policy from 'Forwarding' section, section is optional
+func InitForwarding(file map[string]interface{}) {
+	if v, exist := file["Forwarding"]; exist {
+		Global = ReadForwardingFromFile(v)
+	} else {
+		Global = defaultSettings()
+	}
+}
+
+// returns ip part of host:port address
+func remoteIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+func (s *Settings) isTrusted(ip string) bool {
+	return ContainsIP(s.trusted, net.ParseIP(strings.TrimSpace(ip)))
+}
+
+// returns X-Forwarded-For chain as list of addresses
+func forwardedFor(h http.Header) []string {
+	var rv []string
+	for _, v := range h.Values("X-Forwarded-For") {
+		for _, ip := range strings.Split(v, ",") {
+			if ip = strings.TrimSpace(ip); ip != "" {
+				rv = append(rv, ip)
+			}
+		}
+	}
+	return rv
+}
+
+// ClientIP returns address of the client that made request. X-Forwarded-For is used only
+// if request came from trusted proxy, and only its part added by trusted proxies is taken into account
+func (s *Settings) ClientIP(req *http.Request) string {
+	ip := remoteIP(req.RemoteAddr)
+	if !s.isTrusted(ip) {
+		return ip
+	}
+
+	chain := forwardedFor(req.Header)
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip = chain[i]
+		if !s.isTrusted(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// returns ClientIP of the request according to global policy
+func ClientIP(req *http.Request) string {
+	return Global.ClientIP(req)
+}
+
+// returns protocol, host and port requested by the client.
+// Values sent by trusted proxy are preferred over the ones of this hop
+func (s *Settings) original(req *http.Request) (string, string, string) {
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+	host := req.Host
+	port := listenerPort(req, proto)
+
+	if s.isTrusted(remoteIP(req.RemoteAddr)) {
+		if v := firstValue(req.Header.Get("X-Forwarded-Proto")); v != "" {
+			proto = v
+		}
+		if v := firstValue(req.Header.Get("X-Forwarded-Host")); v != "" {
+			host = v
+		}
+		if v := firstValue(req.Header.Get("X-Forwarded-Port")); v != "" {
+			port = v
+		}
+	}
+
+	return proto, host, port
+}
+
+func firstValue(v string) string {
+	return strings.TrimSpace(strings.Split(v, ",")[0])
+}
+
+// port of the listener that accepted request
+func listenerPort(req *http.Request, proto string) string {
+	if addr, ok := req.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		if tcp, ok := addr.(*net.TCPAddr); ok {
+			return strconv.Itoa(tcp.Port)
+		}
+	}
+	if _, port, err := net.SplitHostPort(req.Host); err == nil {
+		return port
+	}
+	if proto == "https" {
+		return "443"
+	}
+	return "80"
+}
+
+// formats node of Forwarded header, IPv6 addresses should be quoted and enclosed in brackets
+func forwardedNode(ip string) string {
+	if strings.Contains(ip, ":") {
+		return "\"[" + ip + "]\""
+	}
+	return ip
+}
+
+func forwardedElement(forIP string, host string, proto string) string {
+	return "for=" + forwardedNode(forIP) + ";host=\"" + host + "\";proto=" + proto
+}
+
+// Prepare returns copy of the request that should be passed to the reverse proxy with forwarding
+// headers set according to policy. X-Forwarded-For itself is appended by reverse proxy, so in
+// overwrite mode remote address of the copy is replaced by the client address
+func (s *Settings) Prepare(req *http.Request) *http.Request {
+	rv := new(http.Request)
+	*rv = *req
+	rv.Header = req.Header.Clone()
+	h := rv.Header
+	s.setClientCert(req, h)
+
+	peer := remoteIP(req.RemoteAddr)
+	proto, host, port := s.original(req)
+
+	if s.Mode == Strip || s.Mode == Overwrite || !s.isTrusted(peer) {
+		h.Del("Forwarded")
+		h.Del("X-Forwarded-For")
+		h.Del("X-Forwarded-Host")
+		h.Del("X-Forwarded-Proto")
+		h.Del("X-Forwarded-Port")
+	}
+	if s.Mode == Strip {
+		return rv
+	}
+
+	if h.Get("X-Forwarded-Proto") == "" {
+		h.Set("X-Forwarded-Proto", proto)
+	}
+	if h.Get("X-Forwarded-Host") == "" {
+		h.Set("X-Forwarded-Host", host)
+	}
+	if h.Get("X-Forwarded-Port") == "" {
+		h.Set("X-Forwarded-Port", port)
+	}
+
+	if s.Mode == Overwrite {
+		client := s.ClientIP(req)
+		_, remotePort, _ := net.SplitHostPort(req.RemoteAddr)
+		rv.RemoteAddr = net.JoinHostPort(client, remotePort)
+		if s.Forwarded {
+			h.Set("Forwarded", forwardedElement(client, host, proto))
+		}
+		return rv
+	}
+
+	if s.Forwarded {
+		thisProto := "http"
+		if req.TLS != nil {
+			thisProto = "https"
+		}
+		element := forwardedElement(peer, req.Host, thisProto)
+		if prior := h.Values("Forwarded"); len(prior) > 0 {
+			element = strings.Join(prior, ", ") + ", " + element
+		}
+		h.Set("Forwarded", element)
+	}
+
+	return rv
+}
+
+// sets client certificate headers from TLS connection of the request. Certificates the handshake
+// didn't verify (e.g. with 'request' client auth) aren't forwarded, upstream would have to trust them blindly
+func (s *Settings) setClientCert(req *http.Request, h http.Header) {
+	ch := s.Client_cert_headers
+	if ch == nil {
+		return
+	}
+	for _, name := range []string{ch.Pem, ch.Subject, ch.Serial, ch.Fingerprint} {
+		if name != "" {
+			h.Del(name)
+		}
+	}
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 {
+		return
+	}
+
+	cert := req.TLS.VerifiedChains[0][0]
+	sum := sha256.Sum256(cert.Raw)
+	set := func(name string, value string) {
+		if name != "" {
+			h.Set(name, value)
+		}
+	}
+	set(ch.Pem, url.PathEscape(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))))
+	set(ch.Subject, cert.Subject.String())
+	set(ch.Serial, cert.SerialNumber.String())
+	set(ch.Fingerprint, hex.EncodeToString(sum[:]))
+}
+
+// Apply finishes forwarding headers of the request going to upstream, should be called from the Director
+func (s *Settings) Apply(out *http.Request) {
+	if s.Mode == Strip {
+		// nil value tells reverse proxy not to populate the header
+		out.Header["X-Forwarded-For"] = nil
+	}
+}
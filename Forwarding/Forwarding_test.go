@@ -0,0 +1,168 @@
+package Forwarding
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func newSettings(t *testing.T, mode string, trusted ...string) *Settings {
+	s := &Settings{Mode: mode, Forwarded: true, Trusted_proxies: trusted}
+	if err := s.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSettings_Validate(t *testing.T) {
+	s := Settings{Mode: "replace"}
+	if err := s.Validate(); err == nil {
+		t.Error("Validate() should fail for unsupported mode")
+	}
+
+	s = Settings{Trusted_proxies: []string{"10.0.0.0/33"}}
+	if err := s.Validate(); err == nil {
+		t.Error("Validate() should fail for invalid CIDR")
+	}
+
+	s = Settings{Trusted_proxies: []string{"proxy.local"}}
+	if err := s.Validate(); err == nil {
+		t.Error("Validate() should fail for invalid address")
+	}
+
+	s = Settings{Trusted_proxies: []string{"10.0.0.0/8", "192.168.1.1", "::1"}}
+	if err := s.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Mode != Append {
+		t.Error("Append mode should be used by default")
+	}
+	for ip, expected := range map[string]bool{"10.1.2.3": true, "192.168.1.1": true, "192.168.1.2": false, "::1": true} {
+		if s.isTrusted(ip) != expected {
+			t.Error("Unexpected trust for address: " + ip)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	s := newSettings(t, Append, "10.0.0.0/8")
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "203.0.113.5:4000"
+	req.Header.Set("X-Forwarded-For", "1.1.1.1")
+	if ip := s.ClientIP(req); ip != "203.0.113.5" {
+		t.Error("X-Forwarded-For of untrusted peer should be ignored, got: " + ip)
+	}
+
+	req.RemoteAddr = "10.0.0.2:4000"
+	req.Header.Set("X-Forwarded-For", "1.1.1.1, 198.51.100.7, 10.0.0.3")
+	if ip := s.ClientIP(req); ip != "198.51.100.7" {
+		t.Error("First untrusted address from the right should be used, got: " + ip)
+	}
+
+	req.Header.Set("X-Forwarded-For", "10.0.0.4")
+	if ip := s.ClientIP(req); ip != "10.0.0.4" {
+		t.Error("Leftmost address should be used if whole chain is trusted, got: " + ip)
+	}
+}
+
+func TestPrepare(t *testing.T) {
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest("GET", "http://api.example.com/users", nil)
+		req.RemoteAddr = "10.0.0.2:4000"
+		req.Header.Set("X-Forwarded-For", "198.51.100.7")
+		req.Header.Set("X-Forwarded-Proto", "https")
+		req.Header.Set("Forwarded", "for=198.51.100.7;proto=https")
+		return req
+	}
+
+	// trusted peer: incoming chain is kept and this hop is appended
+	s := newSettings(t, Append, "10.0.0.0/8")
+	req := newRequest()
+	out := s.Prepare(req)
+	if out.Header.Get("X-Forwarded-For") != "198.51.100.7" || out.Header.Get("X-Forwarded-Proto") != "https" ||
+		out.Header.Get("X-Forwarded-Host") != "api.example.com" || out.RemoteAddr != req.RemoteAddr {
+		t.Error("Unexpected append headers: ", out.Header)
+	}
+	if out.Header.Get("Forwarded") != "for=198.51.100.7;proto=https, for=10.0.0.2;host=\"api.example.com\";proto=http" {
+		t.Error("Unexpected Forwarded header: " + out.Header.Get("Forwarded"))
+	}
+	if req.Header.Get("X-Forwarded-Host") != "" {
+		t.Error("Original request headers shouldn't be modified")
+	}
+
+	// untrusted peer: spoofed headers are dropped
+	s = newSettings(t, Append)
+	out = s.Prepare(newRequest())
+	if out.Header.Get("X-Forwarded-For") != "" || out.Header.Get("X-Forwarded-Proto") != "http" ||
+		out.Header.Get("Forwarded") != "for=10.0.0.2;host=\"api.example.com\";proto=http" {
+		t.Error("Headers of untrusted peer should be replaced: ", out.Header)
+	}
+
+	s = newSettings(t, Overwrite, "10.0.0.0/8")
+	out = s.Prepare(newRequest())
+	if out.Header.Get("X-Forwarded-For") != "" || out.RemoteAddr != "198.51.100.7:4000" ||
+		out.Header.Get("Forwarded") != "for=198.51.100.7;host=\"api.example.com\";proto=https" {
+		t.Error("Unexpected overwrite result: ", out.RemoteAddr, out.Header)
+	}
+
+	s = newSettings(t, Strip, "10.0.0.0/8")
+	out = s.Prepare(newRequest())
+	s.Apply(out)
+	if v, ok := out.Header["X-Forwarded-For"]; !ok || v != nil || out.Header.Get("Forwarded") != "" ||
+		out.Header.Get("X-Forwarded-Proto") != "" {
+		t.Error("Forwarding headers should be stripped: ", out.Header)
+	}
+
+	req = newRequest()
+	req.RemoteAddr = "[2001:db8::1]:4000"
+	out = newSettings(t, Append).Prepare(req)
+	if out.Header.Get("Forwarded") != "for=\"[2001:db8::1]\";host=\"api.example.com\";proto=http" {
+		t.Error("IPv6 address should be quoted: " + out.Header.Get("Forwarded"))
+	}
+}
+
+func TestClientCertHeaders(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{SerialNumber: big.NewInt(7), Subject: pkix.Name{CommonName: "client"},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+	der, _ := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	cert, _ := x509.ParseCertificate(der)
+
+	s := newSettings(t, Append)
+	s.Client_cert_headers = &CertHeaders{Pem: "X-Client-Cert", Subject: "X-Client-Subject", Serial: "X-Client-Serial"}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Client-Subject", "CN=spoofed")
+	out := s.Prepare(req)
+	if out.Header.Get("X-Client-Subject") != "" {
+		t.Error("Certificate headers sent by the client should be removed")
+	}
+
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	out = s.Prepare(req)
+	if out.Header.Get("X-Client-Subject") != "" || out.Header.Get("X-Client-Cert") != "" {
+		t.Error("Unverified certificate shouldn't be forwarded: ", out.Header)
+	}
+
+	req.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+	out = s.Prepare(req)
+	if out.Header.Get("X-Client-Subject") != "CN=client" || out.Header.Get("X-Client-Serial") != "7" {
+		t.Error("Unexpected certificate headers: ", out.Header)
+	}
+	decoded, err := url.PathUnescape(out.Header.Get("X-Client-Cert"))
+	if block, _ := pem.Decode([]byte(decoded)); err != nil || block == nil || !bytes.Equal(block.Bytes, der) {
+		t.Error("Certificate should be sent as url encoded PEM")
+	}
+}
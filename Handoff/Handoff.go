@@ -0,0 +1,270 @@
+package Handoff
+
+import (
+	"errors"
+	"net"
+	"os"
+	"proxy/Logger"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+var l *Logger.Logger
+
+type Settings struct {
+	// unix socket running process passes its listeners over. New process started with the same settings
+	// takes listeners of the running one, which drains and exits once new process serves them
+	Socket string
+}
+
+func (s *Settings) Validate() error {
+	if s.Socket == "" {
+		return errors.New("Handoff settings should contain 'socket' path")
+	}
+	return nil
+}
+
+// Read handoff settings from parsed json value and validates it
+func ReadHandoffFromFile(v interface{}) *Settings {
+	var s Settings
+	if err := mapstructure.Decode(v, &s); err != nil {
+		panic("Can't decode handoff settings. Error: " + err.Error())
+	}
+	if err := s.Validate(); err != nil {
+		panic(err.Error())
+	}
+
+	return &s
+}
+
+// socket inherited from previous process or service manager
+type inheritedSocket struct {
+	name string
+	file *os.File
+}
+
+// socket of this process that can be passed to the next one
+type socket struct {
+	name string
+	file func() (*os.File, error)
+}
+
+var (
+	settings *Settings
+
+	mu        sync.Mutex
+	inherited []*inheritedSocket
+	sockets   []socket
+)
+
+// Inits handoff from optional 'Handoff' section. Sockets declared by LISTEN_FDS environment variables
+// (systemd socket activation) are taken first, then the ones of running process if handoff socket is configured
+func InitHandoff(file map[string]interface{}) {
+	l = Logger.New("Handoff", 0, nil)
+	settings = nil
+	inherited, sockets = nil, nil
+
+	inherited = fromEnvironment()
+	if v, exist := file["Handoff"]; exist {
+		settings = ReadHandoffFromFile(v)
+		received, err := receive(settings.Socket)
+		if err != nil {
+			panic("Can't take listeners of running process. Error: " + err.Error())
+		}
+		inherited = append(inherited, received...)
+	}
+	if len(inherited) != 0 {
+		l.Info(map[string]string{"sockets": strconv.Itoa(len(inherited))}, "Inherited listening sockets")
+	}
+}
+
+// first file descriptor passed by systemd
+const listenFdsStart = 3
+
+// reads sockets passed as described by sd_listen_fds: LISTEN_FDS sockets starting from descriptor 3,
+// LISTEN_PID is checked if it's set. Variables are unset, so they aren't inherited by child processes
+func fromEnvironment() []*inheritedSocket {
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	pid := os.Getenv("LISTEN_PID")
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if err != nil || count <= 0 || pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return nil
+	}
+
+	var rv []*inheritedSocket
+	for i := 0; i < count; i++ {
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		rv = append(rv, &inheritedSocket{name: name, file: os.NewFile(uintptr(listenFdsStart+i), name)})
+	}
+	return rv
+}
+
+// name socket is passed with, it's also matched against LISTEN_FDNAMES
+func socketName(network, addr string) string {
+	return network + ":" + addr
+}
+
+// reports whether bound address is the one listener is requested for
+func sameAddress(bound net.Addr, network, addr string) bool {
+	if bound == nil || !strings.HasPrefix(bound.Network(), network[:3]) {
+		return false
+	}
+	var ip net.IP
+	var port int
+	switch a := bound.(type) {
+	case *net.TCPAddr:
+		ip, port = a.IP, a.Port
+	case *net.UDPAddr:
+		ip, port = a.IP, a.Port
+	default:
+		return false
+	}
+
+	host, p, err := net.SplitHostPort(addr)
+	if err != nil || strconv.Itoa(port) != p {
+		return false
+	}
+	if host == "" {
+		return ip.IsUnspecified()
+	}
+	requested := net.ParseIP(host)
+	if requested == nil {
+		ips, err := net.LookupIP(host)
+		if err != nil || len(ips) == 0 {
+			return false
+		}
+		requested = ips[0]
+	}
+	return requested.Equal(ip) || requested.IsUnspecified() && ip.IsUnspecified()
+}
+
+// takes inherited socket with the name or bound to the address
+func take(network, addr string) *inheritedSocket {
+	name := socketName(network, addr)
+	for i, s := range inherited {
+		if s.name == name || sameAddress(localAddr(s.file, network), network, addr) {
+			inherited = append(inherited[:i], inherited[i+1:]...)
+			return s
+		}
+	}
+	return nil
+}
+
+// local address of socket file, nil if it isn't socket of the network
+func localAddr(f *os.File, network string) net.Addr {
+	if strings.HasPrefix(network, "udp") {
+		c, err := net.FilePacketConn(f)
+		if err != nil {
+			return nil
+		}
+		defer c.Close()
+		return c.LocalAddr()
+	}
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil
+	}
+	defer ln.Close()
+	return ln.Addr()
+}
+
+// Listen returns listener inherited from previous process or new one. Listener can be passed to the next process
+func Listen(network, addr string) (net.Listener, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var ln net.Listener
+	var err error
+	if s := take(network, addr); s != nil {
+		ln, err = net.FileListener(s.file)
+		s.file.Close()
+	} else {
+		ln, err = net.Listen(network, addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tcp, ok := ln.(*net.TCPListener)
+	if ok {
+		sockets = append(sockets, socket{name: socketName(network, addr), file: tcp.File})
+	}
+	return ln, nil
+}
+
+// ListenPacket returns packet connection inherited from previous process or new one.
+// Connection can be passed to the next process
+func ListenPacket(network, addr string) (net.PacketConn, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var conn net.PacketConn
+	var err error
+	if s := take(network, addr); s != nil {
+		conn, err = net.FilePacketConn(s.file)
+		s.file.Close()
+	} else {
+		conn, err = net.ListenPacket(network, addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	udp, ok := conn.(*net.UDPConn)
+	if ok {
+		sockets = append(sockets, socket{name: socketName(network, addr), file: udp.File})
+	}
+	return conn, nil
+}
+
+// Ready is called once all listeners are created and served. Inherited sockets that aren't used anymore are closed,
+// previous process is told to drain and handoff socket starts accepting the next process
+func Ready() {
+	mu.Lock()
+	for _, s := range inherited {
+		l.Warning(map[string]string{"name": s.name}, "Inherited socket isn't used by settings and is closed")
+		s.file.Close()
+	}
+	inherited = nil
+	mu.Unlock()
+
+	if settings == nil {
+		return
+	}
+	if err := release(); err != nil {
+		l.Error(map[string]string{"error": err.Error()}, "Can't tell previous process to drain")
+	}
+	if err := serve(settings.Socket); err != nil {
+		panic("Can't listen on handoff socket " + settings.Socket + ". Error: " + err.Error())
+	}
+}
+
+// files of the sockets in the order they were created, caller closes them
+func files() ([]string, []*os.File, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var names []string
+	var rv []*os.File
+	for _, s := range sockets {
+		f, err := s.file()
+		if err != nil {
+			for _, f := range rv {
+				f.Close()
+			}
+			return nil, nil, err
+		}
+		names = append(names, s.name)
+		rv = append(rv, f)
+	}
+	return names, rv, nil
+}
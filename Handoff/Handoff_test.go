@@ -0,0 +1,122 @@
+package Handoff
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"proxy/Logger"
+	"proxy/Shutdown"
+	"testing"
+	"time"
+)
+
+func init() {
+	Logger.Init(uint32(Logger.LError), Logger.UseStdOut, nil)
+}
+
+func TestSameAddress(t *testing.T) {
+	bound := &net.TCPAddr{IP: net.IPv4zero, Port: 8080}
+	for addr, expected := range map[string]bool{":8080": true, "0.0.0.0:8080": true, "[::]:8080": true,
+		"127.0.0.1:8080": false, ":8081": false} {
+		if sameAddress(bound, "tcp", addr) != expected {
+			t.Error("Unexpected match of bound address for " + addr)
+		}
+	}
+	if sameAddress(&net.UDPAddr{IP: net.IPv4zero, Port: 8080}, "tcp", ":8080") {
+		t.Error("udp socket shouldn't match tcp listener")
+	}
+}
+
+func TestHandoff(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "handoff")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "handoff.sock")
+
+	// running process
+	InitHandoff(map[string]interface{}{"Handoff": map[string]interface{}{"socket": path}})
+	Shutdown.InitShutdown(map[string]interface{}{})
+	old, err := Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+	Ready()
+	drained := make(chan struct{})
+	go func() {
+		Shutdown.Wait()
+		close(drained)
+	}()
+
+	// new process takes the listener
+	received, err := receive(path)
+	if err != nil || len(received) != 1 || received[0].name != "tcp:127.0.0.1:0" {
+		t.Fatal("Listener of running process should be received: ", err)
+	}
+	inherited, sockets = received, nil
+	ln, err := Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if ln.Addr().String() != old.Addr().String() {
+		t.Fatal("Inherited listener should be bound to the same address, got: " + ln.Addr().String())
+	}
+
+	// port stays open when running process closes its listener
+	old.Close()
+	go func() {
+		if c, err := ln.Accept(); err == nil {
+			c.Close()
+		}
+	}()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal("Inherited listener should accept connections: ", err)
+	}
+	c.Close()
+
+	select {
+	case <-drained:
+		t.Fatal("Running process shouldn't drain before new one is ready")
+	default:
+	}
+	Ready()
+	select {
+	case <-drained:
+	case <-time.After(5 * time.Second):
+		t.Error("Running process should drain once new process is ready")
+	}
+	if received, err := receive(path); err != nil || len(received) != 1 {
+		t.Error("New process should accept handoff requests: ", err)
+	}
+	previous.Close()
+	previous = nil
+}
+
+func TestReceiveTimeout(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "handoff")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "handoff.sock")
+	l = Logger.New("Handoff", 0, nil)
+
+	// process that accepts connection, but never answers
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(2 * time.Second)
+		}
+	}()
+
+	defer func(timeout time.Duration) { receiveTimeout = timeout }(receiveTimeout)
+	receiveTimeout = 100 * time.Millisecond
+	if received, err := receive(path); err != nil || len(received) != 0 {
+		t.Error("Sockets of process that doesn't answer shouldn't be inherited: ", err)
+	}
+}
@@ -0,0 +1,224 @@
+//go:build !windows
+// +build !windows
+
+package Handoff
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"proxy/Shutdown"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// requests names and descriptors of listening sockets
+	requestListeners = "listeners"
+	// sent by new process once it serves the sockets, previous process drains after it
+	requestReady = "ready"
+	// previous process has stopped accepting on handoff socket
+	replyDone = "done"
+
+	maxSockets     = 256
+	releaseTimeout = 10 * time.Second
+)
+
+// connection to previous process, kept until new process is ready
+var previous *net.UnixConn
+
+// time previous process has to pass its sockets, it's variable so tests don't wait long
+var receiveTimeout = 10 * time.Second
+
+// receive takes listening sockets of the process that serves handoff socket. Nothing is returned
+// if there is no such process or it doesn't answer in time, e.g. it's stuck in shutdown
+func receive(path string) ([]*inheritedSocket, error) {
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	rv, err := receiveSockets(conn)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		l.Warning(map[string]string{"socket": path},
+			"Running process didn't pass listening sockets in time, they aren't inherited")
+		return nil, nil
+	}
+	return rv, err
+}
+
+// reads sockets from connection to previous process, connection is closed if they can't be read
+func receiveSockets(conn *net.UnixConn) ([]*inheritedSocket, error) {
+	conn.SetDeadline(time.Now().Add(receiveTimeout))
+
+	if _, err := conn.Write([]byte(requestListeners + "\n")); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	buf := make([]byte, 64*1024)
+	oob := make([]byte, syscall.CmsgSpace(maxSockets*4))
+	n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	fds, err := parseRights(oob[:oobn])
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// descriptors are attached to the first chunk, rest of the names can come later
+	reader := bufio.NewReader(conn)
+	data := buf[:n]
+	if !strings.HasSuffix(string(data), "\n") {
+		rest, err := reader.ReadBytes('\n')
+		if err != nil {
+			closeFds(fds)
+			conn.Close()
+			return nil, err
+		}
+		data = append(data, rest...)
+	}
+
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil || len(names) != len(fds) {
+		closeFds(fds)
+		conn.Close()
+		return nil, errors.New("invalid listeners message of running process")
+	}
+
+	var rv []*inheritedSocket
+	for i, fd := range fds {
+		rv = append(rv, &inheritedSocket{name: names[i], file: os.NewFile(uintptr(fd), names[i])})
+	}
+	// release sets its own deadline
+	conn.SetDeadline(time.Time{})
+	previous = conn
+	return rv, nil
+}
+
+func parseRights(oob []byte) ([]int, error) {
+	messages, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, err
+	}
+	var fds []int
+	for _, m := range messages {
+		rights, err := syscall.ParseUnixRights(&m)
+		if err != nil {
+			return nil, err
+		}
+		fds = append(fds, rights...)
+	}
+	return fds, nil
+}
+
+func closeFds(fds []int) {
+	for _, fd := range fds {
+		syscall.Close(fd)
+	}
+}
+
+// release tells previous process that sockets are served and waits until it stops accepting on handoff socket
+func release() error {
+	if previous == nil {
+		return nil
+	}
+	defer func() {
+		previous.Close()
+		previous = nil
+	}()
+
+	previous.SetDeadline(time.Now().Add(releaseTimeout))
+	if _, err := previous.Write([]byte(requestReady + "\n")); err != nil {
+		return err
+	}
+	line, err := bufio.NewReader(previous).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(line) != replyDone {
+		return errors.New("unexpected reply of previous process: " + line)
+	}
+	return nil
+}
+
+// serve accepts new processes on handoff socket, the one that becomes ready ends this process
+func serve(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return err
+	}
+	// next process binds the same path before this one exits
+	ln.SetUnlinkOnClose(false)
+	// sockets are given only to processes of the same user
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return err
+	}
+
+	go func() {
+		for {
+			conn, err := ln.AcceptUnix()
+			if err != nil {
+				return
+			}
+			if handle(ln, conn) {
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// handle passes sockets to new process and waits until it's ready. Returns true if this process drains
+func handle(ln *net.UnixListener, conn *net.UnixConn) bool {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err != nil || strings.TrimSpace(line) != requestListeners {
+		return false
+	}
+
+	names, files, err := files()
+	if err != nil {
+		l.Error(map[string]string{"error": err.Error()}, "Can't get listening sockets for handoff")
+		return false
+	}
+	data, _ := json.Marshal(names)
+	fds := make([]int, len(files))
+	for i, f := range files {
+		fds[i] = int(f.Fd())
+	}
+	_, _, err = conn.WriteMsgUnix(append(data, '\n'), syscall.UnixRights(fds...), nil)
+	for _, f := range files {
+		f.Close()
+	}
+	if err != nil {
+		l.Error(map[string]string{"error": err.Error()}, "Can't pass listening sockets")
+		return false
+	}
+	l.Info(map[string]string{"sockets": strconv.Itoa(len(names))}, "Listening sockets passed to new process")
+
+	// new process that exits before it's ready leaves this one serving
+	line, err = reader.ReadString('\n')
+	if err != nil || strings.TrimSpace(line) != requestReady {
+		l.Warning(map[string]string{}, "New process exited before it was ready, handoff is cancelled")
+		return false
+	}
+	ln.Close()
+	conn.Write([]byte(replyDone + "\n"))
+	Shutdown.Start("handoff")
+	return true
+}
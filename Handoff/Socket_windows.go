@@ -0,0 +1,16 @@
+package Handoff
+
+import "errors"
+
+// receive takes listening sockets of running process, descriptors can't be passed on windows
+func receive(path string) ([]*inheritedSocket, error) {
+	return nil, nil
+}
+
+func release() error {
+	return nil
+}
+
+func serve(path string) error {
+	return errors.New("handoff socket isn't supported on windows")
+}
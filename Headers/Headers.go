@@ -0,0 +1,329 @@
+package Headers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+const (
+	Add    = "add"
+	Set    = "set"
+	Remove = "remove"
+	Rename = "rename"
+)
+
+// global header rules, nil if 'Headers' section is missing in settings file
+var Global *Settings
+
+// lists of rules applied to requests going to upstream and to responses coming from it
+type Settings struct {
+	Request  []Rule
+	Response []Rule
+}
+
+// Rule describes one header modification.
+// Value may contain variables: ${client_ip}, ${request_id}, ${route}, ${host}, ${method}, ${path},
+// ${claim:<name>} for auth claims and ${env:<name>} for environment variables
+type Rule struct {
+	Action string
+	Name   string
+	Value  string
+	// new name of the header for 'rename' action
+	To string
+
+	value template
+}
+
+// values available for templates while request is processed
+type Context struct {
+	ClientIP  string
+	RequestID string
+	Route     string
+	Host      string
+	Method    string
+	Path      string
+	Claims    map[string]string
+}
+
+// template is a list of literal strings and variables
+type template []templatePart
+
+type templatePart struct {
+	literal string
+	// variable name, empty for literal part
+	variable string
+	// argument of claim and env variables
+	arg string
+}
+
+var variables = map[string]bool{"client_ip": true, "request_id": true, "route": true, "host": true,
+	"method": true, "path": true, "claim": true, "env": true}
+
+func parseTemplate(s string) (template, error) {
+	var rv template
+	for {
+		start := strings.Index(s, "${")
+		if start == -1 {
+			break
+		}
+		end := strings.Index(s[start:], "}")
+		if end == -1 {
+			return nil, errors.New("Unclosed variable in header value: " + s)
+		}
+		end += start
+
+		if start > 0 {
+			rv = append(rv, templatePart{literal: s[:start]})
+		}
+		name, arg := s[start+2:end], ""
+		if i := strings.Index(name, ":"); i != -1 {
+			name, arg = name[:i], name[i+1:]
+		}
+		if !variables[name] {
+			return nil, errors.New("Unknown variable in header value: " + name)
+		}
+		if (name == "claim" || name == "env") && arg == "" {
+			return nil, errors.New("Variable '" + name + "' requires name, e.g. ${" + name + ":name}")
+		}
+		rv = append(rv, templatePart{variable: name, arg: arg})
+		s = s[end+1:]
+	}
+	if s != "" {
+		rv = append(rv, templatePart{literal: s})
+	}
+	return rv, nil
+}
+
+func (t template) render(ctx *Context) string {
+	var b strings.Builder
+	for _, p := range t {
+		switch p.variable {
+		case "":
+			b.WriteString(p.literal)
+		case "client_ip":
+			b.WriteString(ctx.ClientIP)
+		case "request_id":
+			b.WriteString(ctx.RequestID)
+		case "route":
+			b.WriteString(ctx.Route)
+		case "host":
+			b.WriteString(ctx.Host)
+		case "method":
+			b.WriteString(ctx.Method)
+		case "path":
+			b.WriteString(ctx.Path)
+		case "claim":
+			b.WriteString(ctx.Claims[p.arg])
+		case "env":
+			b.WriteString(os.Getenv(p.arg))
+		}
+	}
+	return b.String()
+}
+
+func (r *Rule) Validate() error {
+	r.Action = strings.ToLower(r.Action)
+	if r.Name == "" {
+		return errors.New("Header rule should contain 'name'")
+	}
+
+	switch r.Action {
+	case Add, Set:
+		v, err := parseTemplate(r.Value)
+		if err != nil {
+			return err
+		}
+		r.value = v
+	case Remove:
+	case Rename:
+		if r.To == "" {
+			return errors.New("Header rule 'rename' should contain 'to' for header: " + r.Name)
+		}
+	default:
+		return errors.New("Unsupported header rule action: " + r.Action + ". Supported: add, set, remove, rename")
+	}
+
+	return nil
+}
+
+func (s *Settings) Validate() error {
+	for i := range s.Request {
+		if err := s.Request[i].Validate(); err != nil {
+			return err
+		}
+	}
+	for i := range s.Response {
+		if err := s.Response[i].Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read header rules from parsed json value and validates them
+func ReadHeadersFromFile(v interface{}) *Settings {
+	var s Settings
+	if err := mapstructure.Decode(v, &s); err != nil {
+		panic("Can't decode header rules. Error: " + err.Error())
+	}
+	if err := s.Validate(); err != nil {
+		panic(err.Error())
+	}
+
+	return &s
+}
+
+// Inits global header rules from 'Headers' section, section is optional
+func InitHeaders(file map[string]interface{}) {
+	if v, exist := file["Headers"]; exist {
+		Global = ReadHeadersFromFile(v)
+	}
+}
+
+// returns names of headers matching rule name. Name ending with '*' matches all headers with such prefix
+func (r *Rule) matching(h http.Header) []string {
+	if !strings.HasSuffix(r.Name, "*") {
+		return []string{r.Name}
+	}
+
+	prefix := http.CanonicalHeaderKey(strings.TrimSuffix(r.Name, "*"))
+	var rv []string
+	for k := range h {
+		if strings.HasPrefix(k, prefix) {
+			rv = append(rv, k)
+		}
+	}
+	return rv
+}
+
+// Apply modifies headers according to rules
+func Apply(rules []Rule, h http.Header, ctx *Context) {
+	for i := range rules {
+		r := &rules[i]
+		switch r.Action {
+		case Add:
+			h.Add(r.Name, r.value.render(ctx))
+		case Set:
+			h.Set(r.Name, r.value.render(ctx))
+		case Remove:
+			for _, name := range r.matching(h) {
+				h.Del(name)
+			}
+		case Rename:
+			if values := h.Values(r.Name); len(values) > 0 {
+				values = append([]string(nil), values...)
+				h.Del(r.Name)
+				h.Del(r.To)
+				for _, v := range values {
+					h.Add(r.To, v)
+				}
+			}
+		}
+	}
+}
+
+// variables that differ between requests to the same url, e.g. of different users
+var requestVariables = map[string]bool{"client_ip": true, "request_id": true, "method": true, "claim": true}
+
+// perRequest reports whether rule value depends on the client or its request
+func (r *Rule) perRequest() bool {
+	for _, p := range r.value {
+		if requestVariables[p.variable] {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitPerRequest separates rules which values depend on the client or its request, e.g. ${claim:sub}.
+// Cached responses are shared by clients, so such rules are applied to each response after the cache
+func SplitPerRequest(rules []Rule) ([]Rule, []Rule) {
+	var shared, perRequest []Rule
+	for _, r := range rules {
+		if r.perRequest() {
+			perRequest = append(perRequest, r)
+		} else {
+			shared = append(shared, r)
+		}
+	}
+	return shared, perRequest
+}
+
+// responseWriter applies rules to response headers right before they are sent
+type responseWriter struct {
+	http.ResponseWriter
+	rules   []Rule
+	ctx     *Context
+	applied bool
+}
+
+// NewResponseWriter returns writer that applies rules to headers of the response written to w
+func NewResponseWriter(w http.ResponseWriter, rules []Rule, ctx *Context) http.ResponseWriter {
+	return &responseWriter{ResponseWriter: w, rules: rules, ctx: ctx}
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if code >= 200 && !w.applied {
+		w.applied = true
+		Apply(w.rules, w.Header(), w.ctx)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(data []byte) (int, error) {
+	if !w.applied {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+type contextKey struct{}
+
+// returns copy of parent context that carries template values
+func NewContext(parent context.Context, ctx *Context) context.Context {
+	return context.WithValue(parent, contextKey{}, ctx)
+}
+
+// returns template values stored in context, empty values if there are none
+func FromContext(ctx context.Context) *Context {
+	if v, ok := ctx.Value(contextKey{}).(*Context); ok {
+		return v
+	}
+	return &Context{}
+}
+
+// joins global rules with rules of the endpoint, global rules are applied first
+func Merge(global *Settings, endpoint *Settings) *Settings {
+	rv := &Settings{}
+	for _, s := range []*Settings{global, endpoint} {
+		if s != nil {
+			rv.Request = append(rv.Request, s.Request...)
+			rv.Response = append(rv.Response, s.Response...)
+		}
+	}
+	return rv
+}
+
+// AddVary adds value to Vary header if it isn't there yet, so middlewares and upstream don't repeat it
+func AddVary(h http.Header, value string) {
+	for _, v := range h.Values("Vary") {
+		for _, part := range strings.Split(v, ",") {
+			part = strings.TrimSpace(part)
+			if part == "*" || strings.EqualFold(part, value) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", value)
+}
@@ -0,0 +1,117 @@
+package Headers
+
+import (
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestRule_Validate(t *testing.T) {
+	r := Rule{Action: "SET", Name: "X-Env", Value: "prod-${env:REGION}"}
+	if err := r.Validate(); err != nil {
+		t.Error(err)
+	}
+	if r.Action != Set {
+		t.Error("Action should be lower-cased")
+	}
+
+	r = Rule{Action: "set", Name: "X-Env", Value: "${unknown}"}
+	if err := r.Validate(); err == nil {
+		t.Error("Validate() should fail for unknown variable")
+	}
+
+	r = Rule{Action: "set", Name: "X-Env", Value: "${client_ip"}
+	if err := r.Validate(); err == nil {
+		t.Error("Validate() should fail for unclosed variable")
+	}
+
+	r = Rule{Action: "add", Name: "X-User", Value: "${claim:}"}
+	if err := r.Validate(); err == nil {
+		t.Error("Validate() should fail for claim without name")
+	}
+
+	r = Rule{Action: "rename", Name: "X-A"}
+	if err := r.Validate(); err == nil {
+		t.Error("Validate() should fail for rename without 'to'")
+	}
+
+	r = Rule{Action: "replace", Name: "X-A"}
+	if err := r.Validate(); err == nil {
+		t.Error("Validate() should fail for unsupported action")
+	}
+
+	r = Rule{Action: "remove"}
+	if err := r.Validate(); err == nil {
+		t.Error("Validate() should fail without name")
+	}
+}
+
+func TestApply(t *testing.T) {
+	os.Setenv("HEADERS_TEST_ENV", "staging")
+	defer os.Unsetenv("HEADERS_TEST_ENV")
+
+	s := &Settings{Request: []Rule{
+		{Action: "set", Name: "X-Env", Value: "${env:HEADERS_TEST_ENV}"},
+		{Action: "add", Name: "X-Forwarded-User", Value: "${claim:sub}@${client_ip}"},
+		{Action: "set", Name: "X-Route", Value: "${method} ${route} ${request_id}"},
+		{Action: "remove", Name: "X-Internal-*"},
+		{Action: "remove", Name: "Server"},
+		{Action: "rename", Name: "X-Old", To: "X-New"},
+	}}
+	if err := s.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	h := http.Header{}
+	h.Set("Server", "nginx")
+	h.Set("X-Internal-Id", "1")
+	h.Set("X-Internal-Token", "2")
+	h.Add("X-Old", "a")
+	h.Add("X-Old", "b")
+	ctx := &Context{ClientIP: "10.0.0.1", RequestID: "req-1", Route: "/api", Method: "GET",
+		Claims: map[string]string{"sub": "user"}}
+	Apply(s.Request, h, ctx)
+
+	if h.Get("X-Env") != "staging" {
+		t.Error("Env variable should be rendered, got: " + h.Get("X-Env"))
+	}
+	if h.Get("X-Forwarded-User") != "user@10.0.0.1" {
+		t.Error("Claim and client ip should be rendered, got: " + h.Get("X-Forwarded-User"))
+	}
+	if h.Get("X-Route") != "GET /api req-1" {
+		t.Error("Route variables should be rendered, got: " + h.Get("X-Route"))
+	}
+	if h.Get("Server") != "" || h.Get("X-Internal-Id") != "" || h.Get("X-Internal-Token") != "" {
+		t.Error("Headers should be removed")
+	}
+	if h.Get("X-Old") != "" || len(h.Values("X-New")) != 2 {
+		t.Error("Header should be renamed with all values")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	global := &Settings{Request: []Rule{{Action: "remove", Name: "A"}}}
+	endpoint := &Settings{Request: []Rule{{Action: "remove", Name: "B"}}, Response: []Rule{{Action: "remove", Name: "C"}}}
+
+	merged := Merge(global, endpoint)
+	if len(merged.Request) != 2 || merged.Request[0].Name != "A" || len(merged.Response) != 1 {
+		t.Error("Global rules should be followed by endpoint rules")
+	}
+	if merged = Merge(nil, nil); len(merged.Request) != 0 {
+		t.Error("Merge of missing settings should be empty")
+	}
+}
+
+func TestSplitPerRequest(t *testing.T) {
+	s := &Settings{Response: []Rule{{Action: "set", Name: "X-Route", Value: "${route}"},
+		{Action: "set", Name: "X-User", Value: "user ${claim:sub}"}, {Action: "remove", Name: "Server"},
+		{Action: "add", Name: "X-Request", Value: "${request_id}"}}}
+	if err := s.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	shared, perRequest := SplitPerRequest(s.Response)
+	if len(shared) != 2 || shared[0].Name != "X-Route" || shared[1].Name != "Server" ||
+		len(perRequest) != 2 || perRequest[0].Name != "X-User" || perRequest[1].Name != "X-Request" {
+		t.Error("Rules with request variables should be separated keeping their order")
+	}
+}
@@ -0,0 +1,64 @@
+package Layer4
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// pool distributes connections between upstreams
+type pool struct {
+	upstreams []string
+	balance   string
+
+	mu     sync.Mutex
+	next   int
+	active []int
+}
+
+func newPool(upstreams []string, balance string) *pool {
+	return &pool{upstreams: upstreams, balance: balance, active: make([]int, len(upstreams))}
+}
+
+// pick returns index of upstream for the client skipping already tried ones, -1 if all upstreams were tried.
+// Picked upstream should be released with done
+func (p *pool) pick(clientIP string, tried map[int]bool) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := len(p.upstreams)
+	start := 0
+	switch p.balance {
+	case "least_conn":
+		best := -1
+		for i := 0; i < n; i++ {
+			if !tried[i] && (best == -1 || p.active[i] < p.active[best]) {
+				best = i
+			}
+		}
+		if best != -1 {
+			p.active[best]++
+		}
+		return best
+	case "ip_hash":
+		h := fnv.New32a()
+		h.Write([]byte(clientIP))
+		start = int(h.Sum32() % uint32(n))
+	default:
+		start = p.next % n
+		p.next++
+	}
+
+	for k := 0; k < n; k++ {
+		if i := (start + k) % n; !tried[i] {
+			p.active[i]++
+			return i
+		}
+	}
+	return -1
+}
+
+func (p *pool) done(i int) {
+	p.mu.Lock()
+	p.active[i]--
+	p.mu.Unlock()
+}
@@ -0,0 +1,352 @@
+package Layer4
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"proxy/Logger"
+	"proxy/Protocol"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func init() {
+	Logger.Init(uint32(Logger.LError), Logger.UseStdOut, nil)
+	initLogger()
+}
+
+func TestPool(t *testing.T) {
+	p := newPool([]string{"a", "b", "c"}, "round_robin")
+	if p.pick("", nil) != 0 || p.pick("", nil) != 1 || p.pick("", nil) != 2 || p.pick("", nil) != 0 {
+		t.Error("Round robin should pick upstreams in order")
+	}
+	if p.pick("", map[int]bool{0: true, 1: true, 2: true}) != -1 {
+		t.Error("No upstream should be picked when all were tried")
+	}
+
+	p = newPool([]string{"a", "b", "c"}, "least_conn")
+	first, second := p.pick("", nil), p.pick("", nil)
+	if first == second {
+		t.Error("Least loaded upstream should be picked")
+	}
+	p.done(first)
+	if p.pick("", nil) != first {
+		t.Error("Released upstream should be picked again")
+	}
+
+	p = newPool([]string{"a", "b", "c"}, "ip_hash")
+	i := p.pick("198.51.100.7", nil)
+	if p.pick("198.51.100.7", nil) != i {
+		t.Error("The same client should be sent to the same upstream")
+	}
+	if p.pick("198.51.100.7", map[int]bool{i: true}) == i {
+		t.Error("Unavailable upstream should be skipped")
+	}
+}
+
+// starts tcp server answering every line with its name
+func startUpstream(t *testing.T, name string, config *tls.Config) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config != nil {
+		ln = tls.NewListener(ln, config)
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				r := bufio.NewReader(c)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					c.Write([]byte(name + " " + line))
+				}
+			}(c)
+		}
+	}()
+	return ln
+}
+
+func startTCPProxy(t *testing.T, p *Protocol.Protocol) net.Listener {
+	p.Type = "tcp"
+	p.Validate()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ServeTCP(p, ln)
+	return ln
+}
+
+func request(t *testing.T, c net.Conn, line string) string {
+	c.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := c.Write([]byte(line + "\n")); err != nil {
+		return ""
+	}
+	resp, _ := bufio.NewReader(c).ReadString('\n')
+	return resp
+}
+
+// writes self signed certificate for names to temporary files
+func writeCertificate(t *testing.T, dir string, names ...string) (string, string, *tls.Config) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: names[0]},
+		DNSNames: names, NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+
+	certPath, keyPath := filepath.Join(dir, names[0]+".pem"), filepath.Join(dir, names[0]+".key")
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	ioutil.WriteFile(certPath, certPem, 0600)
+	ioutil.WriteFile(keyPath, keyPem, 0600)
+
+	cert, _ := tls.X509KeyPair(certPem, keyPem)
+	return certPath, keyPath, &tls.Config{Certificates: []tls.Certificate{cert}}
+}
+
+func TestTCPProxy(t *testing.T) {
+	a, b := startUpstream(t, "a", nil), startUpstream(t, "b", nil)
+	defer a.Close()
+	defer b.Close()
+
+	// closed listener address is used as unavailable upstream
+	down, _ := net.Listen("tcp", "127.0.0.1:0")
+	down.Close()
+
+	ln := startTCPProxy(t, &Protocol.Protocol{Upstreams: []string{a.Addr().String(), down.Addr().String(),
+		b.Addr().String()}, MaxConnections: 2})
+	defer ln.Close()
+
+	seen := map[string]bool{}
+	var conns []net.Conn
+	for i := 0; i < 2; i++ {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		conns = append(conns, c)
+		seen[request(t, c, "ping")] = true
+	}
+	if !seen["a ping\n"] || !seen["b ping\n"] {
+		t.Error("Connections should be balanced between available upstreams, got: ", seen)
+	}
+
+	c, _ := net.Dial("tcp", ln.Addr().String())
+	if resp := request(t, c, "ping"); resp != "" {
+		t.Error("Connection over the limit should be closed, got: " + resp)
+	}
+	c.Close()
+
+	conns[0].Close()
+	time.Sleep(50 * time.Millisecond)
+	c, _ = net.Dial("tcp", ln.Addr().String())
+	defer c.Close()
+	if resp := request(t, c, "ping"); resp == "" {
+		t.Error("Connection should be accepted after another one is closed")
+	}
+}
+
+// flakyListener fails first Accept as process that ran out of descriptors does
+type flakyListener struct {
+	net.Listener
+	failed bool
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if !l.failed {
+		l.failed = true
+		return nil, &net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept", syscall.EMFILE)}
+	}
+	return l.Listener.Accept()
+}
+
+func TestTCPAcceptError(t *testing.T) {
+	a := startUpstream(t, "a", nil)
+	defer a.Close()
+	p := &Protocol.Protocol{Type: "tcp", Upstreams: []string{a.Addr().String()}}
+	p.Validate()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- ServeTCP(p, &flakyListener{Listener: ln}) }()
+
+	c, _ := net.Dial("tcp", ln.Addr().String())
+	defer c.Close()
+	if resp := request(t, c, "ping"); resp != "a ping\n" {
+		t.Error("Connections should be accepted after temporary error, got: " + resp)
+	}
+
+	ln.Close()
+	select {
+	case <-served:
+	case <-time.After(2 * time.Second):
+		t.Error("Serving should stop once listener is closed")
+	}
+}
+
+func TestTCPIdleTimeout(t *testing.T) {
+	a := startUpstream(t, "a", nil)
+	defer a.Close()
+	ln := startTCPProxy(t, &Protocol.Protocol{Upstreams: []string{a.Addr().String()}, IdleTimeout: 1})
+	defer ln.Close()
+
+	c, _ := net.Dial("tcp", ln.Addr().String())
+	defer c.Close()
+	// traffic keeps connection open longer than idle timeout
+	for i := 0; i < 3; i++ {
+		if resp := request(t, c, "ping"); resp != "a ping\n" {
+			t.Fatal("Active connection shouldn't be closed, got: " + resp)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+	if resp := request(t, c, "ping"); resp != "" {
+		t.Error("Idle connection should be closed")
+	}
+}
+
+func TestTLSTermination(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "layer4")
+	defer os.RemoveAll(dir)
+	certPath, keyPath, _ := writeCertificate(t, dir, "db.example.com")
+
+	a := startUpstream(t, "a", nil)
+	defer a.Close()
+	ln := startTCPProxy(t, &Protocol.Protocol{Upstreams: []string{a.Addr().String()}, CertPath: certPath, KeyPath: keyPath})
+	defer ln.Close()
+
+	c, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{ServerName: "db.example.com", InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if resp := request(t, c, "ping"); resp != "a ping\n" {
+		t.Error("Upstream should receive decrypted data, got: " + resp)
+	}
+}
+
+func TestSNIRouting(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "layer4")
+	defer os.RemoveAll(dir)
+	_, _, pgConfig := writeCertificate(t, dir, "pg.example.com")
+	_, _, redisConfig := writeCertificate(t, dir, "redis.internal", "*.redis.internal")
+	_, _, defaultConfig := writeCertificate(t, dir, "default")
+
+	pg, redis := startUpstream(t, "pg", pgConfig), startUpstream(t, "redis", redisConfig)
+	fallback := startUpstream(t, "default", defaultConfig)
+	defer pg.Close()
+	defer redis.Close()
+	defer fallback.Close()
+
+	ln := startTCPProxy(t, &Protocol.Protocol{Upstreams: []string{fallback.Addr().String()}, Sni: map[string][]string{
+		"pg.example.com":   {pg.Addr().String()},
+		"*.redis.internal": {redis.Addr().String()},
+	}})
+	defer ln.Close()
+
+	cases := map[string]string{"pg.example.com": "pg", "eu.redis.internal": "redis", "other.com": "default"}
+	for name, expected := range cases {
+		c, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{ServerName: name, InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp := request(t, c, "ping"); resp != expected+" ping\n" {
+			t.Error("Unexpected upstream for " + name + ": " + resp)
+		}
+		c.Close()
+	}
+
+	// plain tcp goes to default upstreams
+	plain := startUpstream(t, "plain", nil)
+	defer plain.Close()
+	ln2 := startTCPProxy(t, &Protocol.Protocol{Upstreams: []string{plain.Addr().String()},
+		Sni: map[string][]string{"pg.example.com": {pg.Addr().String()}}})
+	defer ln2.Close()
+	c, _ := net.Dial("tcp", ln2.Addr().String())
+	defer c.Close()
+	if resp := request(t, c, "ping"); resp != "plain ping\n" {
+		t.Error("Non TLS connection should reach default upstream, got: " + resp)
+	}
+}
+
+func TestUDPProxy(t *testing.T) {
+	upstream, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := upstream.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			upstream.WriteTo(append([]byte("echo "), buf[:n]...), addr)
+		}
+	}()
+
+	p := &Protocol.Protocol{Type: "udp", Upstreams: []string{upstream.LocalAddr().String()}, IdleTimeout: 1,
+		MaxConnections: 1}
+	p.Validate()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	s := newUDPProxy(p, conn)
+	go s.serve()
+
+	exchange := func(c net.Conn) string {
+		c.SetDeadline(time.Now().Add(300 * time.Millisecond))
+		c.Write([]byte("ping"))
+		buf := make([]byte, 1024)
+		n, _ := c.Read(buf)
+		return string(buf[:n])
+	}
+
+	first, _ := net.Dial("udp", conn.LocalAddr().String())
+	defer first.Close()
+	if resp := exchange(first); resp != "echo ping" {
+		t.Fatal("Datagram should be proxied to upstream, got: " + resp)
+	}
+
+	second, _ := net.Dial("udp", conn.LocalAddr().String())
+	defer second.Close()
+	if resp := exchange(second); resp != "" {
+		t.Error("Session over the limit should be dropped, got: " + resp)
+	}
+
+	// idle session is closed, so another client can be served
+	time.Sleep(1500 * time.Millisecond)
+	if resp := exchange(second); resp != "echo ping" {
+		t.Error("New session should be created after idle one is closed, got: " + resp)
+	}
+}
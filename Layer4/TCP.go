@@ -0,0 +1,315 @@
+package Layer4
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"proxy/Logger"
+	"proxy/Protocol"
+	"proxy/Shutdown"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var l *Logger.Logger
+
+const (
+	// idle timeouts used when listener doesn't specify it
+	defaultTCPIdleTimeout = 600 * time.Second
+	defaultUDPIdleTimeout = 60 * time.Second
+
+	dialTimeout      = 10 * time.Second
+	handshakeTimeout = 10 * time.Second
+)
+
+func initLogger() {
+	if l == nil {
+		l = Logger.New("Layer4", 0, nil)
+	}
+}
+
+type tcpProxy struct {
+	protocol  *Protocol.Protocol
+	port      string
+	pool      *pool
+	sni       map[string]*pool
+	tlsConfig *tls.Config
+	idle      time.Duration
+	// taken by every proxied connection, nil if number of connections isn't limited
+	slots chan struct{}
+}
+
+func newTCPProxy(p *Protocol.Protocol) *tcpProxy {
+	s := &tcpProxy{protocol: p, port: strconv.Itoa(p.Port), sni: map[string]*pool{}, idle: defaultTCPIdleTimeout}
+	if len(p.Upstreams) != 0 {
+		s.pool = newPool(p.Upstreams, p.Balance)
+	}
+	for name, upstreams := range p.Sni {
+		s.sni[strings.ToLower(name)] = newPool(upstreams, p.Balance)
+	}
+	if p.IdleTimeout > 0 {
+		s.idle = time.Duration(p.IdleTimeout) * time.Second
+	}
+	if p.MaxConnections > 0 {
+		s.slots = make(chan struct{}, p.MaxConnections)
+	}
+
+	if p.CertPath != "" {
+		config, err := p.TLSConfig()
+		if err != nil {
+			panic(err.Error())
+		}
+		s.tlsConfig = config
+	}
+	return s
+}
+
+// ServeTCP proxies connections accepted by listener to upstreams of the tcp protocol.
+// Returns only when listener is closed, other accept errors are retried
+func ServeTCP(p *Protocol.Protocol, ln net.Listener) error {
+	initLogger()
+	s := newTCPProxy(p)
+	if s.tlsConfig != nil {
+		defer p.RotateTicketKeys(s.tlsConfig)()
+	}
+
+	var delay time.Duration
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if closed(err) {
+				return err
+			}
+			delay = retryDelay(delay)
+			l.Warning(map[string]string{"port": s.port, "error": err.Error(), "retry_in": delay.String()},
+				"Can't accept connection")
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+
+		if s.slots != nil {
+			select {
+			case s.slots <- struct{}{}:
+			default:
+				l.Warning(map[string]string{"port": s.port}, "Connection limit reached, connection is closed")
+				c.Close()
+				continue
+			}
+		}
+		go s.handle(c)
+	}
+}
+
+// closed reports whether error is caused by closed listener or connection. Go 1.14 has no net.ErrClosed,
+// so its message is compared
+func closed(err error) bool {
+	return strings.Contains(err.Error(), "use of closed network connection")
+}
+
+// retryDelay returns delay before the next attempt after serving error, e.g. too many open files.
+// It's doubled after every failed attempt up to a second as net/http server does
+func retryDelay(previous time.Duration) time.Duration {
+	if previous == 0 {
+		return 5 * time.Millisecond
+	}
+	if previous *= 2; previous > time.Second {
+		return time.Second
+	}
+	return previous
+}
+
+func (s *tcpProxy) handle(c net.Conn) {
+	defer Shutdown.Begin()()
+	defer func() {
+		if s.slots != nil {
+			<-s.slots
+		}
+	}()
+	defer c.Close()
+
+	conn, serverName, err := s.accept(c)
+	if err != nil {
+		s.protocol.HandshakeFailed()
+		l.Debug(map[string]string{"port": s.port, "client": c.RemoteAddr().String(), "error": err.Error()},
+			"Can't read TLS handshake")
+		return
+	}
+
+	pool := s.route(serverName)
+	if pool == nil {
+		l.Warning(map[string]string{"port": s.port, "server_name": serverName}, "No upstream for server name")
+		return
+	}
+
+	clientIP, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	upstream, i := dial(pool, "tcp", clientIP)
+	if upstream == nil {
+		return
+	}
+	defer pool.done(i)
+	defer upstream.Close()
+
+	pipe(conn, upstream, s.idle)
+}
+
+// accept terminates TLS or peeks TLS server name if it's required for routing.
+// Returned connection should be used instead of original one
+func (s *tcpProxy) accept(c net.Conn) (net.Conn, string, error) {
+	if s.tlsConfig != nil {
+		tc := tls.Server(c, s.tlsConfig)
+		tc.SetDeadline(time.Now().Add(handshakeTimeout))
+		if err := tc.Handshake(); err != nil {
+			return nil, "", err
+		}
+		tc.SetDeadline(time.Time{})
+		return tc, tc.ConnectionState().ServerName, nil
+	}
+
+	if len(s.sni) != 0 {
+		name, hello := peekServerName(c)
+		return &prefixConn{Conn: c, r: io.MultiReader(bytes.NewReader(hello), c)}, name, nil
+	}
+	return c, "", nil
+}
+
+// route returns upstreams for TLS server name: exact name, wildcard or default upstreams
+func (s *tcpProxy) route(serverName string) *pool {
+	serverName = strings.ToLower(serverName)
+	if p, ok := s.sni[serverName]; ok {
+		return p
+	}
+	if i := strings.Index(serverName, "."); i != -1 {
+		if p, ok := s.sni["*"+serverName[i:]]; ok {
+			return p
+		}
+	}
+	return s.pool
+}
+
+var errSniffed = errors.New("client hello is read")
+
+// peekServerName reads TLS ClientHello and returns requested server name with all bytes read from connection.
+// Empty name is returned if client doesn't speak TLS or doesn't send SNI
+func peekServerName(c net.Conn) (string, []byte) {
+	var read bytes.Buffer
+	var name string
+
+	c.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	tls.Server(&sniffConn{Conn: c, r: io.TeeReader(c, &read)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			name = hello.ServerName
+			return nil, errSniffed
+		},
+	}).Handshake()
+	c.SetReadDeadline(time.Time{})
+
+	return name, read.Bytes()
+}
+
+// sniffConn lets TLS server read ClientHello without answering it
+type sniffConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *sniffConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+func (c *sniffConn) Write(p []byte) (int, error) { return 0, io.ErrClosedPipe }
+
+// prefixConn replays already read bytes before reading from connection
+type prefixConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *prefixConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+func (c *prefixConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
+
+// dial connects to upstream of the pool, unavailable upstreams are skipped.
+// Returns nil connection if no upstream is available, otherwise upstream index should be released by caller
+func dial(p *pool, network string, clientIP string) (net.Conn, int) {
+	tried := map[int]bool{}
+	for {
+		i := p.pick(clientIP, tried)
+		if i == -1 {
+			l.Error(map[string]string{"upstreams": strings.Join(p.upstreams, ",")}, "No available upstream")
+			return nil, -1
+		}
+
+		c, err := net.DialTimeout(network, p.upstreams[i], dialTimeout)
+		if err == nil {
+			return c, i
+		}
+		p.done(i)
+		tried[i] = true
+		l.Warning(map[string]string{"upstream": p.upstreams[i], "error": err.Error()}, "Can't connect to upstream")
+	}
+}
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// idleConn is cut when there is no traffic in both directions for timeout.
+// Last activity time is shared between both sides of the proxied connection
+type idleConn struct {
+	net.Conn
+	last    *int64
+	timeout time.Duration
+}
+
+func (c *idleConn) Read(p []byte) (int, error) {
+	for {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		n, err := c.Conn.Read(p)
+		if n > 0 {
+			atomic.StoreInt64(c.last, time.Now().UnixNano())
+		}
+		if ne, ok := err.(net.Error); ok && ne.Timeout() && n == 0 &&
+			time.Since(time.Unix(0, atomic.LoadInt64(c.last))) < c.timeout {
+			// other direction is still active
+			continue
+		}
+		return n, err
+	}
+}
+
+func (c *idleConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	atomic.StoreInt64(c.last, time.Now().UnixNano())
+	return n, err
+}
+
+// pipe copies data between connections until both directions are finished or connection is idle
+func pipe(client net.Conn, upstream net.Conn, idle time.Duration) {
+	last := time.Now().UnixNano()
+	a := &idleConn{Conn: client, last: &last, timeout: idle}
+	b := &idleConn{Conn: upstream, last: &last, timeout: idle}
+
+	done := make(chan struct{}, 2)
+	transfer := func(dst *idleConn, src *idleConn) {
+		io.Copy(dst, src)
+		// let the other side know that no more data will come, but still read its response
+		if cw, ok := dst.Conn.(closeWriter); ok {
+			cw.CloseWrite()
+		} else {
+			dst.Conn.Close()
+		}
+		done <- struct{}{}
+	}
+	go transfer(b, a)
+	go transfer(a, b)
+	<-done
+	<-done
+}
@@ -0,0 +1,133 @@
+package Layer4
+
+import (
+	"net"
+	"proxy/Protocol"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maximum size of udp datagram
+const maxDatagram = 65535
+
+// session binds client address to upstream connection
+type session struct {
+	// unix nano time of last datagram, first field to be aligned for atomic operations
+	last     int64
+	client   net.Addr
+	upstream net.Conn
+	index    int
+}
+
+type udpProxy struct {
+	port string
+	conn net.PacketConn
+	pool *pool
+	idle time.Duration
+	max  int
+
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+// ServeUDP proxies datagrams received by connection to upstreams of the udp protocol.
+// Datagrams of the same client are sent to the same upstream until session is idle
+func ServeUDP(p *Protocol.Protocol, conn net.PacketConn) error {
+	initLogger()
+	return newUDPProxy(p, conn).serve()
+}
+
+func newUDPProxy(p *Protocol.Protocol, conn net.PacketConn) *udpProxy {
+	s := &udpProxy{port: strconv.Itoa(p.Port), conn: conn, pool: newPool(p.Upstreams, p.Balance),
+		idle: defaultUDPIdleTimeout, max: p.MaxConnections, sessions: map[string]*session{}}
+	if p.IdleTimeout > 0 {
+		s.idle = time.Duration(p.IdleTimeout) * time.Second
+	}
+	return s
+}
+
+// serve returns only when connection is closed, other read errors are retried
+func (s *udpProxy) serve() error {
+	buf := make([]byte, maxDatagram)
+	var delay time.Duration
+	for {
+		n, client, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if closed(err) {
+				return err
+			}
+			delay = retryDelay(delay)
+			l.Warning(map[string]string{"port": s.port, "error": err.Error(), "retry_in": delay.String()},
+				"Can't read datagram")
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+
+		sess := s.session(client)
+		if sess == nil {
+			continue
+		}
+		atomic.StoreInt64(&sess.last, time.Now().UnixNano())
+		if _, err := sess.upstream.Write(buf[:n]); err != nil {
+			l.Debug(map[string]string{"port": s.port, "error": err.Error()}, "Can't send datagram to upstream")
+		}
+	}
+}
+
+// returns session of the client, new session is created for unknown client.
+// nil is returned if session can't be created
+func (s *udpProxy) session(client net.Addr) *session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sess, ok := s.sessions[client.String()]; ok {
+		return sess
+	}
+	if s.max > 0 && len(s.sessions) >= s.max {
+		l.Warning(map[string]string{"port": s.port}, "Session limit reached, datagram is dropped")
+		return nil
+	}
+
+	clientIP, _, _ := net.SplitHostPort(client.String())
+	upstream, i := dial(s.pool, "udp", clientIP)
+	if upstream == nil {
+		return nil
+	}
+
+	sess := &session{client: client, upstream: upstream, index: i, last: time.Now().UnixNano()}
+	s.sessions[client.String()] = sess
+	go s.reply(sess)
+	return sess
+}
+
+// reply sends datagrams of upstream back to the client until session is idle
+func (s *udpProxy) reply(sess *session) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.sessions, sess.client.String())
+		s.mu.Unlock()
+		sess.upstream.Close()
+		s.pool.done(sess.index)
+	}()
+
+	buf := make([]byte, maxDatagram)
+	for {
+		sess.upstream.SetReadDeadline(time.Now().Add(s.idle))
+		n, err := sess.upstream.Read(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() &&
+				time.Since(time.Unix(0, atomic.LoadInt64(&sess.last))) < s.idle {
+				continue
+			}
+			return
+		}
+
+		atomic.StoreInt64(&sess.last, time.Now().UnixNano())
+		if _, err := s.conn.WriteTo(buf[:n], sess.client); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package Logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	AccessJSON     = "json"
+	AccessCommon   = "common"
+	AccessCombined = "combined"
+	AccessTemplate = "template"
+)
+
+// AccessSettings describes access log, it's written to its own output separately from application logs
+type AccessSettings struct {
+	// json (default), common, combined or template
+	Format string
+	// text/template over AccessEntry fields used by 'template' format, e.g. '{{.Method}} {{.Path}} {{.Status}}'
+	Template string
+	// file access log is appended to, stdout is used if empty
+	File string
+
+	template *template.Template
+}
+
+func (s *AccessSettings) Validate() error {
+	if s.Format == "" {
+		s.Format = AccessJSON
+	}
+	switch s.Format {
+	case AccessJSON, AccessCommon, AccessCombined:
+		if s.Template != "" {
+			return errors.New("Access log template can be used only with 'template' format")
+		}
+	case AccessTemplate:
+		if s.Template == "" {
+			return errors.New("Access log 'template' format requires template")
+		}
+		t, err := template.New("access").Parse(s.Template)
+		if err != nil {
+			return errors.New("Invalid access log template. Error: " + err.Error())
+		}
+		s.template = t
+	default:
+		return errors.New("Unsupported access log format: " + s.Format + ". Supported: json, common, combined, template")
+	}
+	return nil
+}
+
+// AccessEntry describes one proxied request
+type AccessEntry struct {
+	Time      time.Time
+	ClientIP  string
+	User      string
+	Method    string
+	Path      string
+	Protocol  string
+	Host      string
+	Status    int
+	Bytes     int
+	Duration  time.Duration
+	Referer   string
+	UserAgent string
+
+	// address of upstream request was proxied to, empty if response was made by the proxy itself
+	Upstream        string
+	UpstreamStatus  int
+	UpstreamLatency time.Duration
+	// upstream attempts after the first one. Proxy doesn't retry requests itself, these are attempts
+	// transport makes again when reused keep-alive connection turns out to be closed by upstream
+	Retries int
+
+	// name of auth that checked the request and its decision: allowed or denied. Empty if route has no auth
+	AuthName string
+	Auth     string
+	// entry url of the endpoint
+	Route     string
+	RequestID string
+}
+
+// AccessLogger writes one line per request
+type AccessLogger struct {
+	settings *AccessSettings
+	mu       sync.Mutex
+	out      io.Writer
+}
+
+// access logger created by InitAccess, nil if access log is disabled
+var access *AccessLogger
+
+// NewAccess creates access logger writing to settings output
+func NewAccess(settings *AccessSettings) (*AccessLogger, error) {
+	if err := settings.Validate(); err != nil {
+		return nil, err
+	}
+	var out io.Writer = os.Stdout
+	if settings.File != "" {
+		file, err := OpenFile(settings.File)
+		if err != nil {
+			return nil, errors.New("Can't open access log file. Error: " + err.Error())
+		}
+		reopenOnSignal()
+		out = file
+	}
+	return &AccessLogger{settings: settings, out: out}, nil
+}
+
+// InitAccess enables access log, nil settings disable it
+func InitAccess(settings *AccessSettings) {
+	if settings == nil {
+		access = nil
+		return
+	}
+	a, err := NewAccess(settings)
+	if err != nil {
+		panic(err.Error())
+	}
+	access = a
+}
+
+// Access returns access logger, nil if access log is disabled
+func Access() *AccessLogger {
+	return access
+}
+
+// Log writes entry in configured format, sensitive query parameters of path and referer are masked
+func (a *AccessLogger) Log(e *AccessEntry) {
+	masked := *e
+	masked.Path, masked.Referer = Redact(e.Path), Redact(e.Referer)
+	line := a.format(&masked)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.out.Write(line)
+}
+
+func (a *AccessLogger) format(e *AccessEntry) []byte {
+	var buf bytes.Buffer
+	switch a.settings.Format {
+	case AccessCommon, AccessCombined:
+		buf.WriteString(dash(e.ClientIP) + " - " + dash(e.User) + " [" + e.Time.Format("02/Jan/2006:15:04:05 -0700") + "] ")
+		buf.WriteString(strconv.Quote(e.Method + " " + e.Path + " " + e.Protocol))
+		buf.WriteString(" " + strconv.Itoa(e.Status) + " ")
+		if e.Bytes > 0 {
+			buf.WriteString(strconv.Itoa(e.Bytes))
+		} else {
+			buf.WriteString("-")
+		}
+		if a.settings.Format == AccessCombined {
+			buf.WriteString(" " + strconv.Quote(dash(e.Referer)) + " " + strconv.Quote(dash(e.UserAgent)))
+		}
+	case AccessTemplate:
+		if err := a.settings.template.Execute(&buf, e); err != nil {
+			buf.Reset()
+			buf.WriteString("access log template error: " + err.Error())
+		}
+	default:
+		data, _ := json.Marshal(map[string]interface{}{
+			"time":                e.Time.Format(time.RFC3339Nano),
+			"client_ip":           e.ClientIP,
+			"user":                e.User,
+			"method":              e.Method,
+			"path":                e.Path,
+			"protocol":            e.Protocol,
+			"host":                e.Host,
+			"status":              e.Status,
+			"bytes":               e.Bytes,
+			"duration_ms":         milliseconds(e.Duration),
+			"referer":             e.Referer,
+			"user_agent":          e.UserAgent,
+			"upstream":            e.Upstream,
+			"upstream_status":     e.UpstreamStatus,
+			"upstream_latency_ms": milliseconds(e.UpstreamLatency),
+			"retries":             e.Retries,
+			"auth_name":           e.AuthName,
+			"auth":                e.Auth,
+			"route":               e.Route,
+			"request_id":          e.RequestID,
+		})
+		buf.Write(data)
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+func dash(v string) string {
+	if v == "" {
+		return "-"
+	}
+	return v
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,64 @@
+package Logger
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestAccess(t *testing.T, s *AccessSettings) (*AccessLogger, *bytes.Buffer) {
+	if err := s.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	return &AccessLogger{settings: s, out: &buf}, &buf
+}
+
+func TestAccessSettings_Validate(t *testing.T) {
+	invalid := map[string]AccessSettings{
+		"unknown format":          {Format: "xml"},
+		"template without format": {Template: "{{.Status}}"},
+		"missing template":        {Format: AccessTemplate},
+		"invalid template":        {Format: AccessTemplate, Template: "{{.Status"},
+	}
+	for name, s := range invalid {
+		if err := s.Validate(); err == nil {
+			t.Error("Validate() should fail for " + name)
+		}
+	}
+	s := AccessSettings{}
+	if err := s.Validate(); err != nil || s.Format != AccessJSON {
+		t.Error("json format should be used by default: ", err)
+	}
+}
+
+func TestAccessFormats(t *testing.T) {
+	e := &AccessEntry{Time: time.Date(2020, 10, 10, 13, 55, 36, 0, time.UTC), ClientIP: "127.0.0.1", Method: "GET",
+		Path: "/api?q=1", Protocol: "HTTP/1.1", Status: 200, Bytes: 2326, Referer: "http://example.com/",
+		UserAgent: "curl/7.68", Upstream: "backend:80", UpstreamLatency: 1500 * time.Microsecond}
+
+	a, buf := newTestAccess(t, &AccessSettings{Format: AccessCommon})
+	a.Log(e)
+	if buf.String() != "127.0.0.1 - - [10/Oct/2020:13:55:36 +0000] \"GET /api?q=1 HTTP/1.1\" 200 2326\n" {
+		t.Error("Unexpected common log format line: " + buf.String())
+	}
+
+	a, buf = newTestAccess(t, &AccessSettings{Format: AccessCombined})
+	a.Log(e)
+	if !strings.HasSuffix(buf.String(), " 200 2326 \"http://example.com/\" \"curl/7.68\"\n") {
+		t.Error("Unexpected combined log format line: " + buf.String())
+	}
+
+	a, buf = newTestAccess(t, &AccessSettings{Format: AccessTemplate, Template: "{{.Method}} {{.Status}} {{.Upstream}}"})
+	a.Log(e)
+	if buf.String() != "GET 200 backend:80\n" {
+		t.Error("Unexpected template line: " + buf.String())
+	}
+
+	a, buf = newTestAccess(t, &AccessSettings{})
+	a.Log(e)
+	if !strings.Contains(buf.String(), `"upstream_latency_ms":1.5`) || !strings.Contains(buf.String(), `"status":200`) {
+		t.Error("Unexpected json line: " + buf.String())
+	}
+}
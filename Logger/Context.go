@@ -0,0 +1,65 @@
+package Logger
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+)
+
+type requestIDKey struct{}
+type fieldsKey struct{}
+
+// gin context key of request-scoped logger
+const ginKey = "logger"
+
+// ContextWithFields returns context with fields added to entries of loggers made by WithContext
+func ContextWithFields(ctx context.Context, fields ...Field) context.Context {
+	prev := contextFields(ctx)
+	all := make([]Field, 0, len(prev)+len(fields))
+	all = append(append(all, prev...), fields...)
+	return context.WithValue(ctx, fieldsKey{}, all)
+}
+
+func contextFields(ctx context.Context) []Field {
+	fields, _ := ctx.Value(fieldsKey{}).([]Field)
+	return fields
+}
+
+// ContextWithRequestID returns context of the request with its id, it's added to entries of loggers made by WithContext
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return ContextWithFields(context.WithValue(ctx, requestIDKey{}, id), String("request_id", id))
+}
+
+// RequestIDFromContext returns id of the request, empty string if context has none
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// WithContext returns logger that adds request-scoped fields of context, e.g. id of the request, to every entry
+func (l *Logger) WithContext(ctx context.Context) *Logger {
+	return l.WithFields(contextFields(ctx)...)
+}
+
+// Middleware adds method and path of the request to its context fields
+// and stores logger with them in gin context, handlers get it with FromGin
+func Middleware(l *Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := ContextWithFields(c.Request.Context(),
+			String("method", c.Request.Method), String("path", c.Request.URL.Path))
+		c.Request = c.Request.WithContext(ctx)
+		c.Set(ginKey, l.WithContext(ctx))
+		c.Next()
+	}
+}
+
+// FromGin returns request-scoped logger stored by Middleware,
+// default logger with fields of request context is returned if there is none
+func FromGin(c *gin.Context) *Logger {
+	if v, ok := c.Get(ginKey); ok {
+		if l, ok := v.(*Logger); ok {
+			return l
+		}
+	}
+	return defaultLogger.WithContext(c.Request.Context())
+}
@@ -0,0 +1,214 @@
+package Logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	elasticsearch7 "github.com/elastic/go-elasticsearch/v7"
+	"github.com/sirupsen/logrus"
+)
+
+// ElasticSettings describes how entries are sent to ElasticSearch at 'UseElastic' url
+type ElasticSettings struct {
+	// other nodes of the cluster, requests are balanced between them and 'UseElastic' url
+	Addresses []string
+	// index entries are written to, text in braces is Go time layout of entry time: proxy-{2006.01.02}
+	Index string
+	// basic authentication
+	Username string
+	Password string
+	// base64 encoded API key, it's used instead of basic authentication if set
+	ApiKey string
+	// PEM file with certificates of CA that signed certificates of the nodes
+	CaCert string
+	// 500 entries are sent in one bulk request by default
+	BatchSettings `mapstructure:",squash"`
+}
+
+func (s *ElasticSettings) Validate() error {
+	if s.Index == "" {
+		s.Index = "proxy-{2006.01.02}"
+	}
+	if strings.Count(s.Index, "{") != strings.Count(s.Index, "}") {
+		return errors.New("ElasticSearch index has unbalanced braces: " + s.Index)
+	}
+	if err := s.BatchSettings.Validate(500); err != nil {
+		return errors.New("ElasticSearch settings: " + err.Error())
+	}
+	return nil
+}
+
+// index name for entry made at t
+func (s *ElasticSettings) index(t time.Time) string {
+	var b strings.Builder
+	rest := s.Index
+	for {
+		start := strings.IndexByte(rest, '{')
+		end := strings.IndexByte(rest, '}')
+		if start == -1 || end < start {
+			b.WriteString(rest)
+			return b.String()
+		}
+		b.WriteString(rest[:start])
+		b.WriteString(t.UTC().Format(rest[start+1 : end]))
+		rest = rest[end+1:]
+	}
+}
+
+// ElasticSink sends entries with bulk requests. One sink is shared by all loggers writing to the same url
+type ElasticSink struct {
+	*batcher
+	client   *elasticsearch7.Client
+	settings ElasticSettings
+	levels   []logrus.Level
+	host     string
+	url      string
+}
+
+var (
+	elastic ElasticSettings
+
+	sinksMu sync.Mutex
+	sinks   = map[string]*ElasticSink{}
+)
+
+// InitElastic sets settings of sinks created by Init and New after it, nil resets them to defaults
+func InitElastic(settings *ElasticSettings) {
+	if settings == nil {
+		settings = &ElasticSettings{}
+	}
+	if err := settings.Validate(); err != nil {
+		panic(err.Error())
+	}
+	elastic = *settings
+}
+
+// NewElasticSink creates sink sending entries to url and other nodes of settings
+func NewElasticSink(url string, settings ElasticSettings) (*ElasticSink, error) {
+	if err := settings.Validate(); err != nil {
+		return nil, err
+	}
+	config := elasticsearch7.Config{
+		Addresses: append([]string{url}, settings.Addresses...),
+		Username:  settings.Username,
+		Password:  settings.Password,
+		APIKey:    settings.ApiKey,
+	}
+	if settings.CaCert != "" {
+		ca, err := ioutil.ReadFile(settings.CaCert)
+		if err != nil {
+			return nil, errors.New("Can't read ElasticSearch CA certificate. Error: " + err.Error())
+		}
+		config.CACert = ca
+	}
+	client, err := elasticsearch7.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	host, _ := os.Hostname()
+	s := &ElasticSink{client: client, settings: settings, levels: logrus.AllLevels, host: host, url: url}
+	s.batcher = newBatcher(settings.BatchSettings, s.send)
+	track(s)
+	return s, nil
+}
+
+// returns sink of the url, it's created on the first call
+func elasticSink(url string) (*ElasticSink, error) {
+	sinksMu.Lock()
+	defer sinksMu.Unlock()
+
+	if s, exist := sinks[url]; exist {
+		return s, nil
+	}
+	s, err := NewElasticSink(url, elastic)
+	if err != nil {
+		return nil, err
+	}
+	sinks[url] = s
+	return s, nil
+}
+
+func (s *ElasticSink) Levels() []logrus.Level {
+	return s.levels
+}
+
+// Fire puts entry into the buffer as bulk action and document lines
+func (s *ElasticSink) Fire(entry *logrus.Entry) error {
+	doc := map[string]interface{}{}
+	for k, v := range entry.Data {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		doc[k] = v
+	}
+	doc["@timestamp"] = entry.Time.UTC().Format(time.RFC3339Nano)
+	doc["host"] = s.host
+	doc["level"] = entry.Level.String()
+	doc["message"] = entry.Message
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	action, _ := json.Marshal(map[string]interface{}{"index": map[string]string{"_index": s.settings.index(entry.Time)}})
+
+	item := make([]byte, 0, len(action)+len(body)+2)
+	item = append(append(append(append(item, action...), '\n'), body...), '\n')
+	s.add(item)
+	return nil
+}
+
+type bulkResponse struct {
+	Errors bool
+	Items  []map[string]struct {
+		Status int
+	}
+}
+
+// sends batch with bulk request. Returns items that should be sent again and error if request failed
+func (s *ElasticSink) send(batch [][]byte) ([][]byte, error) {
+	var body bytes.Buffer
+	for _, item := range batch {
+		body.Write(item)
+	}
+
+	res, err := s.client.Bulk(&body)
+	if err != nil {
+		return batch, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500 {
+		return batch, errors.New("ElasticSearch bulk request failed: " + res.Status())
+	}
+	if res.IsError() {
+		s.drop(len(batch))
+		return nil, nil
+	}
+
+	var parsed bulkResponse
+	if err := json.NewDecoder(res.Body).Decode(&parsed); err != nil || !parsed.Errors {
+		return nil, nil
+	}
+	// documents rejected because of overloaded node are sent again, invalid ones are dropped
+	var retry [][]byte
+	for i, item := range parsed.Items {
+		for _, result := range item {
+			if i < len(batch) && result.Status == http.StatusTooManyRequests {
+				retry = append(retry, batch[i])
+			} else if result.Status >= 300 {
+				s.drop(1)
+			}
+		}
+	}
+	if len(retry) != 0 {
+		return retry, errors.New("ElasticSearch rejected documents of bulk request")
+	}
+	return nil, nil
+}
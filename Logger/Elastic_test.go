@@ -0,0 +1,152 @@
+package Logger
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fakeBulk records documents of bulk requests, requests fail while 'down' is set
+type fakeBulk struct {
+	mu      sync.Mutex
+	indices []string
+	docs    []map[string]interface{}
+	auth    string
+	down    int32
+}
+
+func (f *fakeBulk) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" || r.URL.Path != "/_bulk" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if atomic.LoadInt32(&f.down) == 1 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.auth = r.Header.Get("Authorization")
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var action map[string]map[string]string
+		json.Unmarshal(scanner.Bytes(), &action)
+		scanner.Scan()
+		var doc map[string]interface{}
+		json.Unmarshal(scanner.Bytes(), &doc)
+		f.indices = append(f.indices, action["index"]["_index"])
+		f.docs = append(f.docs, doc)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"errors":false,"items":[]}`))
+}
+
+func (f *fakeBulk) messages() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var rv []string
+	for _, d := range f.docs {
+		rv = append(rv, d["message"].(string))
+	}
+	return rv
+}
+
+func newTestSink(t *testing.T, settings ElasticSettings) (*logrus.Logger, *ElasticSink, *fakeBulk) {
+	bulk := &fakeBulk{}
+	server := httptest.NewServer(bulk)
+	t.Cleanup(server.Close)
+
+	sink, err := NewElasticSink(server.URL, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.levels = logrus.AllLevels
+	log := logrus.New()
+	log.Out = nopWriter{}
+	log.AddHook(sink)
+	return log, sink, bulk
+}
+
+type nopWriter struct{}
+
+func (nopWriter) Write(p []byte) (int, error) { return len(p), nil }
+
+func TestElasticSettings_Validate(t *testing.T) {
+	invalid := []ElasticSettings{{Index: "proxy-{2006"}, {BatchSettings: BatchSettings{BatchSize: -1}},
+		{BatchSettings: BatchSettings{BatchSize: 100, BufferSize: 10}}, {BatchSettings: BatchSettings{DropPolicy: "random"}}}
+	for _, s := range invalid {
+		if err := s.Validate(); err == nil {
+			t.Error("Validate() should fail for: ", s)
+		}
+	}
+	s := ElasticSettings{Index: "proxy-{2006.01}-{02}"}
+	s.Validate()
+	if v := s.index(time.Date(2020, 10, 10, 23, 0, 0, 0, time.UTC)); v != "proxy-2020.10-10" {
+		t.Error("Unexpected index: " + v)
+	}
+}
+
+func TestElasticBulk(t *testing.T) {
+	log, sink, bulk := newTestSink(t, ElasticSettings{Username: "user", Password: "secret",
+		BatchSettings: BatchSettings{BatchSize: 2, FlushInterval: 60}})
+
+	log.WithFields(logrus.Fields{"logger": "Test", "attempt": 2}).Info("first")
+	log.Info("second")
+	// full batch is sent without waiting for flush interval
+	for deadline := time.Now().Add(5 * time.Second); len(bulk.messages()) < 2 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	log.Info("third")
+	if n := sink.Flush(5 * time.Second); n != 0 {
+		t.Fatal("Entries weren't flushed: ", n)
+	}
+
+	if m := bulk.messages(); len(m) != 3 || m[0] != "first" || m[2] != "third" {
+		t.Fatal("Unexpected documents: ", m)
+	}
+	doc := bulk.docs[0]
+	if doc["logger"] != "Test" || doc["attempt"] != 2.0 || doc["level"] != "info" || doc["@timestamp"] == nil {
+		t.Error("Unexpected document: ", doc)
+	}
+	if bulk.indices[0] != "proxy-"+time.Now().UTC().Format("2006.01.02") {
+		t.Error("Unexpected index: " + bulk.indices[0])
+	}
+	if bulk.auth != "Basic dXNlcjpzZWNyZXQ=" {
+		t.Error("Basic authentication should be used: " + bulk.auth)
+	}
+}
+
+func TestElasticBuffer(t *testing.T) {
+	for _, policy := range []string{DropOldest, DropNewest} {
+		log, sink, bulk := newTestSink(t, ElasticSettings{
+			BatchSettings: BatchSettings{BatchSize: 2, BufferSize: 3, DropPolicy: policy}})
+		atomic.StoreInt32(&bulk.down, 1)
+		for _, m := range []string{"1", "2", "3", "4", "5"} {
+			log.Info(m)
+		}
+		if n := sink.Flush(100 * time.Millisecond); n != 3 {
+			t.Error("Buffer should keep 3 entries while ElasticSearch is down, got: ", n)
+		}
+
+		atomic.StoreInt32(&bulk.down, 0)
+		if n := sink.Flush(5 * time.Second); n != 0 {
+			t.Fatal("Entries weren't flushed after ElasticSearch is up: ", n)
+		}
+		expected := map[string]string{DropOldest: "[3 4 5]", DropNewest: "[1 2 3]"}[policy]
+		if m := bulk.messages(); len(m) != 3 || "["+m[0]+" "+m[1]+" "+m[2]+"]" != expected {
+			t.Error("Drop policy "+policy+": unexpected documents: ", m)
+		}
+		if sink.Dropped() != 2 {
+			t.Error("Dropped entries should be counted: ", sink.Dropped())
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package Logger
+
+import "time"
+
+// Field is typed value added to log entries, created by String, Int, Duration, Err and others
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+func String(key, value string) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Int(key string, value int) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Int64(key string, value int64) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Float(key string, value float64) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Bool(key string, value bool) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Duration is written in milliseconds, so entries can be compared and aggregated
+func Duration(key string, value time.Duration) Field {
+	return Field{Key: key, Value: float64(value) / float64(time.Millisecond)}
+}
+
+// Err adds text of error under 'error' key, nil error adds nothing
+func Err(err error) Field {
+	if err == nil {
+		return Field{}
+	}
+	return Field{Key: "error", Value: err.Error()}
+}
+
+// Any adds value as is, it's encoded by formatter of the output
+func Any(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// WithFields returns logger that adds fields to every entry, fields of the logger with the same keys are overridden
+func (l *Logger) WithFields(fields ...Field) *Logger {
+	if len(fields) == 0 {
+		return l
+	}
+	merged := make(map[string]interface{}, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for _, f := range fields {
+		if f.Key != "" {
+			merged[f.Key] = f.Value
+		}
+	}
+	return &Logger{log: l.log, name: l.name, fields: merged}
+}
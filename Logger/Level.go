@@ -0,0 +1,163 @@
+package Logger
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	levelsMu sync.Mutex
+	// level of loggers without override, set by Init and changed at runtime
+	globalLevel = LInfo
+	// level set by Init, debug toggled by signal switches back to it
+	configuredLevel = LInfo
+	// levels of loggers by their names, e.g. Authentication: Debug
+	overrides = map[string]logrus.Level{}
+	// loggers created by New by their names, so their levels can be changed at runtime
+	named = map[string][]*logrus.Logger{}
+)
+
+func levelName(level logrus.Level) string {
+	switch level {
+	case LDebug:
+		return "Debug"
+	case LInfo:
+		return "Info"
+	case LWarning:
+		return "Warning"
+	}
+	return "Error"
+}
+
+// level logger with the name writes entries of. Caller holds levelsMu
+func levelOf(name string) logrus.Level {
+	if level, exist := overrides[name]; exist {
+		return level
+	}
+	return globalLevel
+}
+
+// remembers logger, so level changes of its name are applied to it
+func register(name string, l *logrus.Logger) {
+	levelsMu.Lock()
+	defer levelsMu.Unlock()
+	l.SetLevel(levelOf(name))
+	named[name] = append(named[name], l)
+}
+
+// applies current levels to all loggers. Caller holds levelsMu
+func apply() {
+	logrus.StandardLogger().SetLevel(levelOf("default"))
+	for name, loggers := range named {
+		for _, l := range loggers {
+			l.SetLevel(levelOf(name))
+		}
+	}
+}
+
+// InitLevels sets levels of loggers by their names, other loggers use level of Init. Called after Init
+func InitLevels(levels map[string]string) {
+	parsed := map[string]logrus.Level{}
+	for name, v := range levels {
+		level, err := parseLevel(v)
+		if err != nil {
+			panic("Invalid level of logger " + name + ". Error: " + err.Error())
+		}
+		parsed[name] = level
+	}
+
+	levelsMu.Lock()
+	defer levelsMu.Unlock()
+	overrides = parsed
+	apply()
+}
+
+// SetLevel changes level at runtime. Empty name changes level of all loggers without override,
+// empty level removes override of the name. The change is logged
+func SetLevel(name, level string) error {
+	levelsMu.Lock()
+	old := levelOf(name)
+	switch {
+	case name == "" && level == "":
+		globalLevel = configuredLevel
+	case level == "":
+		delete(overrides, name)
+	default:
+		parsed, err := parseLevel(level)
+		if err != nil {
+			levelsMu.Unlock()
+			return err
+		}
+		if name == "" {
+			globalLevel = parsed
+		} else {
+			overrides[name] = parsed
+		}
+	}
+	current := levelOf(name)
+	apply()
+	levelsMu.Unlock()
+
+	if name == "" {
+		name = "*"
+	}
+	// warning is used, so the change is visible unless only errors are written
+	Warning(map[string]string{"target": name, "from": levelName(old), "to": levelName(current)}, "Log level changed")
+	return nil
+}
+
+// Levels returns level of loggers without override and overrides by logger names
+func Levels() (string, map[string]string) {
+	levelsMu.Lock()
+	defer levelsMu.Unlock()
+	rv := map[string]string{}
+	for name, level := range overrides {
+		rv[name] = levelName(level)
+	}
+	return levelName(globalLevel), rv
+}
+
+// toggles debug level of all loggers without override, it's done on SIGUSR2
+func toggleDebug() {
+	levelsMu.Lock()
+	level := ""
+	if globalLevel == LDebug && configuredLevel != LDebug {
+		level = levelName(configuredLevel)
+	} else {
+		level = "Debug"
+	}
+	levelsMu.Unlock()
+	SetLevel("", level)
+}
+
+// LevelRequest changes level of the logger, empty logger changes level of all loggers without override.
+// Empty level removes override of the logger or restores configured level
+type LevelRequest struct {
+	Logger string
+	Level  string
+}
+
+// Registers log level operations on admin router group
+func RegisterAdmin(group *gin.RouterGroup) {
+	group.GET("/levels", func(c *gin.Context) {
+		level, overrides := Levels()
+		c.JSON(http.StatusOK, gin.H{"level": level, "overrides": overrides})
+	})
+
+	group.PUT("/levels", func(c *gin.Context) {
+		var r LevelRequest
+		if err := c.ShouldBindJSON(&r); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := SetLevel(r.Logger, r.Level); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		level, overrides := Levels()
+		c.JSON(http.StatusOK, gin.H{"level": level, "overrides": overrides})
+	})
+}
@@ -1,10 +1,8 @@
 package Logger
 
 import (
-	elasticsearch7 "github.com/elastic/go-elasticsearch/v7"
 	"github.com/mitchellh/mapstructure"
 	"github.com/sirupsen/logrus"
-	"gopkg.in/go-extras/elogrus.v7"
 	"os"
 )
 
@@ -37,30 +35,69 @@ var elasticUrl string
 var defaultInit = false
 // default logger
 var defaultLogger Logger
-// levels of logging that are allowed
-var levels []logrus.Level
 
 type InitData struct {
 	Level string
 	UseStd bool
 	UseElastic string
 	UseFile string
+	// access log of proxied requests, disabled if not specified
+	Access *AccessSettings
+	// rotation of 'UseFile' and access log files, they grow forever if not specified
+	Rotation *RotationSettings
+	// index, authentication and batching of 'UseElastic' output
+	Elastic *ElasticSettings
+	// other outputs, each one is object with 'type' and its own settings, see SinkSettings
+	Sinks []interface{}
+	// levels of loggers by their names that differ from 'Level', e.g. "Authentication": "Debug"
+	Levels map[string]string
+	// values masked in entries of all outputs in addition to the defaults
+	Redact *RedactSettings
 }
 
 type Logger struct {
 	log *logrus.Logger
 	name string
+	// added to every entry, e.g. id of the request
+	fields map[string]interface{}
 }
 
-func mapToFields(data map[string]string) map[string]interface{} {
+// fields of entry made by package level functions, data can be nil
+func mapToFields(name string, data map[string]string) map[string]interface{} {
 	out := map[string]interface{}{}
 	for k, v := range data {
 		out[k] = v
 	}
+	if name == "" {
+		name = "default"
+	}
+	out["logger"] = name
 
 	return out
 }
 
+// fields of the entry: logger fields overridden by data of the call
+func (l *Logger) entryFields(data map[string]string) map[string]interface{} {
+	out := map[string]interface{}{}
+	for k, v := range l.fields {
+		out[k] = v
+	}
+	for k, v := range data {
+		out[k] = v
+	}
+	out["logger"] = l.name
+
+	return out
+}
+
+// logrus logger of the entries, default logger returned before Init writes with standard one
+func (l *Logger) logger() *logrus.Logger {
+	if l.log == nil {
+		return logrus.StandardLogger()
+	}
+	return l.log
+}
+
 func getLevelGroupFromLevel(level uint32) []logrus.Level {
 
 	levels := []logrus.Level{LDebug, LInfo, LWarning, LError}
@@ -78,30 +115,27 @@ func getLevelGroupFromLevel(level uint32) []logrus.Level {
 
 // add std output as a hook for given logger
 func configureStdOutput(l *logrus.Logger) {
-	h := WriteHook{writer: os.Stdout, levels: levels}
+	h := WriteHook{writer: os.Stdout, levels: logrus.AllLevels}
 	l.AddHook(&h)
 }
 
-// adds file output as hook for given logger
+// adds file output as hook for given logger. All loggers of the path share one file, it's rotated
+// as set by InitRotation and reopened on SIGUSR1
 func configureFileOutput(l *logrus.Logger, path string) {
-	file, err := os.OpenFile(path, os.O_CREATE | os.O_APPEND , 0666)
+	file, err := OpenFile(path)
 	if err != nil {panic("Failed to open file while 'configureFileOutput'. Error: " + err.Error())}
-	h := WriteHook{writer: file, levels:levels }
+	reopenOnSignal()
+	h := WriteHook{writer: file, levels: logrus.AllLevels}
 	l.AddHook(&h)
 }
 
-// adds elastic search output as hook for given logger
+// adds elastic search output as hook for given logger. All loggers of the url share one sink
+// configured by InitElastic, entries are sent in background and can be flushed on shutdown
 func configureElasticOutput(l *logrus.Logger, url string) {
+	sink, err := elasticSink(url)
+	if err != nil { panic("Failed to create ElasticSearch sink. Error: " + err.Error()) }
 
-	client, err := elasticsearch7.NewClient(elasticsearch7.Config{
-		Addresses: []string{url},
-	})
-	if err != nil { panic("Failed to create ElasticSearch client. Errpr: " + err.Error()) }
-
-	hook, err := elogrus.NewAsyncElasticHook(client, "localhost", logrus.Level(levels[0]), "mylog")
-	if err != nil { panic("Failed to create ElasticSearch hook client. Errpr: " + err.Error()) }
-
-	l.AddHook(hook)
+	l.AddHook(sink)
 }
 
 // Init default settings of logger. Possible settings:
@@ -112,7 +146,12 @@ func configureElasticOutput(l *logrus.Logger, url string) {
 func Init(level uint32, flags int, data map[string]string) {
 	if flags == 0 { panic("Logger.Init(); At least one flags for output should be specified")}
 	
-	levels = getLevelGroupFromLevel(level)
+	getLevelGroupFromLevel(level)
+	levelsMu.Lock()
+	configuredLevel, globalLevel = logrus.Level(level), logrus.Level(level)
+	apply()
+	levelsMu.Unlock()
+	toggleDebugOnSignal()
 
 	if flags&UseFile != 0 {
 		if v, exist := data[FilePath]; exist {
@@ -159,18 +198,9 @@ func ReadLoggerDataFromFile(d interface{}) *InitData {
 
 // Converts string logger level to logger level
 func StringLevelToLevel(l string) uint32 {
-	switch l {
-	case "Debug":
-		return uint32(LDebug)
-	case "Info":
-		return uint32(LInfo)
-	case "Warning":
-		return uint32(LWarning)
-	case "Error":
-		return uint32(LError)
-	default:
-		panic("Invalid string level is within config files !")
-	}
+	level, err := parseLevel(l)
+	if err != nil {panic("Invalid string level is within config files ! " + err.Error())}
+	return uint32(level)
 }
 
 // converts InitData to falgs and data map[string]string
@@ -200,6 +230,8 @@ func New(name string, flags int, data map[string]string) *Logger {
 
 	l := Logger{}
 	l.log = logrus.New()
+	// sensitive values are masked before hooks of outputs receive entries
+	l.log.AddHook(RedactHook{})
 	l.name = name
 	
 	if flags == 0 {
@@ -210,6 +242,8 @@ func New(name string, flags int, data map[string]string) *Logger {
 		if useStdOut {configureStdOutput(l.log)}
 		if fileOutPath != "" {configureFileOutput(l.log, fileOutPath)}
 		if elasticUrl != "" {configureElasticOutput(l.log, elasticUrl)}
+		configureSinks(l.log)
+		register(name, l.log)
 
 		return &l
 	}
@@ -239,47 +273,43 @@ func New(name string, flags int, data map[string]string) *Logger {
 			return &defaultLogger
 		}
 	}
+	configureSinks(l.log)
+	register(name, l.log)
 
 	return &l
 }
 
+// Logging methods add data of the call to fields of the logger, data can be nil
 func (l *Logger) Debug(data map[string]string, message string) {
-	data["logger"] = l.name
-	l.log.WithFields(mapToFields(data)).Debug(message)
+	l.logger().WithFields(l.entryFields(data)).Debug(message)
 }
 
 func (l *Logger) Info(data map[string]string, message string) {
-	data["logger"] = l.name
-	l.log.WithFields(mapToFields(data)).Info(message)
+	l.logger().WithFields(l.entryFields(data)).Info(message)
 }
 
 func (l *Logger) Warning(data map[string]string, message string) {
-	data["logger"] = l.name
-	l.log.WithFields(mapToFields(data)).Warning(message)
+	l.logger().WithFields(l.entryFields(data)).Warning(message)
 }
 
 func (l *Logger) Error(data map[string]string, message string) {
-	data["logger"] = l.name
-	l.log.WithFields(mapToFields(data)).Error(message)
+	l.logger().WithFields(l.entryFields(data)).Error(message)
 }
 
+// Package level functions write with standard logger, data isn't modified and can be nil
 func Debug(name string, data map[string]string, message string) {
-	data["logger"] = "default"
-	logrus.StandardLogger().WithFields(mapToFields(data)).Debug(message)
+	logrus.StandardLogger().WithFields(mapToFields(name, data)).Debug(message)
 }
 
 func Info(name string, data map[string]string, message string) {
-	data["logger"] = "default"
-	logrus.StandardLogger().WithFields(mapToFields(data)).Info(message)
+	logrus.StandardLogger().WithFields(mapToFields(name, data)).Info(message)
 }
 
 func Warning(data map[string]string, message string) {
-	data["logger"] = "default"
-	logrus.StandardLogger().WithFields(mapToFields(data)).Warning(message)
+	logrus.StandardLogger().WithFields(mapToFields("", data)).Warning(message)
 }
 
 func Error(data map[string]string, message string) {
-	data["logger"] = "default"
-	logrus.StandardLogger().WithFields(mapToFields(data)).Error(message)
+	logrus.StandardLogger().WithFields(mapToFields("", data)).Error(message)
 }
 
@@ -0,0 +1,140 @@
+package Logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+func newTestLogger(name string) (*Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	log := logrus.New()
+	log.SetOutput(&buf)
+	log.SetFormatter(&logrus.JSONFormatter{})
+	return &Logger{log: log, name: name}, &buf
+}
+
+func lastEntry(t *testing.T, buf *bytes.Buffer) map[string]interface{} {
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	var entry map[string]interface{}
+	if err := json.Unmarshal(lines[len(lines)-1], &entry); err != nil {
+		t.Fatal("Entry isn't json: ", buf.String())
+	}
+	return entry
+}
+
+func TestWithFields(t *testing.T) {
+	base, buf := newTestLogger("Test")
+	l := base.WithFields(String("route", "/api"), Int("attempt", 2))
+	l.WithFields(Duration("latency", 1500*time.Microsecond), Err(errors.New("refused")), Err(nil)).
+		Warning(map[string]string{"attempt": "3"}, "Upstream failed")
+
+	e := lastEntry(t, buf)
+	expected := map[string]interface{}{"logger": "Test", "route": "/api", "attempt": "3", "latency": 1.5,
+		"error": "refused", "msg": "Upstream failed"}
+	for k, v := range expected {
+		if e[k] != v {
+			t.Errorf("Field %s: expected %v, got %v", k, v, e[k])
+		}
+	}
+
+	base.Info(nil, "Plain")
+	if e := lastEntry(t, buf); e["route"] != nil || e["logger"] != "Test" {
+		t.Error("Derived logger fields shouldn't be added to the parent: ", e)
+	}
+}
+
+func TestPackageFunctions(t *testing.T) {
+	var buf bytes.Buffer
+	out := logrus.StandardLogger().Out
+	logrus.SetOutput(&buf)
+	defer logrus.SetOutput(out)
+
+	data := map[string]string{"key": "value"}
+	Error(data, "With data")
+	Warning(nil, "Without data")
+	if len(data) != 1 {
+		t.Error("Data of the call shouldn't be modified: ", data)
+	}
+	if bytes.Count(buf.Bytes(), []byte("\n")) != 2 {
+		t.Error("Both entries should be written: ", buf.String())
+	}
+}
+
+func TestFromGin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	base, buf := newTestLogger("main")
+
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) {
+		c.Request = c.Request.WithContext(ContextWithRequestID(c.Request.Context(), "req-1"))
+	}, Middleware(base))
+	engine.GET("/path", func(c *gin.Context) {
+		FromGin(c).Info(nil, "Handled")
+	})
+	engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/path", nil))
+
+	e := lastEntry(t, buf)
+	if e["request_id"] != "req-1" || e["method"] != "GET" || e["path"] != "/path" || e["logger"] != "main" {
+		t.Error("Request-scoped logger should add fields of the request: ", e)
+	}
+}
+
+func TestLevels(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	defer func() {
+		InitLevels(nil)
+		SetLevel("", "")
+		named = map[string][]*logrus.Logger{}
+	}()
+	InitLevels(map[string]string{"Authentication": "Debug"})
+	auth, endpoint := logrus.New(), logrus.New()
+	register("Authentication", auth)
+	register("Endpoint", endpoint)
+
+	check := func(step string, authLevel, endpointLevel logrus.Level) {
+		if auth.GetLevel() != authLevel || endpoint.GetLevel() != endpointLevel {
+			t.Errorf("%s: unexpected levels %v, %v", step, auth.GetLevel(), endpoint.GetLevel())
+		}
+	}
+	check("override", LDebug, LInfo)
+
+	engine := gin.New()
+	RegisterAdmin(engine.Group("/log"))
+	put := func(body string) int {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest("PUT", "/log/levels", strings.NewReader(body)))
+		return w.Code
+	}
+	if put(`{"logger": "Endpoint", "level": "Verbose"}`) != http.StatusBadRequest {
+		t.Error("Invalid level should be rejected")
+	}
+	if put(`{"logger": "Endpoint", "level": "Warning"}`) != http.StatusOK {
+		t.Error("Level should be changed by admin request")
+	}
+	check("runtime override", LDebug, LWarning)
+
+	SetLevel("", "Error")
+	check("global level", LDebug, LWarning)
+	SetLevel("Endpoint", "")
+	check("override removed", LDebug, LError)
+
+	toggleDebug()
+	check("debug toggled", LDebug, LDebug)
+	toggleDebug()
+	check("debug toggled back", LDebug, LInfo)
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest("GET", "/log/levels", nil))
+	if w.Body.String() != `{"level":"Info","overrides":{"Authentication":"Debug"}}` {
+		t.Error("Unexpected levels: " + w.Body.String())
+	}
+}
@@ -0,0 +1,42 @@
+package Logger
+
+import (
+	"proxy/Metrics"
+)
+
+func init() {
+	Metrics.NewCounterFunc("proxy_log_dropped_entries_total",
+		"Log entries dropped by sinks because buffer was full or destination rejected them",
+		[]string{"sink", "target"}, func() []Metrics.Sample {
+			return sinkSamples(func(b *batcher) float64 { return float64(b.Dropped()) })
+		})
+	Metrics.NewGaugeFunc("proxy_log_buffered_entries", "Log entries waiting to be sent by sinks",
+		[]string{"sink", "target"}, func() []Metrics.Sample {
+			return sinkSamples(func(b *batcher) float64 { return float64(b.buffered()) })
+		})
+}
+
+// value of every sink created, labeled by sink type and its destination
+func sinkSamples(value func(b *batcher) float64) []Metrics.Sample {
+	configuredMu.Lock()
+	sinks := append([]Sink{}, all...)
+	configuredMu.Unlock()
+
+	var rv []Metrics.Sample
+	for _, s := range sinks {
+		var labels []string
+		var b *batcher
+		switch sink := s.(type) {
+		case *ElasticSink:
+			labels, b = []string{"elastic", sink.url}, sink.batcher
+		case *SyslogSink:
+			labels, b = []string{"syslog", sink.settings.Network + "://" + sink.settings.Address}, sink.batcher
+		case *OtlpSink:
+			labels, b = []string{"otlp", sink.settings.Url}, sink.batcher
+		default:
+			continue
+		}
+		rv = append(rv, Metrics.Sample{Labels: labels, Value: value(b)})
+	}
+	return rv
+}
@@ -0,0 +1,235 @@
+package Logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// OtlpCollector describes OpenTelemetry collector receiving OTLP/HTTP json exports, it's shared by otlp sink and tracing
+type OtlpCollector struct {
+	// url of the collector, path of the signal is used if it has no path: http://collector:4318
+	Url string
+	// added to every request, e.g. authorization
+	Headers map[string]string
+	// service.name resource attribute, proxy by default
+	ServiceName string
+	// seconds export request can take, 10 by default
+	Timeout int
+}
+
+// Validate checks collector settings, path is used if url has none, e.g. /v1/logs
+func (c *OtlpCollector) Validate(path string) error {
+	u, err := url.Parse(c.Url)
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return errors.New("OTLP export should contain http(s) 'url' of the collector")
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = path
+		c.Url = u.String()
+	}
+	if c.ServiceName == "" {
+		c.ServiceName = "proxy"
+	}
+	if c.Timeout < 0 {
+		return errors.New("OTLP export timeout can't be negative")
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 10
+	}
+	return nil
+}
+
+// OtlpSettings describe sink exporting entries to OpenTelemetry collector, /v1/logs is used if url has no path
+type OtlpSettings struct {
+	SinkSettings  `mapstructure:",squash"`
+	OtlpCollector `mapstructure:",squash"`
+	// 512 entries are exported at once by default
+	BatchSettings `mapstructure:",squash"`
+}
+
+func (s *OtlpSettings) Validate() error {
+	if err := s.SinkSettings.Validate(); err != nil {
+		return err
+	}
+	if err := s.OtlpCollector.Validate("/v1/logs"); err != nil {
+		return err
+	}
+	if err := s.BatchSettings.Validate(512); err != nil {
+		return errors.New("OTLP settings: " + err.Error())
+	}
+	return nil
+}
+
+// OtlpExporter sends OTLP json encoded items to the collector in batches
+type OtlpExporter struct {
+	*batcher
+	collector OtlpCollector
+	client    *http.Client
+	// resource and scope of all items, items are inserted between prefix and suffix
+	prefix, suffix []byte
+}
+
+// NewOtlpExporter creates exporter of signal, e.g. Logs with logRecords items or Spans with spans items.
+// Settings should be validated
+func NewOtlpExporter(collector OtlpCollector, settings BatchSettings, signal string, items string) *OtlpExporter {
+	e := &OtlpExporter{collector: collector, client: &http.Client{Timeout: time.Duration(collector.Timeout) * time.Second}}
+	attributes := OtlpAttributes(map[string]interface{}{"service.name": collector.ServiceName})
+	resource, _ := json.Marshal(map[string]interface{}{"attributes": attributes})
+	e.prefix = []byte(`{"resource` + signal + `":[{"resource":` + string(resource) + `,"scope` + signal +
+		`":[{"scope":{"name":"proxy"},"` + items + `":[`)
+	e.suffix = []byte(`]}]}]}`)
+	e.batcher = newBatcher(settings, e.send)
+	return e
+}
+
+// Add queues encoded item, it's dropped as set by DropPolicy if buffer is full
+func (e *OtlpExporter) Add(item []byte) {
+	e.add(item)
+}
+
+// exports batch with one request. Requests rejected because collector is overloaded or unavailable are retried
+func (e *OtlpExporter) send(batch [][]byte) ([][]byte, error) {
+	body := bytes.NewBuffer(append([]byte{}, e.prefix...))
+	body.Write(bytes.Join(batch, []byte(",")))
+	body.Write(e.suffix)
+
+	req, err := http.NewRequest("POST", e.collector.Url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.collector.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return batch, err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return nil, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusBadGateway ||
+		resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusGatewayTimeout:
+		return batch, errors.New("OTLP export failed: " + resp.Status)
+	}
+	e.drop(len(batch))
+	return nil, nil
+}
+
+// OtlpSink exports entries as OpenTelemetry log records, fields are sent as attributes
+type OtlpSink struct {
+	*OtlpExporter
+	settings OtlpSettings
+	levels   []logrus.Level
+}
+
+// NewOtlpSink creates sink exporting to collector of settings
+func NewOtlpSink(settings OtlpSettings) (*OtlpSink, error) {
+	if err := settings.Validate(); err != nil {
+		return nil, err
+	}
+	s := &OtlpSink{settings: settings, levels: settings.levels(),
+		OtlpExporter: NewOtlpExporter(settings.OtlpCollector, settings.BatchSettings, "Logs", "logRecords")}
+	track(s)
+	return s, nil
+}
+
+func newOtlpFromFile(settings map[string]interface{}) (Sink, error) {
+	var s OtlpSettings
+	if err := decodeSink(settings, &s); err != nil {
+		return nil, err
+	}
+	return NewOtlpSink(s)
+}
+
+func (s *OtlpSink) Levels() []logrus.Level {
+	return s.levels
+}
+
+// severity number and text of OpenTelemetry log data model
+func otlpSeverity(level logrus.Level) (int, string) {
+	switch level {
+	case logrus.PanicLevel, logrus.FatalLevel:
+		return 21, "FATAL"
+	case logrus.ErrorLevel:
+		return 17, "ERROR"
+	case logrus.WarnLevel:
+		return 13, "WARN"
+	case logrus.InfoLevel:
+		return 9, "INFO"
+	case logrus.DebugLevel:
+		return 5, "DEBUG"
+	}
+	return 1, "TRACE"
+}
+
+// OtlpValue is attribute value of OTLP json encoding
+type OtlpValue map[string]interface{}
+
+// OtlpAttribute is key and value of OTLP json encoding
+type OtlpAttribute struct {
+	Key   string    `json:"key"`
+	Value OtlpValue `json:"value"`
+}
+
+// NewOtlpValue encodes attribute value, 64 bit integers are encoded as strings and values of other types as json
+func NewOtlpValue(v interface{}) OtlpValue {
+	switch value := v.(type) {
+	case string:
+		return OtlpValue{"stringValue": value}
+	case bool:
+		return OtlpValue{"boolValue": value}
+	case int:
+		return OtlpValue{"intValue": strconv.Itoa(value)}
+	case int64:
+		return OtlpValue{"intValue": strconv.FormatInt(value, 10)}
+	case float64:
+		return OtlpValue{"doubleValue": value}
+	case error:
+		return OtlpValue{"stringValue": value.Error()}
+	}
+	return OtlpValue{"stringValue": jsonValue(v)}
+}
+
+// OtlpAttributes encodes fields as attributes
+func OtlpAttributes(fields map[string]interface{}) []OtlpAttribute {
+	rv := make([]OtlpAttribute, 0, len(fields))
+	for k, v := range fields {
+		rv = append(rv, OtlpAttribute{Key: k, Value: NewOtlpValue(v)})
+	}
+	return rv
+}
+
+func (s *OtlpSink) Fire(entry *logrus.Entry) error {
+	number, text := otlpSeverity(entry.Level)
+	body := entry.Message
+	if s.settings.Format == FormatJSON {
+		body = s.settings.json(entry)
+	}
+	record := map[string]interface{}{
+		"timeUnixNano":         strconv.FormatInt(entry.Time.UnixNano(), 10),
+		"observedTimeUnixNano": strconv.FormatInt(time.Now().UnixNano(), 10),
+		"severityNumber":       number,
+		"severityText":         text,
+		"body":                 NewOtlpValue(body),
+		"attributes":           OtlpAttributes(entry.Data),
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	s.add(data)
+	return nil
+}
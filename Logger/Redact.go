@@ -0,0 +1,203 @@
+package Logger
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RedactSettings describe values masked before entries reach any output. Lists are added to the defaults
+type RedactSettings struct {
+	// names of fields whose values are masked, matched case-insensitively
+	Fields []string
+	// headers masked in fields holding http.Header and fields named as the headers
+	Headers []string
+	// query parameters whose values are masked in urls within messages and fields
+	Query []string
+	// regular expressions of masked text or built-in patterns: bearer, jwt, card, email
+	Patterns []string
+	// text values are replaced with, [REDACTED] by default
+	Mask string
+}
+
+var (
+	defaultRedactFields   = []string{"password", "passwd", "secret", "token", "access_token", "refresh_token", "api_key", "apikey"}
+	defaultRedactHeaders  = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+	defaultRedactQuery    = []string{"token", "access_token", "api_key", "apikey", "password", "secret"}
+	defaultRedactPatterns = []string{"bearer", "jwt", "card"}
+
+	builtinPatterns = map[string]string{
+		"bearer": `(?i)\b(?:bearer|basic)\s+[A-Za-z0-9\-._~+/]+=*`,
+		"jwt":    `\beyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`,
+		// candidates are checked with Luhn algorithm, so other long numbers aren't masked
+		"card":  `\b(?:\d[ -]?){12,18}\d\b`,
+		"email": `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,
+	}
+)
+
+// Redactor masks sensitive values of entries
+type Redactor struct {
+	mask     string
+	fields   map[string]bool
+	headers  map[string]bool
+	query    *regexp.Regexp
+	patterns []*regexp.Regexp
+	card     *regexp.Regexp
+}
+
+// NewRedactor creates redactor masking defaults and values of settings, settings can be nil
+func NewRedactor(s *RedactSettings) (*Redactor, error) {
+	if s == nil {
+		s = &RedactSettings{}
+	}
+	r := &Redactor{mask: s.Mask, fields: map[string]bool{}, headers: map[string]bool{}}
+	if r.mask == "" {
+		r.mask = "[REDACTED]"
+	}
+	for _, f := range append(append([]string{}, defaultRedactFields...), s.Fields...) {
+		r.fields[strings.ToLower(f)] = true
+	}
+	for _, h := range append(append([]string{}, defaultRedactHeaders...), s.Headers...) {
+		r.headers[strings.ToLower(h)] = true
+	}
+
+	var names []string
+	for _, q := range append(append([]string{}, defaultRedactQuery...), s.Query...) {
+		names = append(names, regexp.QuoteMeta(q))
+	}
+	r.query = regexp.MustCompile(`(?i)([?&](?:` + strings.Join(names, "|") + `)=)[^&#\s"]*`)
+
+	for _, p := range append(append([]string{}, defaultRedactPatterns...), s.Patterns...) {
+		expr, builtin := builtinPatterns[p]
+		if !builtin {
+			expr = p
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, errors.New("Invalid redaction pattern " + p + ". Error: " + err.Error())
+		}
+		if p == "card" {
+			r.card = re
+			continue
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// String masks query parameters and patterns in text
+func (r *Redactor) String(s string) string {
+	s = r.query.ReplaceAllString(s, "${1}"+r.mask)
+	for _, re := range r.patterns {
+		s = re.ReplaceAllString(s, r.mask)
+	}
+	if r.card != nil {
+		s = r.card.ReplaceAllStringFunc(s, func(m string) string {
+			if luhn(m) {
+				return r.mask
+			}
+			return m
+		})
+	}
+	return s
+}
+
+// Header returns copy of header with sensitive values masked
+func (r *Redactor) Header(h http.Header) http.Header {
+	rv := make(http.Header, len(h))
+	for k, values := range h {
+		masked := make([]string, len(values))
+		for i, v := range values {
+			if r.headers[strings.ToLower(k)] {
+				masked[i] = r.mask
+			} else {
+				masked[i] = r.String(v)
+			}
+		}
+		rv[k] = masked
+	}
+	return rv
+}
+
+// Fields masks values of fields in place
+func (r *Redactor) Fields(data logrus.Fields) {
+	for k, v := range data {
+		key := strings.ToLower(k)
+		if r.fields[key] || r.headers[key] {
+			data[k] = r.mask
+			continue
+		}
+		switch value := v.(type) {
+		case string:
+			data[k] = r.String(value)
+		case error:
+			data[k] = r.String(value.Error())
+		case http.Header:
+			data[k] = r.Header(value)
+		case map[string][]string:
+			data[k] = map[string][]string(r.Header(value))
+		}
+	}
+}
+
+// luhn reports whether digits of s have valid card number checksum
+func luhn(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
+
+var redactor atomic.Value
+
+func init() {
+	r, _ := NewRedactor(nil)
+	redactor.Store(r)
+	logrus.StandardLogger().AddHook(RedactHook{})
+}
+
+// InitRedact sets values masked in addition to the defaults, nil leaves only the defaults
+func InitRedact(settings *RedactSettings) {
+	r, err := NewRedactor(settings)
+	if err != nil {
+		panic(err.Error())
+	}
+	redactor.Store(r)
+}
+
+// Redact masks sensitive values of text with current settings, e.g. url of the request
+func Redact(s string) string {
+	return redactor.Load().(*Redactor).String(s)
+}
+
+// RedactHook masks entry before it's written. It's added before outputs of every logger, so none of them
+// receives sensitive values
+type RedactHook struct{}
+
+func (RedactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (RedactHook) Fire(entry *logrus.Entry) error {
+	r := redactor.Load().(*Redactor)
+	r.Fields(entry.Data)
+	entry.Message = r.String(entry.Message)
+	return nil
+}
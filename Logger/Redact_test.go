@@ -0,0 +1,66 @@
+package Logger
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRedactor(t *testing.T) {
+	r, err := NewRedactor(&RedactSettings{Query: []string{"sig"}, Patterns: []string{"email", `ssn-\d+`}, Mask: "***"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]string{
+		"GET /api?id=1&access_token=abc.def&sig=xyz#top": "GET /api?id=1&access_token=***&sig=***#top",
+		"header Authorization: Bearer abc-123=":          "header Authorization: ***",
+		"token eyJhbGciOi.eyJzdWIiOiIx.c2lnbmF0dXJl":     "token ***",
+		"paid with 4111 1111 1111 1111 today":            "paid with *** today",
+		"order 1234567890123 isn't a card":               "order 1234567890123 isn't a card",
+		"contact john.doe@example.com, ssn-42":           "contact ***, ***",
+	}
+	for in, expected := range cases {
+		if out := r.String(in); out != expected {
+			t.Errorf("Redacted %q: expected %q, got %q", in, expected, out)
+		}
+	}
+
+	if _, err := NewRedactor(&RedactSettings{Patterns: []string{"("}}); err == nil {
+		t.Error("Invalid pattern should be rejected")
+	}
+}
+
+func TestRedactHook(t *testing.T) {
+	InitRedact(&RedactSettings{Fields: []string{"Response"}})
+	defer InitRedact(nil)
+
+	l, buf := newTestLogger("Test")
+	l.log.AddHook(RedactHook{})
+	header := http.Header{"Authorization": {"Basic dXNlcjpwYXNz"}, "Accept": {"*/*"}}
+	l.WithFields(Any("headers", header), Err(errors.New("dial /login?password=hunter2"))).
+		Error(map[string]string{"Response": "user data", "Cookie": "session=1", "Status": "401"},
+			"Auth failed for /login?token=abc")
+
+	e := lastEntry(t, buf)
+	expected := map[string]interface{}{"Response": "[REDACTED]", "Cookie": "[REDACTED]", "Status": "401",
+		"error": "dial /login?password=[REDACTED]", "msg": "Auth failed for /login?token=[REDACTED]"}
+	for k, v := range expected {
+		if e[k] != v {
+			t.Errorf("Field %s: expected %v, got %v", k, v, e[k])
+		}
+	}
+	headers := e["headers"].(map[string]interface{})
+	if headers["Authorization"].([]interface{})[0] != "[REDACTED]" || headers["Accept"].([]interface{})[0] != "*/*" {
+		t.Error("Sensitive headers should be masked: ", headers)
+	}
+	if header.Get("Authorization") != "Basic dXNlcjpwYXNz" {
+		t.Error("Header of the caller shouldn't be modified")
+	}
+
+	a, out := newTestAccess(t, &AccessSettings{Format: AccessCommon})
+	a.Log(&AccessEntry{Method: "GET", Path: "/cb?code=1&access_token=abc", Protocol: "HTTP/1.1", Status: 200})
+	if !strings.Contains(out.String(), `"GET /cb?code=1&access_token=[REDACTED] HTTP/1.1"`) {
+		t.Error("Query parameters of access log path should be masked: " + out.String())
+	}
+}
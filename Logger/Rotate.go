@@ -0,0 +1,306 @@
+package Logger
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	RotateHourly = "hourly"
+	RotateDaily  = "daily"
+
+	// time of rotation is put into names of rotated files: proxy.log -> proxy-2020-10-10T13-55-36.000.log
+	backupTimeFormat = "2006-01-02T15-04-05.000"
+)
+
+// RotationSettings describes rotation of log files, files are rotated by size, by time or both
+type RotationSettings struct {
+	// megabytes file can grow to before it's rotated, 0 disables rotation by size
+	MaxSize int
+	// hourly or daily, empty disables rotation by time
+	Interval string
+	// rotated files that are kept, 0 keeps all of them
+	MaxBackups int
+	// days rotated files are kept for, 0 keeps them regardless of age
+	MaxAge int
+	// rotated files are compressed with gzip
+	Compress bool
+}
+
+func (s *RotationSettings) Validate() error {
+	if s.MaxSize < 0 || s.MaxBackups < 0 || s.MaxAge < 0 {
+		return errors.New("Log rotation MaxSize, MaxBackups and MaxAge can't be negative")
+	}
+	switch s.Interval {
+	case "", RotateHourly, RotateDaily:
+	default:
+		return errors.New("Unsupported log rotation interval: " + s.Interval + ". Supported: hourly, daily")
+	}
+	return nil
+}
+
+// RotatingFile is appended by all loggers writing to the same path. Every Write is written as a whole,
+// file is rotated between writes
+type RotatingFile struct {
+	path     string
+	settings RotationSettings
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+
+	// serializes compression and removal of rotated files running in background
+	cleanup sync.Mutex
+}
+
+var (
+	rotation RotationSettings
+
+	filesMu sync.Mutex
+	files   = map[string]*RotatingFile{}
+)
+
+// InitRotation sets rotation of files opened by Init and New after it, nil disables rotation
+func InitRotation(settings *RotationSettings) {
+	if settings == nil {
+		rotation = RotationSettings{}
+		return
+	}
+	if err := settings.Validate(); err != nil {
+		panic(err.Error())
+	}
+	rotation = *settings
+}
+
+// OpenFile returns file shared by all writers of the path, it's opened on the first call
+func OpenFile(path string) (*RotatingFile, error) {
+	filesMu.Lock()
+	defer filesMu.Unlock()
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if f, exist := files[abs]; exist {
+		return f, nil
+	}
+	f := &RotatingFile{path: abs, settings: rotation}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	files[abs] = f
+	return f, nil
+}
+
+// Reopen reopens all log files, so files moved by external tool like logrotate aren't written anymore
+func Reopen() error {
+	filesMu.Lock()
+	defer filesMu.Unlock()
+
+	var rv error
+	for _, f := range files {
+		if err := f.Reopen(); err != nil && rv == nil {
+			rv = err
+		}
+	}
+	return rv
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size, f.opened = file, info.Size(), time.Now()
+	return nil
+}
+
+// Write appends p, file is rotated before if p doesn't fit into it or interval has passed
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	if f.due(len(p)) {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *RotatingFile) due(next int) bool {
+	if f.size == 0 {
+		return false
+	}
+	if max := int64(f.settings.MaxSize) * 1024 * 1024; max > 0 && f.size+int64(next) > max {
+		return true
+	}
+	now := time.Now()
+	switch f.settings.Interval {
+	case RotateHourly:
+		return !now.Truncate(time.Hour).Equal(f.opened.Truncate(time.Hour))
+	case RotateDaily:
+		y1, m1, d1 := now.Date()
+		y2, m2, d2 := f.opened.Date()
+		return y1 != y2 || m1 != m2 || d1 != d2
+	}
+	return false
+}
+
+// renames current file to backup and opens new one, backups are cleaned up in background
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	if err := os.Rename(f.path, f.backupName(time.Now())); err != nil {
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	go f.clean()
+	return nil
+}
+
+// Reopen closes file and opens it by the path again, closed file stays closed
+func (f *RotatingFile) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	f.file.Close()
+	f.file = nil
+	return f.open()
+}
+
+// Close closes file, it isn't shared anymore. Next Write opens it again
+func (f *RotatingFile) Close() error {
+	filesMu.Lock()
+	if files[f.path] == f {
+		delete(files, f.path)
+	}
+	filesMu.Unlock()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *RotatingFile) backupName(t time.Time) string {
+	ext := filepath.Ext(f.path)
+	return strings.TrimSuffix(f.path, ext) + "-" + t.Format(backupTimeFormat) + ext
+}
+
+// rotated files of the path, newest first
+func (f *RotatingFile) backups() ([]string, error) {
+	ext := filepath.Ext(f.path)
+	prefix := filepath.Base(strings.TrimSuffix(f.path, ext)) + "-"
+	entries, err := ioutil.ReadDir(filepath.Dir(f.path))
+	if err != nil {
+		return nil, err
+	}
+
+	var rv []string
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), ".gz")
+		if e.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext)
+		if _, err := time.Parse(backupTimeFormat, stamp); err != nil {
+			continue
+		}
+		rv = append(rv, filepath.Join(filepath.Dir(f.path), e.Name()))
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(rv)))
+	return rv, nil
+}
+
+// compresses rotated files and removes the ones beyond MaxBackups or older than MaxAge
+func (f *RotatingFile) clean() {
+	f.cleanup.Lock()
+	defer f.cleanup.Unlock()
+
+	backups, err := f.backups()
+	if err != nil {
+		Error(map[string]string{"path": f.path, "error": err.Error()}, "Can't list rotated log files")
+		return
+	}
+	cutoff := time.Now().Add(-time.Duration(f.settings.MaxAge) * 24 * time.Hour)
+	for i, path := range backups {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if f.settings.MaxBackups > 0 && i >= f.settings.MaxBackups || f.settings.MaxAge > 0 && info.ModTime().Before(cutoff) {
+			os.Remove(path)
+			continue
+		}
+		if f.settings.Compress && !strings.HasSuffix(path, ".gz") {
+			if err := compress(path); err != nil {
+				Error(map[string]string{"path": path, "error": err.Error()}, "Can't compress rotated log file")
+			}
+		}
+	}
+}
+
+// replaces file with its gzip copy
+func compress(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
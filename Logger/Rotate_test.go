@@ -0,0 +1,110 @@
+package Logger
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRotationSettings_Validate(t *testing.T) {
+	for _, s := range []RotationSettings{{MaxSize: -1}, {MaxAge: -1}, {Interval: "weekly"}} {
+		if err := s.Validate(); err == nil {
+			t.Error("Validate() should fail for: ", s)
+		}
+	}
+}
+
+func TestRotateBySize(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rotate")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "proxy.log")
+
+	InitRotation(&RotationSettings{MaxSize: 1, MaxBackups: 1, Compress: true})
+	defer InitRotation(nil)
+	f, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if same, _ := OpenFile(path); same != f {
+		t.Error("Writers of the same path should share the file")
+	}
+
+	line := append(bytes.Repeat([]byte("a"), 600*1024), '\n')
+	for i := 0; i < 3; i++ {
+		if _, err := f.Write(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// rotated files are cleaned up in background
+	var names []string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		entries, _ := ioutil.ReadDir(dir)
+		names = names[:0]
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		if len(names) == 2 && strings.HasSuffix(names[0], ".log.gz") {
+			break
+		}
+	}
+	// backups are listed first as '-' sorts before '.'
+	if len(names) != 2 || names[1] != "proxy.log" || !strings.HasPrefix(names[0], "proxy-") || !strings.HasSuffix(names[0], ".log.gz") {
+		t.Fatal("Expected current file and one compressed backup, got: ", names)
+	}
+	if info, _ := os.Stat(path); info.Size() != int64(len(line)) {
+		t.Error("Current file should contain only the last write, size: ", info.Size())
+	}
+}
+
+func TestReopenAndConcurrentWrites(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rotate")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "proxy.log")
+
+	f, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(b byte) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				f.Write(append(bytes.Repeat([]byte{b}, 100), '\n'))
+			}
+		}(byte('a' + i))
+	}
+	wg.Wait()
+
+	// logrotate moves the file and sends SIGUSR1
+	moved := path + ".1"
+	os.Rename(path, moved)
+	if err := Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("after\n"))
+
+	data, _ := ioutil.ReadFile(moved)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 400 {
+		t.Error("Expected 400 lines, got: ", len(lines))
+	}
+	for _, line := range lines {
+		if len(line) != 100 || strings.Count(line, line[:1]) != 100 {
+			t.Fatal("Concurrent writes shouldn't be interleaved: " + line)
+		}
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != "after\n" {
+		t.Error("Entries after reopen should be written to the new file: ", string(data))
+	}
+}
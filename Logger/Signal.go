@@ -0,0 +1,43 @@
+//go:build !windows
+// +build !windows
+
+package Logger
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+var reopenOnce sync.Once
+
+// reopens log files on SIGUSR1, it's sent by logrotate after files are moved
+func reopenOnSignal() {
+	reopenOnce.Do(func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGUSR1)
+		go func() {
+			for range signals {
+				if err := Reopen(); err != nil {
+					Error(map[string]string{"error": err.Error()}, "Can't reopen log files")
+				}
+			}
+		}()
+	})
+}
+
+var toggleOnce sync.Once
+
+// switches all loggers without override to debug level and back on SIGUSR2
+func toggleDebugOnSignal() {
+	toggleOnce.Do(func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGUSR2)
+		go func() {
+			for range signals {
+				toggleDebug()
+			}
+		}()
+	})
+}
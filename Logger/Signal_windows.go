@@ -0,0 +1,7 @@
+package Logger
+
+// there is no SIGUSR1 on windows, log files are rotated only by the process itself
+func reopenOnSignal() {}
+
+// levels are changed only by admin endpoint on windows
+func toggleDebugOnSignal() {}
@@ -0,0 +1,370 @@
+package Logger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/sirupsen/logrus"
+)
+
+// Sink receives entries of all loggers as logrus hook and writes them to external system in background
+type Sink interface {
+	logrus.Hook
+	// Flush waits until buffered entries are written, but not longer than timeout.
+	// Returns number of entries that weren't written
+	Flush(timeout time.Duration) int
+}
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// SinkSettings are settings every entry of 'Sinks' list of 'Logging' section has, other keys depend on the type
+type SinkSettings struct {
+	// type sink was registered with by RegisterSink: syslog or otlp
+	Type string
+	// lowest level written by the sink, all entries written by loggers are sent by default
+	Level string
+	// text (default): fields are sent separately from the message, json: message is json of the whole entry
+	Format string
+}
+
+func (s *SinkSettings) Validate() error {
+	if s.Type == "" {
+		return errors.New("Log sink should contain 'type'")
+	}
+	if s.Level != "" {
+		if _, err := parseLevel(s.Level); err != nil {
+			return err
+		}
+	}
+	switch s.Format {
+	case "":
+		s.Format = FormatText
+	case FormatText, FormatJSON:
+	default:
+		return errors.New("Unsupported log sink format: " + s.Format + ". Supported: text, json")
+	}
+	return nil
+}
+
+// levels sink writes
+func (s *SinkSettings) levels() []logrus.Level {
+	if s.Level == "" {
+		return logrus.AllLevels
+	}
+	level, _ := parseLevel(s.Level)
+	return getLevelGroupFromLevel(uint32(level))
+}
+
+// formats whole entry as json, it's used by sinks with json format
+func (s *SinkSettings) json(entry *logrus.Entry) string {
+	data, err := (&logrus.JSONFormatter{}).Format(entry)
+	if err != nil {
+		return entry.Message
+	}
+	return strings.TrimSuffix(string(data), "\n")
+}
+
+// encodes field value that isn't string, e.g. number or slice
+func jsonValue(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+func parseLevel(level string) (logrus.Level, error) {
+	switch level {
+	case "Debug":
+		return LDebug, nil
+	case "Info":
+		return LInfo, nil
+	case "Warning":
+		return LWarning, nil
+	case "Error":
+		return LError, nil
+	}
+	return 0, errors.New("Invalid log level: " + level + ". Supported: Debug, Info, Warning, Error")
+}
+
+// SinkFactory creates sink from its entry of 'Sinks' list
+type SinkFactory func(settings map[string]interface{}) (Sink, error)
+
+var (
+	factories = map[string]SinkFactory{
+		"syslog": newSyslogFromFile,
+		"otlp":   newOtlpFromFile,
+	}
+
+	configuredMu sync.Mutex
+	// sinks created by InitSinks, they are added to every logger
+	configured []Sink
+	// every sink created, including ElasticSearch ones, so Flush waits for all of them
+	all []Sink
+)
+
+// RegisterSink makes sink type available in 'Sinks' list
+func RegisterSink(kind string, factory SinkFactory) {
+	configuredMu.Lock()
+	defer configuredMu.Unlock()
+	factories[kind] = factory
+}
+
+// decodes sink settings of the type, type-specific settings embed SinkSettings with squash tag
+func decodeSink(settings map[string]interface{}, out interface{}) error {
+	if err := mapstructure.Decode(settings, out); err != nil {
+		return errors.New("Can't decode log sink settings. Error: " + err.Error())
+	}
+	return nil
+}
+
+// InitSinks creates sinks of 'Sinks' list and adds them to standard logger and loggers created by New after it
+func InitSinks(list []interface{}) {
+	configuredMu.Lock()
+	configured = nil
+	configuredMu.Unlock()
+
+	for _, v := range list {
+		raw, ok := v.(map[string]interface{})
+		if !ok {
+			panic("Log sink should be an object")
+		}
+		var common SinkSettings
+		if err := mapstructure.Decode(raw, &common); err != nil {
+			panic("Can't decode log sink settings. Error: " + err.Error())
+		}
+
+		configuredMu.Lock()
+		factory, exist := factories[common.Type]
+		configuredMu.Unlock()
+		if !exist {
+			panic("Unsupported log sink type: " + common.Type)
+		}
+		sink, err := factory(raw)
+		if err != nil {
+			panic("Can't create " + common.Type + " log sink. Error: " + err.Error())
+		}
+
+		configuredMu.Lock()
+		configured = append(configured, sink)
+		configuredMu.Unlock()
+		logrus.StandardLogger().AddHook(sink)
+	}
+}
+
+// adds sinks created by InitSinks to the logger
+func configureSinks(l *logrus.Logger) {
+	configuredMu.Lock()
+	defer configuredMu.Unlock()
+	for _, s := range configured {
+		l.AddHook(s)
+	}
+}
+
+// remembers sink, so it's flushed on shutdown
+func track(s Sink) {
+	configuredMu.Lock()
+	defer configuredMu.Unlock()
+	all = append(all, s)
+}
+
+// Flush waits until entries of all sinks are written, but not longer than timeout.
+// Returns number of entries that weren't written in time
+func Flush(timeout time.Duration) int {
+	deadline := time.Now().Add(timeout)
+	configuredMu.Lock()
+	sinks := append([]Sink{}, all...)
+	configuredMu.Unlock()
+
+	n := 0
+	for _, s := range sinks {
+		n += s.Flush(time.Until(deadline))
+	}
+	return n
+}
+
+const (
+	DropOldest = "oldest"
+	DropNewest = "newest"
+)
+
+// BatchSettings describe buffering of sinks that send entries in batches
+type BatchSettings struct {
+	// entries sent at once
+	BatchSize int
+	// seconds entries wait for the batch to fill, 1 by default
+	FlushInterval int
+	// entries kept while destination is unavailable, 10000 by default
+	BufferSize int
+	// entry dropped when buffer is full: oldest (default) or newest
+	DropPolicy string
+}
+
+func (s *BatchSettings) Validate(batchSize int) error {
+	if s.BatchSize < 0 || s.FlushInterval < 0 || s.BufferSize < 0 {
+		return errors.New("BatchSize, FlushInterval and BufferSize can't be negative")
+	}
+	if s.BatchSize == 0 {
+		s.BatchSize = batchSize
+	}
+	if s.FlushInterval == 0 {
+		s.FlushInterval = 1
+	}
+	if s.BufferSize == 0 {
+		s.BufferSize = 10000
+	}
+	if s.BufferSize < s.BatchSize {
+		return errors.New("BufferSize can't be less than BatchSize")
+	}
+	switch s.DropPolicy {
+	case "":
+		s.DropPolicy = DropOldest
+	case DropOldest, DropNewest:
+	default:
+		return errors.New("Unsupported drop policy: " + s.DropPolicy + ". Supported: oldest, newest")
+	}
+	return nil
+}
+
+// batcher keeps encoded entries in bounded buffer and sends them in background when batch is full
+// or flush interval passes. Batch that failed is returned to the buffer and sent again on the next tick,
+// so unavailable destination isn't retried in a loop
+type batcher struct {
+	settings BatchSettings
+	// sends batch, returns entries that should be sent again and error if batch failed
+	send func(batch [][]byte) ([][]byte, error)
+
+	mu      sync.Mutex
+	queue   [][]byte
+	sending int
+	wake    chan struct{}
+	// number of Flush calls in progress, partial batches are sent without waiting for tick while it isn't 0
+	flushing int32
+
+	dropped uint64
+}
+
+func newBatcher(settings BatchSettings, send func(batch [][]byte) ([][]byte, error)) *batcher {
+	b := &batcher{settings: settings, send: send, wake: make(chan struct{}, 1)}
+	go b.run()
+	return b
+}
+
+// add puts entry into the buffer, entry is dropped as set by DropPolicy if buffer is full
+func (b *batcher) add(item []byte) {
+	b.mu.Lock()
+	full := b.push(item)
+	b.mu.Unlock()
+	if full {
+		b.signal()
+	}
+}
+
+// adds items to the end of the queue, reports whether batch is ready. Caller holds mu
+func (b *batcher) push(items ...[]byte) bool {
+	for _, item := range items {
+		if len(b.queue) >= b.settings.BufferSize {
+			atomic.AddUint64(&b.dropped, 1)
+			if b.settings.DropPolicy == DropNewest {
+				continue
+			}
+			b.queue = b.queue[1:]
+		}
+		b.queue = append(b.queue, item)
+	}
+	return len(b.queue) >= b.settings.BatchSize
+}
+
+func (b *batcher) signal() {
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// drop counts entries that destination rejected
+func (b *batcher) drop(n int) {
+	atomic.AddUint64(&b.dropped, uint64(n))
+}
+
+// Dropped returns number of entries that were dropped because buffer was full or destination rejected them
+func (b *batcher) Dropped() uint64 {
+	return atomic.LoadUint64(&b.dropped)
+}
+
+// entries waiting in the buffer or being sent
+func (b *batcher) buffered() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.queue) + b.sending
+}
+
+func (b *batcher) run() {
+	ticker := time.NewTicker(time.Duration(b.settings.FlushInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		var tick bool
+		select {
+		case <-b.wake:
+		case <-ticker.C:
+			tick = true
+		}
+		tick = tick || atomic.LoadInt32(&b.flushing) != 0
+
+		for {
+			b.mu.Lock()
+			n := len(b.queue)
+			if n == 0 || n < b.settings.BatchSize && !tick {
+				b.mu.Unlock()
+				break
+			}
+			if n > b.settings.BatchSize {
+				n = b.settings.BatchSize
+			}
+			batch := append([][]byte{}, b.queue[:n]...)
+			b.queue = b.queue[n:]
+			b.sending += len(batch)
+			b.mu.Unlock()
+
+			retry, err := b.send(batch)
+
+			b.mu.Lock()
+			b.sending -= len(batch)
+			if err != nil {
+				// failed entries go back before newer ones
+				rest := b.queue
+				b.queue = nil
+				b.push(retry...)
+				b.push(rest...)
+			}
+			b.mu.Unlock()
+			if err != nil {
+				break
+			}
+		}
+	}
+}
+
+// Flush sends buffered entries, but waits not longer than timeout. Returns number of entries that weren't sent
+func (b *batcher) Flush(timeout time.Duration) int {
+	atomic.AddInt32(&b.flushing, 1)
+	defer atomic.AddInt32(&b.flushing, -1)
+	deadline := time.Now().Add(timeout)
+	for {
+		n := b.buffered()
+		if n == 0 || time.Now().After(deadline) {
+			return n
+		}
+		b.signal()
+		time.Sleep(10 * time.Millisecond)
+	}
+}
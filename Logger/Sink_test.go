@@ -0,0 +1,209 @@
+package Logger
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newHookLogger(hook logrus.Hook) *logrus.Logger {
+	log := logrus.New()
+	log.Out = nopWriter{}
+	log.SetLevel(logrus.DebugLevel)
+	log.AddHook(hook)
+	return log
+}
+
+func TestSinkSettings_Validate(t *testing.T) {
+	invalid := map[string]map[string]interface{}{
+		"syslog without address": {"type": "syslog", "network": "tcp"},
+		"syslog network":         {"type": "syslog", "network": "sctp", "address": "localhost:514"},
+		"syslog facility":        {"type": "syslog", "address": "localhost:514", "facility": "local9"},
+		"level":                  {"type": "syslog", "address": "localhost:514", "level": "Trace"},
+		"format":                 {"type": "otlp", "url": "http://localhost:4318", "format": "xml"},
+		"otlp url":               {"type": "otlp", "url": "localhost:4318"},
+	}
+	for name, settings := range invalid {
+		factory := factories[settings["type"].(string)]
+		if _, err := factory(settings); err == nil {
+			t.Error("Sink should be invalid: " + name)
+		}
+	}
+}
+
+func TestSyslogSink(t *testing.T) {
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+
+	sink, err := newSyslogFromFile(map[string]interface{}{"type": "syslog", "address": udp.LocalAddr().String(),
+		"facility": "local3", "level": "Warning", "appName": "edge"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := newHookLogger(sink)
+	log.WithFields(logrus.Fields{"logger": "Endpoint", "upstream": `a"b]`, "attempt": 2}).Error("Upstream failed")
+	log.Info("Below level of the sink")
+	sink.Flush(5 * time.Second)
+
+	buf := make([]byte, 2048)
+	udp.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := udp.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// local3 * 8 + error
+	expected := regexp.MustCompile(`^<155>1 \S+ \S+ edge ` + strconv.Itoa(os.Getpid()) +
+		` Endpoint \[fields@32473 attempt="2" logger="Endpoint" upstream="a\\"b\\]"\] Upstream failed$`)
+	if !expected.Match(buf[:n]) {
+		t.Error("Unexpected message: " + string(buf[:n]))
+	}
+	udp.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, _, err := udp.ReadFrom(buf); err == nil {
+		t.Error("Entry below level of the sink shouldn't be sent")
+	}
+}
+
+func TestSyslogSinkStream(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	messages := make(chan string, 2)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			size, err := r.ReadString(' ')
+			if err != nil {
+				return
+			}
+			n, _ := strconv.Atoi(strings.TrimSpace(size))
+			msg := make([]byte, n)
+			if _, err := io.ReadFull(r, msg); err != nil {
+				return
+			}
+			messages <- string(msg)
+		}
+	}()
+
+	sink, err := NewSyslogSink(SyslogSettings{SinkSettings: SinkSettings{Type: "syslog", Level: "Info", Format: FormatJSON},
+		Network: "tcp", Address: ln.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := newHookLogger(sink)
+	log.WithField("logger", "main").Info("first")
+	log.Info("second")
+	sink.Flush(5 * time.Second)
+
+	for _, expected := range []string{"first", "second"} {
+		select {
+		case msg := <-messages:
+			// json format puts whole entry into the message and has no structured data
+			i := strings.Index(msg, " - {")
+			var entry map[string]interface{}
+			if i == -1 || json.Unmarshal([]byte(msg[i+3:]), &entry) != nil || entry["msg"] != expected {
+				t.Error("Unexpected message: " + msg)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Message wasn't received: " + expected)
+		}
+	}
+}
+
+func TestSyslogSinkUnixgram(t *testing.T) {
+	s := SyslogSettings{SinkSettings: SinkSettings{Type: "syslog"}, Network: "unix"}
+	if err := s.Validate(); err != nil || s.Network != "unixgram" || s.Address != "/dev/log" {
+		t.Error("Datagram /dev/log socket should be used by default: ", err, s.Network)
+	}
+
+	dir, _ := ioutil.TempDir("", "syslog")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "log")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sink, err := NewSyslogSink(SyslogSettings{SinkSettings: SinkSettings{Type: "syslog", Level: "Info"},
+		Network: "unixgram", Address: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newHookLogger(sink).Info("journald")
+	sink.Flush(5 * time.Second)
+
+	buf := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// datagrams aren't framed with octet counting
+	if msg := string(buf[:n]); !strings.HasPrefix(msg, "<134>1 ") || !strings.HasSuffix(msg, " journald") {
+		t.Error("Unexpected message: " + msg)
+	}
+}
+
+func TestOtlpSink(t *testing.T) {
+	requests := make(chan map[string]interface{}, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/logs" || r.Header.Get("Authorization") != "Bearer token" ||
+			r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		requests <- body
+	}))
+	defer collector.Close()
+
+	sink, err := newOtlpFromFile(map[string]interface{}{"type": "otlp", "url": collector.URL,
+		"headers": map[string]interface{}{"Authorization": "Bearer token"}, "serviceName": "edge", "level": "Debug"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := newHookLogger(sink)
+	log.WithFields(logrus.Fields{"logger": "Endpoint", "attempt": 2}).Warn("Upstream failed")
+	if n := sink.Flush(5 * time.Second); n != 0 {
+		t.Fatal("Entries weren't exported: ", n)
+	}
+
+	var body map[string]interface{}
+	select {
+	case body = <-requests:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Collector didn't receive request")
+	}
+	encoded, _ := json.Marshal(body)
+	for _, part := range []string{`"key":"service.name","value":{"stringValue":"edge"}`, `"severityNumber":13`,
+		`"severityText":"WARN"`, `"body":{"stringValue":"Upstream failed"}`, `"key":"attempt","value":{"intValue":"2"}`,
+		`"key":"logger","value":{"stringValue":"Endpoint"}`} {
+		if !strings.Contains(string(encoded), part) {
+			t.Error("Export request should contain " + part + ": " + string(encoded))
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package Logger
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var facilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// SyslogSettings describe sink sending RFC 5424 messages to syslog server or local socket.
+// journald reads the same messages from /dev/log
+type SyslogSettings struct {
+	SinkSettings `mapstructure:",squash"`
+	// udp (default), tcp, unix or unixgram. Messages sent over stream connections are framed with octet counting
+	Network string
+	// host:port of the server or path of the socket. /dev/log is used for unix sockets by default,
+	// it's datagram socket, so messages are sent to it over unixgram
+	Address string
+	// local0 by default
+	Facility string
+	// APP-NAME of messages, proxy by default
+	AppName string
+	// messages are sent one by one, BatchSize only limits how many are written before buffer is checked again
+	BatchSettings `mapstructure:",squash"`
+}
+
+func (s *SyslogSettings) Validate() error {
+	if err := s.SinkSettings.Validate(); err != nil {
+		return err
+	}
+	switch s.Network {
+	case "":
+		s.Network = "udp"
+	case "udp", "tcp", "unix", "unixgram":
+	default:
+		return errors.New("Unsupported syslog network: " + s.Network + ". Supported: udp, tcp, unix, unixgram")
+	}
+	if s.Address == "" {
+		if !strings.HasPrefix(s.Network, "unix") {
+			return errors.New("Syslog sink should contain 'address' of the server")
+		}
+		s.Address, s.Network = "/dev/log", "unixgram"
+	}
+	if s.Facility == "" {
+		s.Facility = "local0"
+	}
+	if _, exist := facilities[s.Facility]; !exist {
+		return errors.New("Unsupported syslog facility: " + s.Facility)
+	}
+	if s.AppName == "" {
+		s.AppName = "proxy"
+	}
+	if err := s.BatchSettings.Validate(100); err != nil {
+		return errors.New("Syslog settings: " + err.Error())
+	}
+	return nil
+}
+
+// SyslogSink writes entries as RFC 5424 messages
+type SyslogSink struct {
+	*batcher
+	settings SyslogSettings
+	levels   []logrus.Level
+	host     string
+	pid      string
+
+	// connection is used only by send, which is called by one goroutine
+	conn net.Conn
+}
+
+// NewSyslogSink creates sink, connection is made when the first message is sent
+func NewSyslogSink(settings SyslogSettings) (*SyslogSink, error) {
+	if err := settings.Validate(); err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	s := &SyslogSink{settings: settings, levels: settings.levels(), host: host, pid: strconv.Itoa(os.Getpid())}
+	s.batcher = newBatcher(settings.BatchSettings, s.send)
+	track(s)
+	return s, nil
+}
+
+func newSyslogFromFile(settings map[string]interface{}) (Sink, error) {
+	var s SyslogSettings
+	if err := decodeSink(settings, &s); err != nil {
+		return nil, err
+	}
+	return NewSyslogSink(s)
+}
+
+func (s *SyslogSink) Levels() []logrus.Level {
+	return s.levels
+}
+
+func (s *SyslogSink) Fire(entry *logrus.Entry) error {
+	s.add(s.format(entry))
+	return nil
+}
+
+func severity(level logrus.Level) int {
+	switch level {
+	case logrus.PanicLevel:
+		return 0
+	case logrus.FatalLevel:
+		return 2
+	case logrus.ErrorLevel:
+		return 3
+	case logrus.WarnLevel:
+		return 4
+	case logrus.InfoLevel:
+		return 6
+	}
+	return 7
+}
+
+// format makes message: <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG.
+// Logger name is MSGID, fields of text format are put into structured data
+func (s *SyslogSink) format(entry *logrus.Entry) []byte {
+	var b strings.Builder
+	b.WriteString("<" + strconv.Itoa(facilities[s.settings.Facility]*8+severity(entry.Level)) + ">1 ")
+	b.WriteString(entry.Time.Format("2006-01-02T15:04:05.000000Z07:00") + " ")
+	b.WriteString(header(s.host, 255) + " " + header(s.settings.AppName, 48) + " " + s.pid + " ")
+	name, _ := entry.Data["logger"].(string)
+	b.WriteString(header(name, 32) + " ")
+
+	if s.settings.Format == FormatJSON || len(entry.Data) == 0 {
+		b.WriteString("-")
+	} else {
+		// 32473 is enterprise number reserved for documentation, it's used as there is no registered one
+		b.WriteString("[fields@32473")
+		keys := make([]string, 0, len(entry.Data))
+		for k := range entry.Data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			b.WriteString(" " + paramName(k) + "=\"" + paramValue(entry.Data[k]) + "\"")
+		}
+		b.WriteString("]")
+	}
+
+	b.WriteString(" ")
+	if s.settings.Format == FormatJSON {
+		b.WriteString(s.settings.json(entry))
+	} else {
+		b.WriteString(entry.Message)
+	}
+	return []byte(b.String())
+}
+
+// header field is printable ASCII without spaces, "-" if it's empty
+func header(v string, max int) string {
+	v = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return -1
+		}
+		return r
+	}, v)
+	if len(v) > max {
+		v = v[:max]
+	}
+	if v == "" {
+		return "-"
+	}
+	return v
+}
+
+// SD-NAME can't contain '=', ']', '"' and spaces
+func paramName(k string) string {
+	k = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' || r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, k)
+	if len(k) > 32 {
+		k = k[:32]
+	}
+	return k
+}
+
+func paramValue(v interface{}) string {
+	var s string
+	switch value := v.(type) {
+	case string:
+		s = value
+	case error:
+		s = value.Error()
+	default:
+		s = strings.Trim(jsonValue(value), "\"")
+	}
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(s)
+}
+
+// sends messages one by one, connection is made again once if it's broken
+func (s *SyslogSink) send(batch [][]byte) ([][]byte, error) {
+	for i, msg := range batch {
+		if err := s.write(msg); err != nil {
+			if s.conn != nil {
+				s.conn.Close()
+				s.conn = nil
+			}
+			if err = s.write(msg); err != nil {
+				return batch[i:], err
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (s *SyslogSink) write(msg []byte) error {
+	if s.conn == nil {
+		conn, err := net.DialTimeout(s.settings.Network, s.settings.Address, 5*time.Second)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if s.settings.Network == "tcp" || s.settings.Network == "unix" {
+		// RFC 6587 octet counting
+		msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	}
+	_, err := s.conn.Write(msg)
+	return err
+}
@@ -0,0 +1,319 @@
+package Metrics
+
+import (
+	"bufio"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	counterType   = "counter"
+	gaugeType     = "gauge"
+	histogramType = "histogram"
+)
+
+// DefaultBuckets are upper bounds in seconds of latency histograms
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Sample is value of metric collected by func when metrics are scraped
+type Sample struct {
+	// values of labels in the order they were declared
+	Labels []string
+	Value  float64
+}
+
+// family is metric with its series by label values
+type family struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+	// values of func metrics are collected on scrape instead of series
+	collect func() []Sample
+}
+
+// series is one combination of label values
+type series struct {
+	labels []string
+	// float64 bits of counter and gauge value
+	value uint64
+
+	// histogram counts by bucket, the last one is +Inf
+	mu     sync.Mutex
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+var (
+	mu       sync.Mutex
+	families = map[string]*family{}
+)
+
+// register adds family, family with the same name is replaced, so func metrics can be registered again
+func register(f *family) *family {
+	mu.Lock()
+	defer mu.Unlock()
+	f.series = map[string]*series{}
+	families[f.name] = f
+	return f
+}
+
+func (f *family) with(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic("Metric " + f.name + " requires " + strconv.Itoa(len(f.labels)) + " label values")
+	}
+	key := strings.Join(values, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, exist := f.series[key]
+	if !exist {
+		s = &series{labels: append([]string{}, values...)}
+		if f.kind == histogramType {
+			s.counts = make([]uint64, len(f.buckets)+1)
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (s *series) add(v float64) {
+	for {
+		old := atomic.LoadUint64(&s.value)
+		next := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&s.value, old, next) {
+			return
+		}
+	}
+}
+
+func (s *series) get() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&s.value))
+}
+
+// Counter is metric that only grows, e.g. number of requests
+type Counter struct{ f *family }
+
+// CounterValue is counter of one combination of label values
+type CounterValue struct{ s *series }
+
+// NewCounter registers counter with label names
+func NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{register(&family{name: name, help: help, kind: counterType, labels: labels})}
+}
+
+// With returns counter of label values, they are given in the order labels were declared
+func (c *Counter) With(values ...string) CounterValue {
+	return CounterValue{c.f.with(values)}
+}
+
+func (c CounterValue) Inc() {
+	c.s.add(1)
+}
+
+// Add increases counter, negative values are ignored
+func (c CounterValue) Add(v float64) {
+	if v > 0 {
+		c.s.add(v)
+	}
+}
+
+// Gauge is metric that goes up and down, e.g. number of requests in progress
+type Gauge struct{ f *family }
+
+// GaugeValue is gauge of one combination of label values
+type GaugeValue struct{ s *series }
+
+// NewGauge registers gauge with label names
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{register(&family{name: name, help: help, kind: gaugeType, labels: labels})}
+}
+
+// With returns gauge of label values, they are given in the order labels were declared
+func (g *Gauge) With(values ...string) GaugeValue {
+	return GaugeValue{g.f.with(values)}
+}
+
+func (g GaugeValue) Inc() {
+	g.s.add(1)
+}
+
+func (g GaugeValue) Dec() {
+	g.s.add(-1)
+}
+
+func (g GaugeValue) Set(v float64) {
+	atomic.StoreUint64(&g.s.value, math.Float64bits(v))
+}
+
+// Histogram counts observations, e.g. latencies, in buckets
+type Histogram struct{ f *family }
+
+// HistogramValue is histogram of one combination of label values
+type HistogramValue struct {
+	s       *series
+	buckets []float64
+}
+
+// NewHistogram registers histogram with bucket upper bounds in ascending order and label names
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if !sort.Float64sAreSorted(buckets) {
+		panic("Buckets of histogram " + name + " should be sorted")
+	}
+	return &Histogram{register(&family{name: name, help: help, kind: histogramType, labels: labels, buckets: buckets})}
+}
+
+// With returns histogram of label values, they are given in the order labels were declared
+func (h *Histogram) With(values ...string) HistogramValue {
+	return HistogramValue{h.f.with(values), h.f.buckets}
+}
+
+// Observe adds value to the histogram. Buckets of series aren't cumulative, they are summed when metrics are written
+func (h HistogramValue) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.s.mu.Lock()
+	h.s.counts[i]++
+	h.s.sum += v
+	h.s.count++
+	h.s.mu.Unlock()
+}
+
+// NewCounterFunc registers counter whose values are collected by f when metrics are scraped
+func NewCounterFunc(name, help string, labels []string, f func() []Sample) {
+	register(&family{name: name, help: help, kind: counterType, labels: labels, collect: f})
+}
+
+// NewGaugeFunc registers gauge whose values are collected by f when metrics are scraped
+func NewGaugeFunc(name, help string, labels []string, f func() []Sample) {
+	register(&family{name: name, help: help, kind: gaugeType, labels: labels, collect: f})
+}
+
+// StatusClass returns class of status code used as label: 2xx, 4xx
+func StatusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "unknown"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writes {name="value",...}, extra pair is added at the end if it's set
+func writeLabels(w *bufio.Writer, names, values []string, extra ...string) {
+	if len(names) == 0 && len(extra) == 0 {
+		return
+	}
+	w.WriteByte('{')
+	for i, name := range names {
+		if i != 0 {
+			w.WriteByte(',')
+		}
+		w.WriteString(name + `="` + labelEscaper.Replace(values[i]) + `"`)
+	}
+	if len(extra) == 2 {
+		if len(names) != 0 {
+			w.WriteByte(',')
+		}
+		w.WriteString(extra[0] + `="` + extra[1] + `"`)
+	}
+	w.WriteByte('}')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// series of the family sorted by label values
+func (f *family) snapshot() []*series {
+	if f.collect != nil {
+		var rv []*series
+		for _, sample := range f.collect() {
+			if len(sample.Labels) != len(f.labels) {
+				continue
+			}
+			rv = append(rv, &series{labels: sample.Labels, value: math.Float64bits(sample.Value)})
+		}
+		return rv
+	}
+
+	f.mu.Lock()
+	rv := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		rv = append(rv, s)
+	}
+	f.mu.Unlock()
+	sort.Slice(rv, func(i, j int) bool {
+		return strings.Join(rv[i].labels, "\xff") < strings.Join(rv[j].labels, "\xff")
+	})
+	return rv
+}
+
+func (f *family) write(w *bufio.Writer) {
+	w.WriteString("# HELP " + f.name + " " + strings.ReplaceAll(f.help, "\n", " ") + "\n")
+	w.WriteString("# TYPE " + f.name + " " + f.kind + "\n")
+	for _, s := range f.snapshot() {
+		if f.kind != histogramType {
+			w.WriteString(f.name)
+			writeLabels(w, f.labels, s.labels)
+			w.WriteString(" " + formatFloat(s.get()) + "\n")
+			continue
+		}
+
+		s.mu.Lock()
+		counts := append([]uint64{}, s.counts...)
+		sum, count := s.sum, s.count
+		s.mu.Unlock()
+		var cumulative uint64
+		for i, c := range counts {
+			cumulative += c
+			le := math.Inf(1)
+			if i < len(f.buckets) {
+				le = f.buckets[i]
+			}
+			w.WriteString(f.name + "_bucket")
+			writeLabels(w, f.labels, s.labels, "le", formatFloat(le))
+			w.WriteString(" " + strconv.FormatUint(cumulative, 10) + "\n")
+		}
+		w.WriteString(f.name + "_sum")
+		writeLabels(w, f.labels, s.labels)
+		w.WriteString(" " + formatFloat(sum) + "\n")
+		w.WriteString(f.name + "_count")
+		writeLabels(w, f.labels, s.labels)
+		w.WriteString(" " + strconv.FormatUint(count, 10) + "\n")
+	}
+}
+
+// Handler writes all metrics in Prometheus text format
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		all := make([]*family, 0, len(families))
+		for _, f := range families {
+			all = append(all, f)
+		}
+		mu.Unlock()
+		sort.Slice(all, func(i, j int) bool { return all[i].name < all[j].name })
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		for _, f := range all {
+			f.write(bw)
+		}
+		bw.Flush()
+	})
+}
@@ -0,0 +1,71 @@
+package Metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape() string {
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	return w.Body.String()
+}
+
+func TestCounterAndGauge(t *testing.T) {
+	c := NewCounter("test_requests_total", "Requests.", "route", "status_class")
+	c.With("/api", StatusClass(201)).Inc()
+	c.With("/api", StatusClass(201)).Add(2)
+	c.With("/api", StatusClass(201)).Add(-1)
+	c.With(`/a"b`, StatusClass(42)).Inc()
+
+	g := NewGauge("test_in_flight", "In flight.")
+	g.With().Inc()
+	g.With().Inc()
+	g.With().Dec()
+
+	out := scrape()
+	for _, line := range []string{
+		"# HELP test_requests_total Requests.",
+		"# TYPE test_requests_total counter",
+		`test_requests_total{route="/api",status_class="2xx"} 3`,
+		`test_requests_total{route="/a\"b",status_class="unknown"} 1`,
+		"# TYPE test_in_flight gauge",
+		"test_in_flight 1",
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Error("Metrics should contain: " + line + "\n" + out)
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram("test_duration_seconds", "Duration.", []float64{0.1, 1}, "route")
+	h.With("/api").Observe(0.05)
+	h.With("/api").Observe(0.1)
+	h.With("/api").Observe(5)
+
+	out := scrape()
+	for _, line := range []string{
+		"# TYPE test_duration_seconds histogram",
+		`test_duration_seconds_bucket{route="/api",le="0.1"} 2`,
+		`test_duration_seconds_bucket{route="/api",le="1"} 2`,
+		`test_duration_seconds_bucket{route="/api",le="+Inf"} 3`,
+		`test_duration_seconds_sum{route="/api"} 5.15`,
+		`test_duration_seconds_count{route="/api"} 3`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Error("Metrics should contain: " + line + "\n" + out)
+		}
+	}
+}
+
+func TestFuncMetrics(t *testing.T) {
+	NewGaugeFunc("test_buffered", "Buffered.", []string{"sink"}, func() []Sample {
+		return []Sample{{Labels: []string{"otlp"}, Value: 7}, {Labels: []string{"invalid", "labels"}, Value: 1}}
+	})
+	out := scrape()
+	if !strings.Contains(out, `test_buffered{sink="otlp"} 7`+"\n") || strings.Contains(out, "invalid") {
+		t.Error("Values of func metric should be collected on scrape: " + out)
+	}
+}
@@ -0,0 +1,64 @@
+package Protocol
+
+import (
+	"crypto/tls"
+	"os"
+	"proxy/DevCert"
+	"proxy/Logger"
+	"strconv"
+	"sync"
+)
+
+// in development mode listeners whose certificate files are missing get in-memory certificate for devHosts
+var (
+	devMode  bool
+	devHosts []string
+
+	devCAOnce sync.Once
+	devCA     *DevCert.CA
+	devCAErr  error
+)
+
+func initDevMode(file map[string]interface{}) {
+	devMode, devHosts = false, nil
+	if v, exist := file["DevMode"]; exist {
+		enabled, ok := v.(bool)
+		if !ok {
+			panic("Can't cast 'DevMode' field to bool")
+		}
+		devMode, devHosts = enabled, DevCert.Hosts(file)
+	}
+}
+
+func missing(path string) bool {
+	_, err := os.Stat(path)
+	return os.IsNotExist(err)
+}
+
+// development CA created by 'gencert' command is used if it exists, so its clients keep trusting the proxy.
+// Otherwise temporary CA lives until the process exits
+func developmentCA() (*DevCert.CA, error) {
+	devCAOnce.Do(func() {
+		if !missing(DevCert.CACertPath) {
+			devCA, devCAErr = DevCert.LoadCA(DevCert.CACertPath, DevCert.CAKeyPath)
+		} else {
+			devCA, devCAErr = DevCert.NewCA()
+		}
+	})
+	return devCA, devCAErr
+}
+
+// devCertificate issues in-memory certificate if development mode is on and certificate files of the listener are missing
+func (p *Protocol) devCertificate() (*tls.Certificate, bool, error) {
+	if !devMode || !missing(p.CertPath) && !missing(p.KeyPath) {
+		return nil, false, nil
+	}
+	ca, err := developmentCA()
+	if err != nil {
+		return nil, true, err
+	}
+	Logger.Warning(map[string]string{"port": strconv.Itoa(p.Port), "cert_path": p.CertPath},
+		"Certificate files are missing, in-memory development certificate is used. Run 'gencert' command to write them")
+	cert, err := ca.Certificate(devHosts)
+	return cert, true, err
+}
@@ -0,0 +1,44 @@
+package Protocol
+
+import (
+	"bytes"
+	"log"
+	"proxy/Logger"
+	"proxy/Metrics"
+	"strconv"
+	"strings"
+)
+
+var handshakeErrors = Metrics.NewCounter("proxy_tls_handshake_errors_total",
+	"TLS handshakes with clients that failed.", "listener")
+
+// Listener names listener of the protocol in metrics: type and port, e.g. https:443
+func (p *Protocol) Listener() string {
+	return p.Type + ":" + strconv.Itoa(p.Port)
+}
+
+// HandshakeFailed counts failed TLS handshake of the listener
+func (p *Protocol) HandshakeFailed() {
+	handshakeErrors.With(p.Listener()).Inc()
+}
+
+// errorLog receives errors of http server, TLS handshake errors are counted
+type errorLog struct {
+	p *Protocol
+}
+
+func (w errorLog) Write(line []byte) (int, error) {
+	message := string(bytes.TrimSpace(line))
+	if strings.Contains(message, "TLS handshake error") {
+		w.p.HandshakeFailed()
+		Logger.Debug("Protocol", map[string]string{"listener": w.p.Listener()}, message)
+	} else {
+		Logger.Warning(map[string]string{"listener": w.p.Listener()}, message)
+	}
+	return len(line), nil
+}
+
+// ErrorLog returns logger for http.Server of the protocol, http server reports failed handshakes only there
+func (p *Protocol) ErrorLog() *log.Logger {
+	return log.New(errorLog{p}, "", 0)
+}
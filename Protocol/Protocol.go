@@ -1,6 +1,19 @@
 package Protocol
 
-import "github.com/mitchellh/mapstructure"
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/mitchellh/mapstructure"
+	"io/ioutil"
+	"net"
+	"proxy/Acme"
+	"proxy/Forwarding"
+	"proxy/Handoff"
+	"proxy/ProxyProtocol"
+	"strconv"
+	"time"
+)
 
 var Protocols []Protocol = []Protocol{}
 
@@ -9,17 +22,268 @@ type Protocol struct {
 	Port int
 	CertPath string
 	KeyPath string
+	// certificate of https listener is obtained from ACME CA configured in 'Acme' section,
+	// CertPath and KeyPath are optional then and used when certificate can't be issued
+	Acme bool
+	// PEM bundle of CAs client certificates are verified against
+	ClientCA string
+	// client certificate authentication: request, require, verify-if-given or empty to not ask for certificate
+	ClientAuth string
+	// TLS version, cipher and session policy, intermediate preset is used if not specified
+	Tls *TLSSettings
+	// PROXY protocol header on accepted connections: 'accept', 'require' or empty to disable
+	ProxyProtocol string
+	// addresses or CIDRs allowed to send PROXY protocol header, required if 'proxyProtocol' is set.
+	// Header from any other source would let it spoof client address
+	ProxyTrusted []string
+	// seconds given to the client to send PROXY protocol header
+	ProxyTimeout int
+
+	// upstream addresses (host:port) of tcp and udp listeners
+	Upstreams []string
+	// load balancing between upstreams: round_robin (default), least_conn or ip_hash
+	Balance string
+	// seconds without traffic in both directions after which tcp connection or udp session is closed
+	IdleTimeout int
+	// maximum number of simultaneous tcp connections or udp sessions, 0 means no limit
+	MaxConnections int
+	// upstreams of tcp listener chosen by TLS server name, '*.example.com' matches any subdomain.
+	// TLS is passed through to upstream as is unless CertPath and KeyPath are specified
+	Sni map[string][]string
+
+	trusted []*net.IPNet
 }
 
 func (p *Protocol) Validate() {
-	if p.Type != "http" && p.Type != "https" {
+	if p.Type != "http" && p.Type != "https" && p.Type != "tcp" && p.Type != "udp" {
 		// log error
 		panic("Unsupported type protocol type is used: " + p.Type)
 	}
 
-	if p.Type == "https" && (p.CertPath == "" || p.KeyPath == "") {
+	if p.Acme {
+		if p.Type != "https" {
+			panic("acme can be used only by https protocol")
+		}
+		if !Acme.Enabled() {
+			panic("https protocol on port " + strconv.Itoa(p.Port) + " uses acme, but 'Acme' section is missing")
+		}
+	}
+	if p.Type == "https" && !p.Acme && (p.CertPath == "" || p.KeyPath == "") {
+		panic("https protocol should contain both CertPath and KeyPath variables")
+	}
+	if p.Type == "https" && (p.CertPath == "") != (p.KeyPath == "") {
 		panic("https protocol should contain both CertPath and KeyPath variables")
 	}
+
+	if p.ClientAuth != "" {
+		if _, ok := clientAuthTypes[p.ClientAuth]; !ok {
+			panic("Unsupported clientAuth: " + p.ClientAuth + ". Supported: request, require, verify-if-given")
+		}
+		if !p.usesTLS() {
+			panic("clientAuth requires TLS listener with CertPath and KeyPath")
+		}
+		if p.ClientAuth != "request" && p.ClientCA == "" {
+			panic("clientAuth '" + p.ClientAuth + "' requires ClientCA to verify certificates")
+		}
+	}
+
+	if p.usesTLS() {
+		if err := p.tlsSettings().Validate(); err != nil {
+			panic(err.Error() + " on port " + strconv.Itoa(p.Port))
+		}
+	} else if p.Tls != nil {
+		panic("tls settings require TLS listener with CertPath and KeyPath on port " + strconv.Itoa(p.Port))
+	}
+
+	if p.IsHTTP() {
+		if len(p.Upstreams) != 0 || len(p.Sni) != 0 {
+			panic(p.Type + " protocol can't have upstreams, they are specified by endpoints")
+		}
+	} else {
+		p.validateLayer4()
+	}
+
+	if p.ProxyProtocol != "" && p.ProxyProtocol != ProxyProtocol.Accept && p.ProxyProtocol != ProxyProtocol.Require {
+		panic("Unsupported proxyProtocol mode: " + p.ProxyProtocol + ". Supported: accept, require")
+	}
+	if p.ProxyProtocol != "" && len(p.ProxyTrusted) == 0 {
+		panic("proxyProtocol requires 'proxyTrusted' addresses of load balancers allowed to send the header")
+	}
+	if p.ProxyTimeout < 0 {
+		panic("proxyTimeout can't be negative")
+	}
+	trusted, err := Forwarding.ParseCIDRs(p.ProxyTrusted)
+	if err != nil {
+		panic("Invalid proxyTrusted value. " + err.Error())
+	}
+	p.trusted = trusted
+}
+
+func (p *Protocol) validateLayer4() {
+	port := strconv.Itoa(p.Port)
+	if len(p.Upstreams) == 0 && len(p.Sni) == 0 {
+		panic(p.Type + " protocol on port " + port + " should contain upstreams")
+	}
+	for _, pool := range append([][]string{p.Upstreams}, sniPools(p.Sni)...) {
+		for _, u := range pool {
+			if _, _, err := net.SplitHostPort(u); err != nil {
+				panic("Invalid upstream address: " + u + " on port " + port)
+			}
+		}
+	}
+
+	if p.Balance == "" {
+		p.Balance = "round_robin"
+	} else if p.Balance != "round_robin" && p.Balance != "least_conn" && p.Balance != "ip_hash" {
+		panic("Unsupported balance: " + p.Balance + ". Supported: round_robin, least_conn, ip_hash")
+	}
+	if p.IdleTimeout < 0 || p.MaxConnections < 0 {
+		panic("idleTimeout and maxConnections can't be negative on port " + port)
+	}
+	if (p.CertPath == "") != (p.KeyPath == "") {
+		panic("TLS termination requires both CertPath and KeyPath on port " + port)
+	}
+
+	if p.Type == "udp" {
+		if p.CertPath != "" || len(p.Sni) != 0 || p.ProxyProtocol != "" {
+			panic("udp protocol doesn't support TLS, sni and proxyProtocol")
+		}
+	}
+}
+
+func sniPools(sni map[string][]string) [][]string {
+	var rv [][]string
+	for name, pool := range sni {
+		if len(pool) == 0 {
+			panic("Empty upstream list for server name: " + name)
+		}
+		rv = append(rv, pool)
+	}
+	return rv
+}
+
+// IsHTTP reports whether listener serves http endpoints rather than proxies raw connections
+func (p *Protocol) IsHTTP() bool {
+	return p.Type == "http" || p.Type == "https"
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	// certificate is asked for but not verified, can be used only for fingerprint pinning
+	"request":         tls.RequestClientCert,
+	"require":         tls.RequireAndVerifyClientCert,
+	"verify-if-given": tls.VerifyClientCertIfGiven,
+}
+
+// reports whether listener terminates TLS
+func (p *Protocol) usesTLS() bool {
+	return p.CertPath != "" || p.Acme
+}
+
+// returns TLS policy of the listener, default one is created if it isn't specified
+func (p *Protocol) tlsSettings() *TLSSettings {
+	if p.Tls == nil {
+		p.Tls = &TLSSettings{}
+	}
+	if p.Type == "https" && len(p.Tls.Alpn) == 0 {
+		p.Tls.Alpn = []string{"h2", "http/1.1"}
+	}
+	return p.Tls
+}
+
+// TLSConfig creates TLS configuration of the listener from its certificate and client authentication settings
+func (p *Protocol) TLSConfig() (*tls.Config, error) {
+	config := &tls.Config{}
+	var static *tls.Certificate
+	if p.CertPath != "" {
+		cert, dev, err := p.devCertificate()
+		if !dev {
+			var pair tls.Certificate
+			pair, err = tls.LoadX509KeyPair(p.CertPath, p.KeyPath)
+			cert = &pair
+		}
+		if err != nil {
+			return nil, errors.New("Can't load TLS certificate on port " + strconv.Itoa(p.Port) + ". Error: " + err.Error())
+		}
+		static = cert
+	}
+
+	if p.ClientAuth != "" {
+		config.ClientAuth = clientAuthTypes[p.ClientAuth]
+	}
+	policy := p.tlsSettings()
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	if err := policy.apply(config); err != nil {
+		return nil, err
+	}
+	// static certificate of ACME listener is used only as fallback
+	if p.Acme {
+		Acme.Configure(config, static)
+	} else {
+		config.Certificates = []tls.Certificate{*static}
+	}
+
+	if p.ClientCA != "" {
+		data, err := ioutil.ReadFile(p.ClientCA)
+		if err != nil {
+			return nil, errors.New("Can't read ClientCA file. Error: " + err.Error())
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(data) {
+			return nil, errors.New("ClientCA file doesn't contain PEM certificates: " + p.ClientCA)
+		}
+	}
+
+	return config, nil
+}
+
+// Listen creates listener of the protocol on the address, PROXY protocol header is decoded if it's enabled.
+// https listener also terminates TLS
+func (p *Protocol) Listen(addr string) (net.Listener, error) {
+	var config *tls.Config
+	if p.Type == "https" {
+		c, err := p.TLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		config = c
+	}
+
+	// listener of previous process is taken over if it's handed off
+	ln, err := Handoff.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if p.ProxyProtocol != "" {
+		ln = ProxyProtocol.NewListener(ln, p.ProxyProtocol, p.trusted, time.Duration(p.ProxyTimeout) * time.Second)
+	}
+	if config != nil {
+		ln = &tlsListener{Listener: tls.NewListener(ln, config), stop: p.RotateTicketKeys(config)}
+	}
+	return ln, nil
+}
+
+// RotateTicketKeys starts session ticket keys rotation of the listener configuration created by TLSConfig.
+// Returned func stops it, should be called when listener is closed
+func (p *Protocol) RotateTicketKeys(config *tls.Config) func() {
+	return p.tlsSettings().rotateTicketKeys(config)
+}
+
+// tlsListener stops session ticket keys rotation when it's closed
+type tlsListener struct {
+	net.Listener
+	stop func()
+}
+
+func (l *tlsListener) Close() error {
+	l.stop()
+	return l.Listener.Close()
+}
+
+// ListenPacket creates connection of udp protocol on the address
+func (p *Protocol) ListenPacket(addr string) (net.PacketConn, error) {
+	return Handoff.ListenPacket("udp", addr)
 }
 
 func ReadProtocolFormFile(prot interface{}) []Protocol {
@@ -45,6 +309,7 @@ func ReadProtocolFormFile(prot interface{}) []Protocol {
 }
 
 func InitProtocols(file map[string]interface{}) {
+	initDevMode(file)
 	if v, exist := file["Protocols"]; exist {
 		Protocols = ReadProtocolFormFile(v)
 	} else {
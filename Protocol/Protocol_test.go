@@ -0,0 +1,207 @@
+package Protocol
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTLSSettings_Validate(t *testing.T) {
+	invalid := map[string]TLSSettings{
+		"unknown preset":             {Preset: "paranoid"},
+		"old version":                {MinVersion: "1.0"},
+		"min above max":              {MinVersion: "1.3", MaxVersion: "1.2"},
+		"insecure suite":             {Preset: Legacy, CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+		"suite without ECDHE":        {CipherSuites: []string{"TLS_RSA_WITH_AES_128_GCM_SHA256"}},
+		"unknown suite":              {CipherSuites: []string{"TLS_FAKE"}},
+		"suites for TLS 1.3":         {Preset: Modern, CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}},
+		"unknown curve":              {Curves: []string{"P192"}},
+		"h2 without required suite":  {Alpn: []string{"h2"}, CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"}},
+		"disabled tickets with keys": {DisableSessionTickets: true, SessionTicketRotation: 60},
+	}
+	for name, s := range invalid {
+		if err := s.Validate(); err == nil {
+			t.Error("Validate() should fail for " + name)
+		}
+	}
+
+	legacy := TLSSettings{Preset: Legacy}
+	if err := legacy.Validate(); err != nil || legacy.minVersion != tls.VersionTLS10 {
+		t.Error("Legacy preset should allow TLS 1.0: ", err)
+	}
+	s := TLSSettings{}
+	if err := s.Validate(); err != nil || s.Preset != Intermediate || s.minVersion != tls.VersionTLS12 ||
+		len(s.cipherSuites) != len(intermediateSuites) {
+		t.Error("Intermediate preset should be used by default: ", err)
+	}
+}
+
+func TestReadTicketKeys(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "tls")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "tickets")
+	key := strings.Repeat("ab", 32)
+	ioutil.WriteFile(path, []byte(key+"\n\n"+"q83vq83vq83vq83vq83vq83vq83vq83vq83vq83vq80=\n"), 0600)
+	keys, err := readTicketKeys(path)
+	if err != nil || len(keys) != 2 || hex.EncodeToString(keys[0][:]) != key || keys[1][0] != 0xab {
+		t.Error("Hex and base64 keys should be read: ", err)
+	}
+
+	ioutil.WriteFile(path, []byte("abcd\n"), 0600)
+	if _, err := readTicketKeys(path); err == nil {
+		t.Error("Short key should be rejected")
+	}
+}
+
+func TestRotateTicketKeys(t *testing.T) {
+	s := TLSSettings{SessionTicketRotation: 60}
+	s.Validate()
+	before := runtime.NumGoroutine()
+	config := &tls.Config{}
+	if err := s.apply(config); err != nil || runtime.NumGoroutine() != before {
+		t.Error("Applying policy shouldn't start ticket keys rotation: ", err)
+	}
+
+	stop := s.rotateTicketKeys(config)
+	if runtime.NumGoroutine() != before+1 {
+		t.Error("Rotation should be started once")
+	}
+	stop()
+	stop()
+	for i := 0; i < 100 && runtime.NumGoroutine() != before; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if runtime.NumGoroutine() != before {
+		t.Error("Rotation should be stopped")
+	}
+}
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// issues certificate signed by ca, self signed CA certificate is created if ca is nil
+func issue(t *testing.T, ca *testCA, cn string) (*testCA, []byte, []byte) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{SerialNumber: big.NewInt(time.Now().UnixNano()), Subject: pkix.Name{CommonName: cn},
+		DNSNames: []string{cn}, NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour),
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}}
+	parent, signer := template, key
+	if ca == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		parent, signer = ca.cert, ca.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	return &testCA{cert: cert, key: key}, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func TestListenTLS(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "tls")
+	defer os.RemoveAll(dir)
+
+	ca, caPem, _ := issue(t, nil, "Test CA")
+	_, certPem, keyPem := issue(t, ca, "localhost")
+	_, clientPem, clientKeyPem := issue(t, ca, "client")
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		ioutil.WriteFile(path, data, 0600)
+		return path
+	}
+
+	p := Protocol{Type: "https", CertPath: write("cert.pem", certPem), KeyPath: write("key.pem", keyPem),
+		ClientCA: write("ca.pem", caPem), ClientAuth: "require"}
+	p.Validate()
+	ln, err := p.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				c.(*tls.Conn).Handshake()
+				c.Close()
+			}()
+		}
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caPem)
+	client, _ := tls.X509KeyPair(clientPem, clientKeyPem)
+	dial := func(config *tls.Config) (*tls.Conn, error) {
+		config.RootCAs, config.ServerName = roots, "localhost"
+		return tls.Dial("tcp", ln.Addr().String(), config)
+	}
+
+	c, err := dial(&tls.Config{Certificates: []tls.Certificate{client}, NextProtos: []string{"h2", "http/1.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ConnectionState().NegotiatedProtocol != "h2" || c.ConnectionState().Version < tls.VersionTLS12 {
+		t.Error("Unexpected connection state: ", c.ConnectionState())
+	}
+	c.Close()
+
+	if c, err := dial(&tls.Config{Certificates: []tls.Certificate{client}, MaxVersion: tls.VersionTLS11}); err == nil {
+		c.Close()
+		t.Error("TLS 1.1 shouldn't be accepted by intermediate preset")
+	}
+
+	// with TLS 1.3 client certificate is rejected after handshake, so read is required to see the error
+	if c, err := dial(&tls.Config{}); err == nil {
+		_, err = c.Read(make([]byte, 1))
+		c.Close()
+		if err == nil || !strings.Contains(err.Error(), "certificate") {
+			t.Error("Connection without client certificate should be rejected: ", err)
+		}
+	}
+}
+
+func TestDevCertificate(t *testing.T) {
+	devMode, devHosts = true, []string{"localhost", "127.0.0.1"}
+	defer func() { devMode, devHosts = false, nil }()
+
+	p := Protocol{Type: "https", CertPath: "missing/cert.pem", KeyPath: "missing/key.pem"}
+	p.Validate()
+	config, err := p.TLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+	if err := leaf.VerifyHostname("127.0.0.1"); err != nil {
+		t.Error("Development certificate should be issued for configured hosts: ", err)
+	}
+
+	devMode = false
+	if _, err := p.TLSConfig(); err == nil {
+		t.Error("Missing certificate files should be an error outside of development mode")
+	}
+}
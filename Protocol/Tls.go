@@ -0,0 +1,307 @@
+package Protocol
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"proxy/Logger"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// TLS 1.3 only
+	Modern = "modern"
+	// TLS 1.2 and 1.3 with forward secret AEAD cipher suites, used by default
+	Intermediate = "intermediate"
+	// also allows TLS 1.0 and 1.1 and ECDHE CBC cipher suites for old clients
+	Legacy = "legacy"
+)
+
+// TLSSettings describes TLS policy of the listener. Values that aren't specified are taken from preset
+type TLSSettings struct {
+	// modern, intermediate (default) or legacy
+	Preset string
+	// TLS versions: 1.0, 1.1, 1.2 or 1.3
+	MinVersion string
+	MaxVersion string
+	// cipher suite names, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Can't be configured for TLS 1.3
+	CipherSuites []string
+	// X25519, P256, P384 or P521 in order of preference
+	Curves []string
+	// protocols offered by ALPN, 'h2' and 'http/1.1' are used for https by default
+	Alpn []string
+	// file with session ticket keys, one hex or base64 encoded 32 byte key per line.
+	// First key encrypts new tickets, the rest are used only to decrypt tickets issued before
+	SessionTicketKeys string
+	// seconds between session ticket key rotations: keys file is re-read, or new random key is generated
+	// if file isn't specified. 0 disables rotation
+	SessionTicketRotation int
+	DisableSessionTickets bool
+
+	minVersion   uint16
+	maxVersion   uint16
+	cipherSuites []uint16
+	curves       []tls.CurveID
+}
+
+var versions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var curves = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+}
+
+var intermediateSuites = []string{
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256",
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256",
+}
+
+var presets = map[string]TLSSettings{
+	Modern:       {MinVersion: "1.3", Curves: []string{"X25519", "P256", "P384"}},
+	Intermediate: {MinVersion: "1.2", CipherSuites: intermediateSuites, Curves: []string{"X25519", "P256", "P384"}},
+	Legacy: {MinVersion: "1.0", Curves: []string{"X25519", "P256", "P384"}, CipherSuites: append(append([]string{},
+		intermediateSuites...),
+		"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA",
+		"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA",
+		"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA",
+		"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA",
+	)},
+}
+
+// cipher suite ids by name, second value tells whether suite is known to be insecure
+func cipherSuite(name string) (uint16, bool, bool) {
+	for _, s := range tls.CipherSuites() {
+		if s.Name == name {
+			return s.ID, false, true
+		}
+	}
+	for _, s := range tls.InsecureCipherSuites() {
+		if s.Name == name {
+			return s.ID, true, true
+		}
+	}
+	return 0, false, false
+}
+
+// suites without ECDHE key exchange have no forward secrecy, suites without GCM or ChaCha20 aren't AEAD
+func strongSuite(name string) bool {
+	return strings.Contains(name, "_ECDHE_") && (strings.Contains(name, "_GCM_") || strings.Contains(name, "CHACHA20"))
+}
+
+func (s *TLSSettings) Validate() error {
+	if s.Preset == "" {
+		s.Preset = Intermediate
+	}
+	preset, ok := presets[s.Preset]
+	if !ok {
+		return errors.New("Unsupported TLS preset: " + s.Preset + ". Supported: modern, intermediate, legacy")
+	}
+
+	minVersion, maxVersion := s.MinVersion, s.MaxVersion
+	if minVersion == "" {
+		minVersion = preset.MinVersion
+	}
+	if maxVersion == "" {
+		maxVersion = "1.3"
+	}
+	if s.minVersion, ok = versions[minVersion]; !ok {
+		return errors.New("Unsupported TLS minVersion: " + minVersion + ". Supported: 1.0, 1.1, 1.2, 1.3")
+	}
+	if s.maxVersion, ok = versions[maxVersion]; !ok {
+		return errors.New("Unsupported TLS maxVersion: " + maxVersion + ". Supported: 1.0, 1.1, 1.2, 1.3")
+	}
+	if s.minVersion > s.maxVersion {
+		return errors.New("TLS minVersion can't be greater than maxVersion")
+	}
+	if s.minVersion < tls.VersionTLS12 && s.Preset != Legacy {
+		return errors.New("TLS versions below 1.2 are allowed only with 'legacy' preset")
+	}
+
+	suites := s.CipherSuites
+	if len(suites) == 0 {
+		suites = preset.CipherSuites
+	} else if s.minVersion == tls.VersionTLS13 {
+		return errors.New("TLS cipherSuites can't be configured when only TLS 1.3 is allowed")
+	}
+	s.cipherSuites = nil
+	for _, name := range suites {
+		id, insecure, known := cipherSuite(name)
+		if !known {
+			return errors.New("Unknown TLS cipher suite: " + name)
+		}
+		if insecure {
+			return errors.New("Insecure TLS cipher suite: " + name)
+		}
+		if !strongSuite(name) && s.Preset != Legacy {
+			return errors.New("TLS cipher suite without forward secrecy or AEAD is allowed only with 'legacy' preset: " + name)
+		}
+		s.cipherSuites = append(s.cipherSuites, id)
+	}
+
+	names := s.Curves
+	if len(names) == 0 {
+		names = preset.Curves
+	}
+	s.curves = nil
+	for _, name := range names {
+		id, ok := curves[name]
+		if !ok {
+			return errors.New("Unsupported TLS curve: " + name + ". Supported: X25519, P256, P384, P521")
+		}
+		s.curves = append(s.curves, id)
+	}
+
+	// HTTP/2 clients reject connections that don't use the suite required by RFC 7540
+	if s.minVersion < tls.VersionTLS13 && len(s.CipherSuites) != 0 && contains(s.Alpn, "h2") &&
+		!contains(s.CipherSuites, "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256") &&
+		!contains(s.CipherSuites, "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256") {
+		return errors.New("ALPN 'h2' requires TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 cipher suite")
+	}
+
+	if s.SessionTicketRotation < 0 {
+		return errors.New("TLS sessionTicketRotation can't be negative")
+	}
+	if s.DisableSessionTickets && (s.SessionTicketKeys != "" || s.SessionTicketRotation != 0) {
+		return errors.New("TLS session ticket keys can't be used when session tickets are disabled")
+	}
+
+	return nil
+}
+
+func contains(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+// apply sets policy on TLS configuration. Session ticket keys are rotated only by rotateTicketKeys
+func (s *TLSSettings) apply(config *tls.Config) error {
+	config.MinVersion = s.minVersion
+	config.MaxVersion = s.maxVersion
+	config.CipherSuites = s.cipherSuites
+	config.CurvePreferences = s.curves
+	config.NextProtos = s.Alpn
+
+	if s.DisableSessionTickets {
+		config.SessionTicketsDisabled = true
+		return nil
+	}
+	if s.SessionTicketKeys != "" {
+		keys, err := readTicketKeys(s.SessionTicketKeys)
+		if err != nil {
+			return err
+		}
+		config.SetSessionTicketKeys(keys)
+	}
+	return nil
+}
+
+// number of random keys kept, so tickets stay valid for this number of rotation periods
+const ticketKeysKept = 3
+
+// rotateTicketKeys starts session ticket keys rotation of the configuration if it's required.
+// It should be started once per listener, returned func stops it and may be called more than once
+func (s *TLSSettings) rotateTicketKeys(config *tls.Config) func() {
+	if s.DisableSessionTickets || s.SessionTicketRotation <= 0 {
+		return func() {}
+	}
+	var keys [][32]byte
+	if s.SessionTicketKeys == "" {
+		keys = [][32]byte{randomTicketKey()}
+		config.SetSessionTicketKeys(keys)
+	}
+
+	ticker := time.NewTicker(time.Duration(s.SessionTicketRotation) * time.Second)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			if s.SessionTicketKeys != "" {
+				fileKeys, err := readTicketKeys(s.SessionTicketKeys)
+				if err != nil {
+					// previous keys are kept until the file is fixed
+					Logger.Error(map[string]string{"file": s.SessionTicketKeys, "error": err.Error()},
+						"Can't rotate TLS session ticket keys")
+					continue
+				}
+				config.SetSessionTicketKeys(fileKeys)
+				continue
+			}
+
+			keys = append([][32]byte{randomTicketKey()}, keys...)
+			if len(keys) > ticketKeysKept {
+				keys = keys[:ticketKeysKept]
+			}
+			config.SetSessionTicketKeys(keys)
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			ticker.Stop()
+			close(done)
+		})
+	}
+}
+
+func randomTicketKey() [32]byte {
+	var key [32]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		panic("Can't generate TLS session ticket key. Error: " + err.Error())
+	}
+	return key
+}
+
+func readTicketKeys(path string) ([][32]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.New("Can't read TLS session ticket keys. Error: " + err.Error())
+	}
+
+	var keys [][32]byte
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		raw, err := hex.DecodeString(line)
+		if err != nil {
+			raw, err = base64.StdEncoding.DecodeString(line)
+		}
+		if err != nil || len(raw) != 32 {
+			return nil, errors.New("TLS session ticket key should be 32 bytes encoded as hex or base64: " + path)
+		}
+		var key [32]byte
+		copy(key[:], raw)
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("TLS session ticket keys file is empty: " + path)
+	}
+	return keys, nil
+}
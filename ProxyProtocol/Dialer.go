@@ -0,0 +1,42 @@
+package ProxyProtocol
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+type contextKey struct{}
+
+// NewContext returns copy of parent context carrying addresses of the client connection,
+// they are sent to upstream by transport created with NewTransport
+func NewContext(parent context.Context, source net.Addr, destination net.Addr) context.Context {
+	return context.WithValue(parent, contextKey{}, &Header{Source: source, Destination: destination})
+}
+
+// NewTransport creates transport that starts every upstream connection with PROXY protocol header of given version.
+// Header describes the client stored in request context. Connections belong to single client, so they aren't reused
+func NewTransport(version int) *http.Transport {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DisableKeepAlives = true
+	t.DialContext = func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		c, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		h := &Header{Version: version}
+		if v, ok := ctx.Value(contextKey{}).(*Header); ok {
+			h.Source, h.Destination = v.Source, v.Destination
+		}
+		if _, err := c.Write(h.Format()); err != nil {
+			c.Close()
+			return nil, err
+		}
+		return c, nil
+	}
+	return t
+}
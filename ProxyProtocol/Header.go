@@ -0,0 +1,205 @@
+package ProxyProtocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// signature that starts every v2 header
+var signatureV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// longest possible v1 header including CRLF
+const maxV1Length = 107
+
+// ErrNoHeader is returned when connection doesn't start with PROXY protocol header
+var ErrNoHeader = errors.New("PROXY protocol header is missing")
+
+// Header describes addresses of the original connection.
+// Source and Destination are nil for LOCAL (v2) and UNKNOWN (v1) connections, real connection addresses should be used then
+type Header struct {
+	Version     int
+	Source      net.Addr
+	Destination net.Addr
+}
+
+// Read reads PROXY protocol header of any version from the reader.
+// ErrNoHeader is returned, and nothing is consumed, if data doesn't start with the header
+func Read(r *bufio.Reader) (*Header, error) {
+	prefix, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch prefix[0] {
+	case 'P':
+		if start, err := r.Peek(6); err != nil || string(start) != "PROXY " {
+			return nil, ErrNoHeader
+		}
+		return readV1(r)
+	case signatureV2[0]:
+		if start, err := r.Peek(len(signatureV2)); err != nil || !bytes.Equal(start, signatureV2) {
+			return nil, ErrNoHeader
+		}
+		return readV2(r)
+	}
+	return nil, ErrNoHeader
+}
+
+func readV1(r *bufio.Reader) (*Header, error) {
+	var line []byte
+	for len(line) < maxV1Length {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("PROXY protocol v1 header isn't terminated by CRLF")
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return &Header{Version: 1}, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errors.New("Invalid PROXY protocol v1 header: " + string(line))
+	}
+
+	src, err := v1Address(fields[1], fields[2], fields[4])
+	if err != nil {
+		return nil, err
+	}
+	dst, err := v1Address(fields[1], fields[3], fields[5])
+	if err != nil {
+		return nil, err
+	}
+	return &Header{Version: 1, Source: src, Destination: dst}, nil
+}
+
+func v1Address(family string, ip string, port string) (*net.TCPAddr, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil || (family == "TCP4") != (addr.To4() != nil) {
+		return nil, errors.New("Invalid address in PROXY protocol v1 header: " + ip)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p < 0 || p > 65535 {
+		return nil, errors.New("Invalid port in PROXY protocol v1 header: " + port)
+	}
+	return &net.TCPAddr{IP: addr, Port: p}, nil
+}
+
+func readV2(r *bufio.Reader) (*Header, error) {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, err
+	}
+	if fixed[12]>>4 != 2 {
+		return nil, errors.New("Unsupported PROXY protocol v2 version")
+	}
+	command, family := fixed[12]&0x0f, fixed[13]
+
+	payload := make([]byte, binary.BigEndian.Uint16(fixed[14:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	switch command {
+	case 0x0: // LOCAL, connection made by the proxy itself (health checks)
+		return &Header{Version: 2}, nil
+	case 0x1: // PROXY
+	default:
+		return nil, errors.New("Unsupported PROXY protocol v2 command")
+	}
+
+	var size int
+	switch family >> 4 {
+	case 0x1:
+		size = net.IPv4len
+	case 0x2:
+		size = net.IPv6len
+	default:
+		// unix sockets and unspecified family carry no usable address
+		return &Header{Version: 2}, nil
+	}
+	if len(payload) < 2*size+4 {
+		return nil, errors.New("PROXY protocol v2 header is too short for its address family")
+	}
+
+	srcIP, dstIP := net.IP(payload[:size]), net.IP(payload[size:2*size])
+	srcPort := int(binary.BigEndian.Uint16(payload[2*size:]))
+	dstPort := int(binary.BigEndian.Uint16(payload[2*size+2:]))
+
+	h := &Header{Version: 2}
+	// TLVs following addresses aren't used
+	if family&0x0f == 0x2 {
+		h.Source, h.Destination = &net.UDPAddr{IP: srcIP, Port: srcPort}, &net.UDPAddr{IP: dstIP, Port: dstPort}
+	} else {
+		h.Source, h.Destination = &net.TCPAddr{IP: srcIP, Port: srcPort}, &net.TCPAddr{IP: dstIP, Port: dstPort}
+	}
+	return h, nil
+}
+
+// splits address into ip and port, nil ip is returned for non ip addresses
+func ipPort(addr net.Addr) (net.IP, int) {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP, a.Port
+	case *net.UDPAddr:
+		return a.IP, a.Port
+	}
+	return nil, 0
+}
+
+// Format returns header in wire format of its version.
+// Header without usable addresses is formatted as UNKNOWN (v1) or LOCAL (v2)
+func (h *Header) Format() []byte {
+	srcIP, srcPort := ipPort(h.Source)
+	dstIP, dstPort := ipPort(h.Destination)
+	ipv4 := srcIP.To4() != nil && dstIP.To4() != nil
+	known := srcIP != nil && dstIP != nil && (ipv4 || (srcIP.To4() == nil && dstIP.To4() == nil))
+
+	if h.Version == 1 {
+		if !known {
+			return []byte("PROXY UNKNOWN\r\n")
+		}
+		family := "TCP6"
+		if ipv4 {
+			family = "TCP4"
+		}
+		return []byte("PROXY " + family + " " + srcIP.String() + " " + dstIP.String() + " " +
+			strconv.Itoa(srcPort) + " " + strconv.Itoa(dstPort) + "\r\n")
+	}
+
+	rv := append([]byte(nil), signatureV2...)
+	if !known {
+		return append(rv, 0x20, 0x00, 0x00, 0x00)
+	}
+
+	family, size := byte(0x21), net.IPv6len
+	if ipv4 {
+		family, size = 0x11, net.IPv4len
+		srcIP, dstIP = srcIP.To4(), dstIP.To4()
+	} else {
+		srcIP, dstIP = srcIP.To16(), dstIP.To16()
+	}
+	if _, ok := h.Source.(*net.UDPAddr); ok {
+		family++
+	}
+
+	rv = append(rv, 0x21, family, 0, 0)
+	binary.BigEndian.PutUint16(rv[len(rv)-2:], uint16(2*size+4))
+	rv = append(rv, srcIP...)
+	rv = append(rv, dstIP...)
+	rv = append(rv, byte(srcPort>>8), byte(srcPort), byte(dstPort>>8), byte(dstPort))
+	return rv
+}
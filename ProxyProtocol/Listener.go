@@ -0,0 +1,134 @@
+package ProxyProtocol
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"proxy/Forwarding"
+)
+
+const (
+	// header is decoded if present, connections without it are served as is
+	Accept = "accept"
+	// connections without header are closed
+	Require = "require"
+)
+
+// time given to the client to send the header if listener doesn't specify it
+const DefaultHeaderTimeout = 5 * time.Second
+
+// listener decodes PROXY protocol header of accepted connections
+type listener struct {
+	net.Listener
+	mode    string
+	trusted []*net.IPNet
+	timeout time.Duration
+}
+
+// NewListener wraps listener so that addresses of accepted connections are taken from PROXY protocol header.
+// Header is decoded only for connections coming from trusted networks, empty list trusts no source.
+// Header is read lazily on first use of the connection, so slow clients don't block Accept
+func NewListener(inner net.Listener, mode string, trusted []*net.IPNet, timeout time.Duration) net.Listener {
+	if timeout <= 0 {
+		timeout = DefaultHeaderTimeout
+	}
+	return &listener{Listener: inner, mode: mode, trusted: trusted, timeout: timeout}
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: c, listener: l}, nil
+}
+
+func (l *listener) isTrusted(addr net.Addr) bool {
+	ip, _ := ipPort(addr)
+	return Forwarding.ContainsIP(l.trusted, ip)
+}
+
+type conn struct {
+	net.Conn
+	listener *listener
+
+	once   sync.Once
+	reader *bufio.Reader
+	header *Header
+	err    error
+
+	// read deadline requested by the user of connection, restored after header is read
+	mu           sync.Mutex
+	readDeadline time.Time
+}
+
+func (c *conn) init() {
+	c.once.Do(func() {
+		if !c.listener.isTrusted(c.Conn.RemoteAddr()) {
+			if c.listener.mode == Require {
+				c.err = errors.New("PROXY protocol header from untrusted source: " + c.Conn.RemoteAddr().String())
+			}
+			return
+		}
+
+		c.Conn.SetReadDeadline(time.Now().Add(c.listener.timeout))
+		c.reader = bufio.NewReader(c.Conn)
+		c.header, c.err = Read(c.reader)
+
+		c.mu.Lock()
+		c.Conn.SetReadDeadline(c.readDeadline)
+		c.mu.Unlock()
+
+		if c.err == ErrNoHeader && c.listener.mode == Accept {
+			c.err = nil
+		}
+	})
+}
+
+// Header returns decoded PROXY protocol header, nil if connection didn't have one
+func (c *conn) Header() *Header {
+	c.init()
+	return c.header
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.reader != nil {
+		return c.reader.Read(p)
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	if h := c.Header(); h != nil && h.Source != nil {
+		return h.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *conn) LocalAddr() net.Addr {
+	if h := c.Header(); h != nil && h.Destination != nil {
+		return h.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+func (c *conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.mu.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.mu.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
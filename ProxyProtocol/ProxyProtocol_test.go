@@ -0,0 +1,185 @@
+package ProxyProtocol
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRead(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("PROXY TCP4 198.51.100.7 10.0.0.1 40000 443\r\nGET / HTTP/1.1\r\n"))
+	h, err := Read(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Version != 1 || h.Source.String() != "198.51.100.7:40000" || h.Destination.String() != "10.0.0.1:443" {
+		t.Error("Unexpected v1 header: ", h)
+	}
+	if rest, _ := r.ReadString('\n'); rest != "GET / HTTP/1.1\r\n" {
+		t.Error("Data after header should be kept, got: " + rest)
+	}
+
+	for _, src := range []string{"2001:db8::1", "198.51.100.7"} {
+		expected := &Header{Version: 2, Source: &net.TCPAddr{IP: net.ParseIP(src), Port: 40000},
+			Destination: &net.TCPAddr{IP: net.ParseIP(src), Port: 443}}
+		h, err = Read(bufio.NewReader(bytes.NewReader(expected.Format())))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Version != 2 || h.Source.String() != expected.Source.String() ||
+			h.Destination.String() != expected.Destination.String() {
+			t.Error("Unexpected v2 header: ", h)
+		}
+	}
+
+	h, err = Read(bufio.NewReader(bytes.NewReader((&Header{Version: 2}).Format())))
+	if err != nil || h.Source != nil {
+		t.Error("LOCAL header should be read without addresses")
+	}
+
+	r = bufio.NewReader(strings.NewReader("POST / HTTP/1.1\r\n"))
+	if _, err = Read(r); err != ErrNoHeader || r.Buffered() != len("POST / HTTP/1.1\r\n") {
+		t.Error("Request without header shouldn't be consumed")
+	}
+
+	invalid := []string{
+		"PROXY TCP4 2001:db8::1 10.0.0.1 1 2\r\n",
+		"PROXY TCP4 198.51.100.7 10.0.0.1 1\r\n",
+		"PROXY TCP4 198.51.100.7 10.0.0.1 1 70000\r\n",
+		"PROXY " + strings.Repeat("A", 120),
+	}
+	for _, v := range invalid {
+		if _, err = Read(bufio.NewReader(strings.NewReader(v))); err == nil || err == ErrNoHeader {
+			t.Error("Header should be rejected: " + v)
+		}
+	}
+}
+
+// starts listener that echoes remote address and first line of every connection
+func startEcho(t *testing.T, mode string, trusted ...string) net.Listener {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nets []*net.IPNet
+	for _, v := range trusted {
+		_, n, _ := net.ParseCIDR(v)
+		nets = append(nets, n)
+	}
+	ln := NewListener(inner, mode, nets, 200*time.Millisecond)
+
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				line, err := bufio.NewReader(c).ReadString('\n')
+				if err != nil {
+					return
+				}
+				c.Write([]byte(c.RemoteAddr().String() + " " + line))
+			}(c)
+		}
+	}()
+	return ln
+}
+
+func exchange(t *testing.T, addr string, data string) string {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte(data))
+	resp, _ := ioutil.ReadAll(c)
+	return string(resp)
+}
+
+func TestListener(t *testing.T) {
+	ln := startEcho(t, Require, "127.0.0.0/8")
+	defer ln.Close()
+
+	if resp := exchange(t, ln.Addr().String(), "PROXY TCP4 198.51.100.7 10.0.0.1 40000 443\r\nhello\n"); resp != "198.51.100.7:40000 hello\n" {
+		t.Error("Client address should be taken from header, got: " + resp)
+	}
+	if resp := exchange(t, ln.Addr().String(), "hello\n"); resp != "" {
+		t.Error("Connection without header should be closed in require mode, got: " + resp)
+	}
+
+	accept := startEcho(t, Accept, "127.0.0.0/8")
+	defer accept.Close()
+	if resp := exchange(t, accept.Addr().String(), "hello\n"); !strings.HasPrefix(resp, "127.0.0.1:") {
+		t.Error("Connection without header should be served in accept mode, got: " + resp)
+	}
+
+	// connection that doesn't send anything is cut after header timeout
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := c.Read(make([]byte, 1)); err == nil || strings.Contains(err.Error(), "timeout") {
+		t.Error("Connection should be closed after header timeout")
+	}
+
+	untrusted := startEcho(t, Require, "10.0.0.0/8")
+	defer untrusted.Close()
+	if resp := exchange(t, untrusted.Addr().String(), "PROXY TCP4 198.51.100.7 10.0.0.1 40000 443\r\nhello\n"); resp != "" {
+		t.Error("Header from untrusted source should be rejected, got: " + resp)
+	}
+
+	// no source is trusted without the list, so clients can't spoof their address
+	open := startEcho(t, Accept)
+	defer open.Close()
+	if resp := exchange(t, open.Addr().String(), "PROXY TCP4 198.51.100.7 10.0.0.1 40000 443\r\n"); !strings.HasPrefix(resp, "127.0.0.1:") {
+		t.Error("Header shouldn't be decoded without trusted sources, got: " + resp)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+
+	headers := make(chan *Header, 1)
+	go func() {
+		c, err := upstream.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		r := bufio.NewReader(c)
+		h, _ := Read(r)
+		headers <- h
+		http.ReadRequest(r)
+		c.Write([]byte("HTTP/1.1 204 No Content\r\n\r\n"))
+	}()
+
+	src := &net.TCPAddr{IP: net.ParseIP("198.51.100.7"), Port: 40000}
+	dst := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 443}
+	req, _ := http.NewRequest("GET", "http://"+upstream.Addr().String()+"/", nil)
+	req = req.WithContext(NewContext(context.Background(), src, dst))
+
+	resp, err := NewTransport(1).RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	h := <-headers
+	if h == nil || h.Version != 1 || h.Source.String() != src.String() || h.Destination.String() != dst.String() {
+		t.Error("Upstream should receive PROXY protocol header of the client, got: ", h)
+	}
+}
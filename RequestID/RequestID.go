@@ -0,0 +1,104 @@
+package RequestID
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"proxy/Logger"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mitchellh/mapstructure"
+)
+
+// longest incoming id that is accepted
+const maxLength = 128
+
+type Settings struct {
+	// header request id is read from, sent to auth service and upstream and returned in, X-Request-Id by default
+	Header string
+	// always generate new id, id sent by the client is replaced
+	Ignore_incoming bool
+}
+
+func (s *Settings) Validate() error {
+	if s.Header == "" {
+		s.Header = "X-Request-Id"
+	}
+	if http.CanonicalHeaderKey(s.Header) == "Host" {
+		return errors.New("Request id header can't be 'Host'")
+	}
+	return nil
+}
+
+// settings used by all requests
+var Global = Settings{Header: "X-Request-Id"}
+
+// Read request id settings from parsed json value and validates it
+func ReadRequestIDFromFile(v interface{}) *Settings {
+	var s Settings
+	if err := mapstructure.Decode(v, &s); err != nil {
+		panic("Can't decode request id settings. Error: " + err.Error())
+	}
+	if err := s.Validate(); err != nil {
+		panic(err.Error())
+	}
+
+	return &s
+}
+
+// Inits request id settings from optional 'RequestId' section
+func InitRequestID(file map[string]interface{}) {
+	Global = Settings{Header: "X-Request-Id"}
+	if v, exist := file["RequestId"]; exist {
+		Global = *ReadRequestIDFromFile(v)
+	}
+}
+
+// New generates random request id in UUID v4 format
+func New() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic("Can't generate request id. Error: " + err.Error())
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	s := hex.EncodeToString(b[:])
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+// incoming id is accepted only if it can't break log lines and headers
+func valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' ||
+			r == '.' || r == ':' || r == '/' || r == '+' || r == '=') {
+			return false
+		}
+	}
+	return true
+}
+
+// FromContext returns id of the request being handled, empty string if there is none
+func FromContext(ctx context.Context) string {
+	return Logger.RequestIDFromContext(ctx)
+}
+
+// Middleware assigns id to the request. It's set in request header, so upstream gets it, in response header and
+// in request context, so loggers created by Logger.WithContext add it to every entry
+func Middleware(s *Settings) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(s.Header)
+		if s.Ignore_incoming || !valid(id) {
+			id = New()
+		}
+
+		c.Request.Header.Set(s.Header, id)
+		c.Writer.Header().Set(s.Header, id)
+		c.Request = c.Request.WithContext(Logger.ContextWithRequestID(c.Request.Context(), id))
+		c.Next()
+	}
+}
@@ -0,0 +1,67 @@
+package RequestID
+
+import (
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+var uuid = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestNew(t *testing.T) {
+	a, b := New(), New()
+	if !uuid.MatchString(a) || a == b {
+		t.Error("Unexpected generated ids: " + a + ", " + b)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := Settings{Header: "X-Correlation-Id"}
+	if err := s.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	var seen string
+	engine := gin.New()
+	engine.Use(Middleware(&s))
+	engine.GET("/", func(c *gin.Context) {
+		if FromContext(c.Request.Context()) != c.GetHeader("X-Correlation-Id") {
+			t.Error("Request header and context should contain the same id")
+		}
+		seen = FromContext(c.Request.Context())
+	})
+
+	serve := func(incoming string) string {
+		req := httptest.NewRequest("GET", "/", nil)
+		if incoming != "" {
+			req.Header.Set("X-Correlation-Id", incoming)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		if w.Header().Get("X-Correlation-Id") != seen {
+			t.Error("Response should contain id of the request")
+		}
+		return seen
+	}
+
+	if id := serve(""); !uuid.MatchString(id) {
+		t.Error("Id should be generated for request without it, got: " + id)
+	}
+	if id := serve("abc-123"); id != "abc-123" {
+		t.Error("Incoming id should be accepted, got: " + id)
+	}
+	for _, invalid := range []string{"bad id\"", strings.Repeat("a", maxLength+1)} {
+		if id := serve(invalid); !uuid.MatchString(id) {
+			t.Error("Invalid incoming id should be replaced, got: " + id)
+		}
+	}
+
+	s.Ignore_incoming = true
+	if id := serve("abc-123"); id == "abc-123" {
+		t.Error("Incoming id should be replaced when 'ignore_incoming' is set")
+	}
+}
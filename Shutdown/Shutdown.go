@@ -0,0 +1,193 @@
+package Shutdown
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"proxy/Logger"
+	"proxy/Tracing"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+var l *Logger.Logger
+
+// time log entries of async hooks are flushed for after connections are drained
+const flushTimeout = 5 * time.Second
+
+type Settings struct {
+	// seconds given to active requests, WebSockets and tcp connections to finish, 30 by default
+	Drain_timeout int
+	// seconds readiness probe fails before listeners are closed, so load balancers stop sending new traffic
+	Readiness_delay int
+}
+
+func (s *Settings) Validate() error {
+	if s.Drain_timeout < 0 || s.Readiness_delay < 0 {
+		return errors.New("Shutdown drain_timeout and readiness_delay can't be negative")
+	}
+	if s.Drain_timeout == 0 {
+		s.Drain_timeout = 30
+	}
+	return nil
+}
+
+var settings = Settings{Drain_timeout: 30}
+
+var (
+	draining int32
+	// requests and tcp connections that are being served
+	active int64
+
+	hooksMu sync.Mutex
+	hooks   []func(ctx context.Context)
+
+	// reason of shutdown started by the process itself, e.g. after listeners are handed off to new process
+	started = make(chan string, 1)
+)
+
+// Read shutdown settings from parsed json value and validates it
+func ReadShutdownFromFile(v interface{}) *Settings {
+	var s Settings
+	if err := mapstructure.Decode(v, &s); err != nil {
+		panic("Can't decode shutdown settings. Error: " + err.Error())
+	}
+	if err := s.Validate(); err != nil {
+		panic(err.Error())
+	}
+
+	return &s
+}
+
+// Inits shutdown settings from optional 'Shutdown' section
+func InitShutdown(file map[string]interface{}) {
+	l = Logger.New("Shutdown", 0, nil)
+	settings = Settings{Drain_timeout: 30}
+	if v, exist := file["Shutdown"]; exist {
+		settings = *ReadShutdownFromFile(v)
+	}
+}
+
+// Draining reports whether shutdown has started, readiness probe fails from this moment
+func Draining() bool {
+	return atomic.LoadInt32(&draining) == 1
+}
+
+// Begin marks start of request or connection that shutdown should wait for. Returned func marks its end
+func Begin() func() {
+	atomic.AddInt64(&active, 1)
+	return func() { atomic.AddInt64(&active, -1) }
+}
+
+// Track counts requests served by handler. Upgraded connections like WebSockets are proxied
+// inside of the handler, so they are counted until they are closed
+func Track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer Begin()()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// OnShutdown registers func that stops listener from accepting new connections.
+// It's called when draining starts, context is done when drain timeout expires
+func OnShutdown(f func(ctx context.Context)) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	hooks = append(hooks, f)
+}
+
+// Server registers graceful shutdown of http server
+func Server(srv *http.Server) {
+	OnShutdown(func(ctx context.Context) {
+		srv.Shutdown(ctx)
+	})
+}
+
+// Start makes Wait drain connections as if signal was received
+func Start(reason string) {
+	select {
+	case started <- reason:
+	default:
+	}
+}
+
+// Wait blocks until SIGTERM or SIGINT is received or Start is called and drains connections.
+// Signal received during draining stops the process at once
+func Wait() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	var reason string
+	select {
+	case sig := <-signals:
+		reason = sig.String()
+	case reason = <-started:
+	}
+	go func() {
+		<-signals
+		l.Error(map[string]string{}, "Signal received during draining, process is stopped at once")
+		Logger.Flush(flushTimeout)
+		os.Exit(1)
+	}()
+
+	l.Info(map[string]string{"reason": reason}, "Shutdown started")
+	Drain()
+}
+
+// Drain fails readiness, stops listeners and waits for active requests and connections until drain timeout.
+// Spans and log entries that are still being sent are flushed at the end
+func Drain() {
+	atomic.StoreInt32(&draining, 1)
+	time.Sleep(time.Duration(settings.Readiness_delay) * time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(settings.Drain_timeout)*time.Second)
+	defer cancel()
+
+	hooksMu.Lock()
+	stops := append([]func(ctx context.Context){}, hooks...)
+	hooksMu.Unlock()
+	var wg sync.WaitGroup
+	for _, stop := range stops {
+		wg.Add(1)
+		go func(stop func(ctx context.Context)) {
+			defer wg.Done()
+			stop(ctx)
+		}(stop)
+	}
+	wg.Wait()
+
+	if n := waitIdle(ctx); n != 0 {
+		l.WithFields(Logger.Int64("active", n)).Warning(nil, "Drain timeout expired, remaining connections are closed")
+	} else {
+		l.Info(map[string]string{}, "Connections drained")
+	}
+
+	if n := Tracing.Flush(flushTimeout); n != 0 {
+		l.WithFields(Logger.Int("spans", n)).Error(nil, "Not all spans were exported before exit")
+	}
+	if n := Logger.Flush(flushTimeout); n != 0 {
+		l.WithFields(Logger.Int("entries", n)).Error(nil, "Not all log entries were sent before exit")
+	}
+}
+
+// waits until there are no active requests and connections, returns their number if context is done earlier
+func waitIdle(ctx context.Context) int64 {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		n := atomic.LoadInt64(&active)
+		if n == 0 {
+			return 0
+		}
+		select {
+		case <-ctx.Done():
+			return n
+		case <-ticker.C:
+		}
+	}
+}
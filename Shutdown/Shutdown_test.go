@@ -0,0 +1,113 @@
+package Shutdown
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"proxy/Logger"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func init() {
+	Logger.Init(uint32(Logger.LError), Logger.UseStdOut, nil)
+}
+
+func reset(s Settings) {
+	InitShutdown(map[string]interface{}{})
+	settings = s
+	atomic.StoreInt32(&draining, 0)
+	hooks = nil
+}
+
+// starts tracked server whose handler blocks until release is closed
+func startServer(t *testing.T, release chan struct{}) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: Track(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte("done"))
+	}))}
+	Server(srv)
+	go srv.Serve(ln)
+	return ln.Addr().String()
+}
+
+func TestSettings_Validate(t *testing.T) {
+	s := Settings{Drain_timeout: -1}
+	if err := s.Validate(); err == nil {
+		t.Error("Validate() should fail for negative drain timeout")
+	}
+	s = Settings{}
+	if err := s.Validate(); err != nil || s.Drain_timeout != 30 {
+		t.Error("Drain timeout should be 30 seconds by default: ", err)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	reset(Settings{Drain_timeout: 5})
+	release := make(chan struct{})
+	addr := startServer(t, release)
+
+	body := make(chan string)
+	go func() {
+		res, err := http.Get("http://" + addr)
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		data, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		body <- string(data)
+	}()
+	for atomic.LoadInt64(&active) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		Drain()
+		close(drained)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if !Draining() {
+		t.Error("Draining should be reported after shutdown started")
+	}
+	if c, err := net.Dial("tcp", addr); err == nil {
+		c.Close()
+		t.Error("New connections shouldn't be accepted while draining")
+	}
+	select {
+	case <-drained:
+		t.Fatal("Drain shouldn't finish while request is active")
+	default:
+	}
+
+	close(release)
+	if v := <-body; v != "done" {
+		t.Error("Active request should be finished, got: " + v)
+	}
+	select {
+	case <-drained:
+	case <-time.After(time.Second):
+		t.Error("Drain should finish when there are no active requests")
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	reset(Settings{Drain_timeout: 1})
+	stopped := false
+	OnShutdown(func(ctx context.Context) { stopped = true })
+	done := Begin()
+	defer done()
+
+	start := time.Now()
+	Drain()
+	if !stopped || time.Since(start) < time.Second || time.Since(start) > 3*time.Second {
+		t.Error("Drain should stop listeners and wait until drain timeout, waited: ", time.Since(start))
+	}
+}
@@ -0,0 +1,75 @@
+package Tracing
+
+import (
+	"encoding/json"
+	"proxy/Logger"
+	"proxy/Metrics"
+	"strconv"
+)
+
+func init() {
+	Metrics.NewCounterFunc("proxy_trace_spans_dropped_total",
+		"Spans dropped because buffer was full or collector rejected them", nil, func() []Metrics.Sample {
+			t := current
+			if t == nil {
+				return nil
+			}
+			return []Metrics.Sample{{Value: float64(t.exporter.Dropped())}}
+		})
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// span of OTLP json encoding, ids are hex encoded
+type otlpSpan struct {
+	TraceId           string                 `json:"traceId"`
+	SpanId            string                 `json:"spanId"`
+	ParentSpanId      string                 `json:"parentSpanId,omitempty"`
+	TraceState        string                 `json:"traceState,omitempty"`
+	Name              string                 `json:"name"`
+	Kind              int                    `json:"kind"`
+	StartTimeUnixNano string                 `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string                 `json:"endTimeUnixNano"`
+	Attributes        []Logger.OtlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus             `json:"status"`
+}
+
+func (s *Span) encode() otlpSpan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rv := otlpSpan{
+		TraceId:           s.context.TraceID.String(),
+		SpanId:            s.context.SpanID.String(),
+		TraceState:        s.context.TraceState,
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		Attributes:        s.attributes,
+		Status:            otlpStatus{s.status, s.message},
+	}
+	if s.parent != (SpanID{}) {
+		rv.ParentSpanId = s.parent.String()
+	}
+	return rv
+}
+
+// newExporter creates exporter of spans. Batches failed because collector is overloaded or unavailable
+// are exported again, newer spans are dropped if buffer is full, so requests aren't slowed down by the collector
+func newExporter(s Settings) *Logger.OtlpExporter {
+	batch := Logger.BatchSettings{BatchSize: s.BatchSize, FlushInterval: s.FlushInterval, BufferSize: s.BufferSize,
+		DropPolicy: Logger.DropNewest}
+	return Logger.NewOtlpExporter(s.OtlpCollector, batch, "Spans", "spans")
+}
+
+// export queues ended span
+func (t *tracer) export(s *Span) {
+	data, err := json.Marshal(s.encode())
+	if err != nil {
+		return
+	}
+	t.exporter.Add(data)
+}
@@ -0,0 +1,224 @@
+package Tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+const (
+	FormatTraceContext = "tracecontext"
+	FormatBaggage      = "baggage"
+	FormatB3           = "b3"
+	FormatB3Multi      = "b3multi"
+)
+
+// longest baggage header that is propagated, limit of W3C baggage specification
+const maxBaggage = 8192
+
+type TraceID [16]byte
+
+type SpanID [8]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	if _, err := rand.Read(id[:]); err != nil {
+		panic("Can't generate trace id. Error: " + err.Error())
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	if _, err := rand.Read(id[:]); err != nil {
+		panic("Can't generate span id. Error: " + err.Error())
+	}
+	return id
+}
+
+// SpanContext is identity of span propagated between services
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+	// vendor-specific values of W3C tracestate header, they are passed unchanged
+	TraceState string
+	// W3C baggage header, it's passed unchanged
+	Baggage string
+
+	// sender didn't decide whether trace is sampled, it's possible with B3
+	deferred bool
+}
+
+// Valid reports whether trace and span ids are set
+func (sc SpanContext) Valid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// propagator reads its format of trace context from headers into sc and writes it to headers
+type propagator struct {
+	extract func(h http.Header, sc *SpanContext)
+	inject  func(sc SpanContext, h http.Header)
+	// headers of the format, they are removed before injection, so stale values of the client aren't sent
+	headers []string
+}
+
+var propagators = map[string]propagator{
+	FormatTraceContext: {extractTraceContext, injectTraceContext, []string{"Traceparent", "Tracestate"}},
+	FormatBaggage:      {extractBaggage, injectBaggage, []string{"Baggage"}},
+	FormatB3:           {extractB3, injectB3, []string{"B3", "X-B3-Traceid", "X-B3-Spanid", "X-B3-Parentspanid", "X-B3-Sampled", "X-B3-Flags"}},
+	FormatB3Multi:      {extractB3, injectB3Multi, []string{"B3", "X-B3-Traceid", "X-B3-Spanid", "X-B3-Parentspanid", "X-B3-Sampled", "X-B3-Flags"}},
+}
+
+// extract reads trace context of incoming request with configured formats, the first valid one is used
+func (t *tracer) extract(h http.Header) SpanContext {
+	var sc SpanContext
+	for _, f := range t.settings.Propagation {
+		if sc.Valid() && f != FormatBaggage {
+			continue
+		}
+		propagators[f].extract(h, &sc)
+	}
+	return sc
+}
+
+// inject replaces trace context in headers of outgoing request with the span context
+func (t *tracer) inject(sc SpanContext, h http.Header) {
+	for _, f := range t.settings.Propagation {
+		for _, name := range propagators[f].headers {
+			h.Del(name)
+		}
+	}
+	for _, f := range t.settings.Propagation {
+		propagators[f].inject(sc, h)
+	}
+}
+
+// decodes lowercase hex of exact length into id, all-zero id isn't valid
+func decodeID(s string, id []byte) bool {
+	if len(s) != 2*len(id) || strings.ToLower(s) != s {
+		return false
+	}
+	if _, err := hex.Decode(id, []byte(s)); err != nil {
+		return false
+	}
+	for _, b := range id {
+		if b != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// traceparent: version-traceid-parentid-flags, e.g. 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func extractTraceContext(h http.Header, sc *SpanContext) {
+	parts := strings.Split(strings.TrimSpace(h.Get("Traceparent")), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || parts[0] == "00" && len(parts) != 4 {
+		return
+	}
+	var version, flags [1]byte
+	var next SpanContext
+	if !decodeID(parts[1], next.TraceID[:]) || !decodeID(parts[2], next.SpanID[:]) || len(parts[3]) != 2 {
+		return
+	}
+	if _, err := hex.Decode(version[:], []byte(parts[0])); err != nil {
+		return
+	}
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return
+	}
+	next.Sampled = flags[0]&1 == 1
+	next.TraceState = strings.Join(h.Values("Tracestate"), ",")
+	next.Baggage = sc.Baggage
+	*sc = next
+}
+
+func injectTraceContext(sc SpanContext, h http.Header) {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	h.Set("Traceparent", "00-"+sc.TraceID.String()+"-"+sc.SpanID.String()+"-"+flags)
+	if sc.TraceState != "" {
+		h.Set("Tracestate", sc.TraceState)
+	}
+}
+
+func extractBaggage(h http.Header, sc *SpanContext) {
+	if b := strings.Join(h.Values("Baggage"), ","); len(b) <= maxBaggage {
+		sc.Baggage = b
+	}
+}
+
+func injectBaggage(sc SpanContext, h http.Header) {
+	if sc.Baggage != "" {
+		h.Set("Baggage", sc.Baggage)
+	}
+}
+
+// reads 64 or 128 bit trace id of B3, 64 bit id is padded with zeros
+func decodeB3TraceID(s string, id *TraceID) bool {
+	if len(s) == 16 {
+		s = strings.Repeat("0", 16) + s
+	}
+	return decodeID(s, id[:])
+}
+
+// b3: traceid-spanid-sampled-parentspanid, sampled and parent are optional. X-B3-* headers are read if it's missing
+func extractB3(h http.Header, sc *SpanContext) {
+	var next SpanContext
+	var sampled string
+	if single := strings.TrimSpace(h.Get("B3")); single != "" {
+		parts := strings.Split(single, "-")
+		if len(parts) < 2 || !decodeB3TraceID(parts[0], &next.TraceID) || !decodeID(parts[1], next.SpanID[:]) {
+			return
+		}
+		if len(parts) > 2 {
+			sampled = parts[2]
+		}
+	} else {
+		if !decodeB3TraceID(h.Get("X-B3-Traceid"), &next.TraceID) || !decodeID(h.Get("X-B3-Spanid"), next.SpanID[:]) {
+			return
+		}
+		sampled = h.Get("X-B3-Sampled")
+		if h.Get("X-B3-Flags") == "1" {
+			sampled = "d"
+		}
+	}
+
+	switch sampled {
+	case "1", "d", "true":
+		next.Sampled = true
+	case "0", "false":
+	default:
+		next.deferred = true
+	}
+	next.Baggage = sc.Baggage
+	*sc = next
+}
+
+func b3Sampled(sc SpanContext) string {
+	if sc.Sampled {
+		return "1"
+	}
+	return "0"
+}
+
+func injectB3(sc SpanContext, h http.Header) {
+	h.Set("B3", sc.TraceID.String()+"-"+sc.SpanID.String()+"-"+b3Sampled(sc))
+}
+
+func injectB3Multi(sc SpanContext, h http.Header) {
+	h.Set("X-B3-Traceid", sc.TraceID.String())
+	h.Set("X-B3-Spanid", sc.SpanID.String())
+	h.Set("X-B3-Sampled", b3Sampled(sc))
+}
@@ -0,0 +1,315 @@
+package Tracing
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"proxy/Forwarding"
+	"proxy/Logger"
+	"proxy/RequestID"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mitchellh/mapstructure"
+)
+
+const (
+	SamplerAlwaysOn  = "always_on"
+	SamplerAlwaysOff = "always_off"
+	SamplerRatio     = "ratio"
+)
+
+// span kinds of OpenTelemetry data model
+const (
+	KindInternal = 1
+	KindServer   = 2
+	KindClient   = 3
+)
+
+// Settings describe tracing and export of spans to OpenTelemetry collector, /v1/traces is used if url has no path
+type Settings struct {
+	Logger.OtlpCollector `mapstructure:",squash"`
+	// always_on (default), always_off or ratio
+	Sampler string
+	// share of traces sampled by ratio sampler, from 0 to 1
+	Ratio float64
+	// sampler decides even if incoming request has sampling decision, by default the decision is followed
+	Ignore_parent bool
+	// formats of trace context read from requests and sent to auth services and upstreams:
+	// tracecontext, baggage, b3 (single header), b3multi. tracecontext and baggage by default
+	Propagation []string
+	// spans exported at once, 512 by default
+	BatchSize int
+	// seconds spans wait for the batch to fill, 5 by default
+	FlushInterval int
+	// spans kept while collector is slow or unavailable, newer spans are dropped when it's full. 2048 by default
+	BufferSize int
+}
+
+func (s *Settings) Validate() error {
+	if err := s.OtlpCollector.Validate("/v1/traces"); err != nil {
+		return errors.New("Tracing: " + err.Error())
+	}
+
+	switch s.Sampler {
+	case "":
+		s.Sampler = SamplerAlwaysOn
+	case SamplerAlwaysOn, SamplerAlwaysOff:
+	case SamplerRatio:
+		if s.Ratio < 0 || s.Ratio > 1 {
+			return errors.New("Sampling ratio should be from 0 to 1")
+		}
+	default:
+		return errors.New("Unsupported sampler: " + s.Sampler + ". Supported: always_on, always_off, ratio")
+	}
+
+	if len(s.Propagation) == 0 {
+		s.Propagation = []string{FormatTraceContext, FormatBaggage}
+	}
+	for _, f := range s.Propagation {
+		if _, exist := propagators[f]; !exist {
+			return errors.New("Unsupported propagation format: " + f + ". Supported: tracecontext, baggage, b3, b3multi")
+		}
+	}
+
+	if s.BatchSize < 0 || s.FlushInterval < 0 || s.BufferSize < 0 {
+		return errors.New("Tracing BatchSize, FlushInterval and BufferSize can't be negative")
+	}
+	if s.BatchSize == 0 {
+		s.BatchSize = 512
+	}
+	if s.FlushInterval == 0 {
+		s.FlushInterval = 5
+	}
+	if s.BufferSize == 0 {
+		s.BufferSize = 2048
+	}
+	if s.BufferSize < s.BatchSize {
+		return errors.New("Tracing BufferSize can't be less than BatchSize")
+	}
+	return nil
+}
+
+// Read tracing settings from parsed json value and validates it
+func ReadTracingFromFile(v interface{}) *Settings {
+	var s Settings
+	if err := mapstructure.Decode(v, &s); err != nil {
+		panic("Can't decode tracing settings. Error: " + err.Error())
+	}
+	if err := s.Validate(); err != nil {
+		panic(err.Error())
+	}
+
+	return &s
+}
+
+// tracer of the process, nil if tracing is disabled
+var current *tracer
+
+type tracer struct {
+	settings Settings
+	exporter *Logger.OtlpExporter
+}
+
+// Inits tracing from optional 'Tracing' section, requests aren't traced if it's missing
+func InitTracing(file map[string]interface{}) {
+	current = nil
+	v, exist := file["Tracing"]
+	if !exist {
+		return
+	}
+	s := ReadTracingFromFile(v)
+	current = &tracer{settings: *s, exporter: newExporter(*s)}
+}
+
+// Flush exports ended spans, but waits not longer than timeout. Returns number of spans that weren't exported
+func Flush(timeout time.Duration) int {
+	if current == nil {
+		return 0
+	}
+	return current.exporter.Flush(timeout)
+}
+
+// sampled decides whether new trace is recorded. Trace id is used instead of random number,
+// so all proxies with the same ratio make the same decision about the trace
+func (t *tracer) sampled(id TraceID) bool {
+	switch t.settings.Sampler {
+	case SamplerAlwaysOff:
+		return false
+	case SamplerRatio:
+		return float64(binary.BigEndian.Uint64(id[8:])>>11) < t.settings.Ratio*(1<<53)
+	}
+	return true
+}
+
+// starts span as child of parent, new trace is started if parent isn't valid
+func (t *tracer) start(parent SpanContext, name string, kind int) *Span {
+	sc := SpanContext{SpanID: newSpanID(), TraceState: parent.TraceState, Baggage: parent.Baggage}
+	s := &Span{name: name, kind: kind, start: time.Now(), tracer: t}
+	if parent.Valid() {
+		sc.TraceID, s.parent = parent.TraceID, parent.SpanID
+		sc.Sampled = parent.Sampled
+		if parent.deferred || t.settings.Ignore_parent {
+			sc.Sampled = t.sampled(sc.TraceID)
+		}
+	} else {
+		sc.TraceID = newTraceID()
+		sc.Sampled = t.sampled(sc.TraceID)
+	}
+	s.context = sc
+	return s
+}
+
+// Span is operation of the trace. Methods of nil span do nothing, so callers don't check whether tracing is enabled
+type Span struct {
+	tracer  *tracer
+	context SpanContext
+	parent  SpanID
+	name    string
+	kind    int
+	start   time.Time
+
+	mu         sync.Mutex
+	end        time.Time
+	attributes []Logger.OtlpAttribute
+	// status code of OpenTelemetry: 0 unset, 2 error
+	status  int
+	message string
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns context with the span, spans started with the context are its children
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, s)
+}
+
+// SpanFromContext returns span of the context, nil if it has none
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// Start starts span as child of span in the context, new trace is started if context has none.
+// Returns nil span and unchanged context if tracing is disabled
+func Start(ctx context.Context, name string, kind int) (*Span, context.Context) {
+	t := current
+	if t == nil {
+		return nil, ctx
+	}
+	var parent SpanContext
+	if p := SpanFromContext(ctx); p != nil {
+		parent = p.context
+	}
+	s := t.start(parent, name, kind)
+	return s, ContextWithSpan(ctx, s)
+}
+
+// Context returns identity of the span that is sent to other services
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.context
+}
+
+// SetAttribute adds attribute to the span, values other than string, bool, int, int64 or float64 are encoded as json
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil || !s.context.Sampled {
+		return
+	}
+	s.mu.Lock()
+	s.attributes = append(s.attributes, Logger.OtlpAttribute{Key: key, Value: Logger.NewOtlpValue(value)})
+	s.mu.Unlock()
+}
+
+// SetError marks span as failed with the message
+func (s *Span) SetError(message string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.status, s.message = 2, message
+	s.mu.Unlock()
+}
+
+// SetStatusCode adds http status of the response. Server spans fail on 5xx statuses, client spans on 4xx and 5xx
+func (s *Span) SetStatusCode(code int) {
+	if s == nil {
+		return
+	}
+	s.SetAttribute("http.status_code", code)
+	if code >= 500 || code >= 400 && s.kind == KindClient {
+		s.SetError(http.StatusText(code))
+	}
+}
+
+// End finishes the span, sampled span is queued for export. Span can't be changed after it's ended
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	ended := !s.end.IsZero()
+	if !ended {
+		s.end = time.Now()
+	}
+	s.mu.Unlock()
+	if !ended && s.context.Sampled {
+		s.tracer.export(s)
+	}
+}
+
+// Middleware starts server span of each request as child of trace context sent by the client.
+// Span is stored in request context, trace and span ids are added to its log fields
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		t := current
+		if t == nil {
+			c.Next()
+			return
+		}
+
+		req := c.Request
+		name := req.Method
+		if route := c.FullPath(); route != "" {
+			name += " " + route
+		}
+		s := t.start(t.extract(req.Header), name, KindServer)
+		s.SetAttribute("http.method", req.Method)
+		s.SetAttribute("http.route", c.FullPath())
+		s.SetAttribute("http.target", Logger.Redact(req.RequestURI))
+		s.SetAttribute("http.host", req.Host)
+		s.SetAttribute("http.client_ip", Forwarding.ClientIP(req))
+		if id := RequestID.FromContext(req.Context()); id != "" {
+			s.SetAttribute("http.request_id", id)
+		}
+
+		ctx := Logger.ContextWithFields(ContextWithSpan(req.Context(), s),
+			Logger.String("trace_id", s.context.TraceID.String()), Logger.String("span_id", s.context.SpanID.String()))
+		c.Request = req.WithContext(ctx)
+		c.Next()
+
+		s.SetStatusCode(c.Writer.Status())
+		s.End()
+	}
+}
+
+// StartClient starts client span of request to another service as child of span in ctx.
+// Returns copy of request with trace context of the span in headers, request is returned as is if tracing is disabled
+func StartClient(ctx context.Context, req *http.Request, name string) (*Span, *http.Request) {
+	t := current
+	if t == nil {
+		return nil, req
+	}
+	s, _ := Start(ctx, name, KindClient)
+	s.SetAttribute("http.method", req.Method)
+	s.SetAttribute("http.url", Logger.Redact(req.URL.String()))
+	s.SetAttribute("net.peer.name", req.URL.Host)
+
+	req = req.Clone(req.Context())
+	t.inject(s.context, req.Header)
+	return s, req
+}
@@ -0,0 +1,267 @@
+package Tracing
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"proxy/Logger"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// collector is in-process OTLP/HTTP collector keeping spans of export requests
+type collector struct {
+	*httptest.Server
+	mu      sync.Mutex
+	service string
+	spans   []otlpSpan
+	// export requests answered with 503 before spans are accepted
+	failures int
+}
+
+func newCollector() *collector {
+	c := &collector{}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			ResourceSpans []struct {
+				Resource struct {
+					Attributes []Logger.OtlpAttribute
+				}
+				ScopeSpans []struct {
+					Spans []otlpSpan
+				}
+			}
+		}
+		data, _ := ioutil.ReadAll(r.Body)
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" ||
+			json.Unmarshal(data, &body) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.failures > 0 {
+			c.failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		for _, rs := range body.ResourceSpans {
+			c.service, _ = rs.Resource.Attributes[0].Value["stringValue"].(string)
+			for _, ss := range rs.ScopeSpans {
+				c.spans = append(c.spans, ss.Spans...)
+			}
+		}
+	}))
+	return c
+}
+
+func (c *collector) received() []otlpSpan {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]otlpSpan{}, c.spans...)
+}
+
+func initTestTracing(t *testing.T, settings map[string]interface{}) {
+	Logger.Init(uint32(Logger.LError), Logger.UseStdOut, nil)
+	gin.SetMode(gin.TestMode)
+	InitTracing(map[string]interface{}{"Tracing": settings})
+	t.Cleanup(func() { InitTracing(map[string]interface{}{}) })
+}
+
+func TestSettings_Validate(t *testing.T) {
+	at := func(url string) Logger.OtlpCollector { return Logger.OtlpCollector{Url: url} }
+	s := Settings{OtlpCollector: at("http://collector:4318")}
+	if err := s.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Url != "http://collector:4318/v1/traces" || s.Sampler != SamplerAlwaysOn || s.ServiceName != "proxy" ||
+		len(s.Propagation) != 2 || s.BatchSize != 512 || s.BufferSize != 2048 {
+		t.Error("Unexpected defaults: ", s)
+	}
+
+	for _, invalid := range []Settings{
+		{},
+		{OtlpCollector: at("collector:4318")},
+		{OtlpCollector: at("http://collector"), Sampler: "sometimes"},
+		{OtlpCollector: at("http://collector"), Sampler: SamplerRatio, Ratio: 1.5},
+		{OtlpCollector: at("http://collector"), Propagation: []string{"jaeger"}},
+		{OtlpCollector: at("http://collector"), BatchSize: 100, BufferSize: 10},
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Error("Validate() should fail: ", invalid)
+		}
+	}
+}
+
+func TestExtract(t *testing.T) {
+	tr := &tracer{settings: Settings{Propagation: []string{FormatTraceContext, FormatBaggage, FormatB3}}}
+
+	h := http.Header{}
+	h.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h.Set("Tracestate", "vendor=1")
+	h.Set("Baggage", "user=42")
+	sc := tr.extract(h)
+	if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" ||
+		!sc.Sampled || sc.TraceState != "vendor=1" || sc.Baggage != "user=42" {
+		t.Error("Unexpected trace context: ", sc)
+	}
+
+	for _, invalid := range []string{
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		h := http.Header{}
+		h.Set("Traceparent", invalid)
+		if tr.extract(h).Valid() {
+			t.Error("Invalid traceparent should be ignored: " + invalid)
+		}
+	}
+
+	h = http.Header{}
+	h.Set("B3", "a3ce929d0e0e4736-00f067aa0ba902b7-0")
+	sc = tr.extract(h)
+	if sc.TraceID.String() != "0000000000000000a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" || sc.Sampled {
+		t.Error("Unexpected trace context of b3 header: ", sc)
+	}
+
+	h = http.Header{}
+	h.Set("X-B3-TraceId", "4bf92f3577b34da6a3ce929d0e0e4736")
+	h.Set("X-B3-SpanId", "00f067aa0ba902b7")
+	sc = tr.extract(h)
+	if !sc.Valid() || !sc.deferred {
+		t.Error("B3 headers without sampling decision should defer it: ", sc)
+	}
+}
+
+func TestInject(t *testing.T) {
+	tr := &tracer{settings: Settings{Propagation: []string{FormatTraceContext, FormatBaggage, FormatB3Multi}}}
+	sc := SpanContext{TraceID: newTraceID(), SpanID: newSpanID(), Sampled: true, Baggage: "user=42"}
+
+	h := http.Header{}
+	h.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h.Set("X-B3-ParentSpanId", "00f067aa0ba902b7")
+	tr.inject(sc, h)
+	if h.Get("Traceparent") != "00-"+sc.TraceID.String()+"-"+sc.SpanID.String()+"-01" || h.Get("Baggage") != "user=42" {
+		t.Error("Trace context should be replaced: ", h)
+	}
+	if h.Get("X-B3-TraceId") != sc.TraceID.String() || h.Get("X-B3-SpanId") != sc.SpanID.String() ||
+		h.Get("X-B3-Sampled") != "1" || h.Get("X-B3-ParentSpanId") != "" {
+		t.Error("B3 headers should be replaced: ", h)
+	}
+}
+
+func TestSampling(t *testing.T) {
+	tr := &tracer{settings: Settings{Sampler: SamplerRatio, Ratio: 0}}
+	if tr.start(SpanContext{}, "root", KindServer).context.Sampled {
+		t.Error("Ratio 0 shouldn't sample new traces")
+	}
+	parent := SpanContext{TraceID: newTraceID(), SpanID: newSpanID(), Sampled: true}
+	if !tr.start(parent, "child", KindServer).context.Sampled {
+		t.Error("Sampling decision of parent should be followed")
+	}
+	tr.settings.Ignore_parent = true
+	if tr.start(parent, "child", KindServer).context.Sampled {
+		t.Error("Sampler should decide if parent is ignored")
+	}
+
+	tr = &tracer{settings: Settings{Sampler: SamplerRatio, Ratio: 0.5}}
+	sampled := 0
+	for i := 0; i < 1000; i++ {
+		if tr.start(SpanContext{}, "root", KindServer).context.Sampled {
+			sampled++
+		}
+	}
+	if sampled < 400 || sampled > 600 {
+		t.Error("Ratio 0.5 should sample about half of traces: ", sampled)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	c := newCollector()
+	defer c.Close()
+	initTestTracing(t, map[string]interface{}{"url": c.URL, "serviceName": "edge"})
+
+	var upstreamHeader http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHeader = r.Header.Clone()
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer upstream.Close()
+
+	engine := gin.New()
+	engine.Use(Middleware())
+	engine.GET("/items/:id", func(ctx *gin.Context) {
+		req, _ := http.NewRequest("GET", upstream.URL, nil)
+		span, req := StartClient(ctx.Request.Context(), req, "GET")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		span.SetStatusCode(resp.StatusCode)
+		span.End()
+		ctx.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest("GET", "/items/1?token=secret", nil)
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("Baggage", "user=42")
+	engine.ServeHTTP(httptest.NewRecorder(), req)
+	if n := Flush(2 * time.Second); n != 0 {
+		t.Fatal("Spans should be exported: ", n)
+	}
+
+	spans := c.received()
+	if len(spans) != 2 || c.service != "edge" {
+		t.Fatal("Server and client spans should be exported: ", spans)
+	}
+	client, server := spans[0], spans[1]
+	if server.Name != "GET /items/:id" || server.Kind != KindServer || server.TraceId != "4bf92f3577b34da6a3ce929d0e0e4736" ||
+		server.ParentSpanId != "00f067aa0ba902b7" || server.Status.Code != 0 {
+		t.Error("Unexpected server span: ", server)
+	}
+	if client.Kind != KindClient || client.TraceId != server.TraceId || client.ParentSpanId != server.SpanId ||
+		client.Status.Code != 2 {
+		t.Error("Unexpected client span: ", client)
+	}
+	for _, a := range server.Attributes {
+		if a.Key == "http.target" && a.Value["stringValue"] != "/items/1?token=[REDACTED]" {
+			t.Error("Target should be redacted: ", a.Value)
+		}
+	}
+
+	if upstreamHeader.Get("Traceparent") != "00-"+client.TraceId+"-"+client.SpanId+"-01" ||
+		upstreamHeader.Get("Baggage") != "user=42" {
+		t.Error("Upstream should get trace context of client span: ", upstreamHeader)
+	}
+}
+
+func TestExportRetry(t *testing.T) {
+	c := newCollector()
+	defer c.Close()
+	c.failures = 1
+	initTestTracing(t, map[string]interface{}{"url": c.URL})
+
+	current.start(SpanContext{}, "root", KindInternal).End()
+	if n := Flush(2 * time.Second); n != 0 || len(c.received()) != 1 {
+		t.Error("Span should be exported again after collector was unavailable: ", n)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	InitTracing(map[string]interface{}{})
+	req, _ := http.NewRequest("GET", "http://upstream", nil)
+	span, out := StartClient(req.Context(), req, "GET")
+	span.SetStatusCode(http.StatusInternalServerError)
+	span.End()
+	if span != nil || out != req || out.Header.Get("Traceparent") != "" {
+		t.Error("Requests shouldn't be traced without 'Tracing' section")
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/mitchellh/mapstructure"
+	"os"
+	"proxy/DevCert"
+	"proxy/Protocol"
+	"strings"
+)
+
+// genCert writes development CA and certificates of TLS listeners to the paths from 'Protocols' section.
+// Existing CA is reused, so it has to be added to the trust store only once
+func genCert(args []string) {
+	flags := flag.NewFlagSet("gencert", flag.ExitOnError)
+	env := flags.String("env", "", "settings environment")
+	hosts := flags.String("hosts", "", "comma separated hostnames added to the ones found in settings")
+	caCert := flags.String("ca-cert", DevCert.CACertPath, "development CA certificate, created if missing")
+	caKey := flags.String("ca-key", DevCert.CAKeyPath, "development CA key")
+	flags.Parse(args)
+	readSettingFile(*env)
+
+	fail := func(message string, err error) {
+		fmt.Fprintln(os.Stderr, message+" Error: "+err.Error())
+		os.Exit(1)
+	}
+
+	var protocols []Protocol.Protocol
+	if err := mapstructure.Decode(settingsFile["Protocols"], &protocols); err != nil {
+		fail("Can't decode 'Protocols' section.", err)
+	}
+
+	names := DevCert.Hosts(settingsFile)
+	for _, h := range strings.Split(*hosts, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			names = append(names, h)
+		}
+	}
+
+	ca, created, err := DevCert.LoadOrCreateCA(*caCert, *caKey)
+	if err != nil {
+		fail("Can't prepare development CA.", err)
+	}
+	if created {
+		fmt.Println("Created development CA " + *caCert + ", add it to the trust store of your system and browser")
+	}
+
+	written := map[string]bool{}
+	for _, p := range protocols {
+		if p.CertPath == "" || p.KeyPath == "" || written[p.CertPath] {
+			continue
+		}
+		certPem, keyPem, err := ca.Issue(names)
+		if err == nil {
+			err = DevCert.WriteFiles(p.CertPath, p.KeyPath, certPem, keyPem)
+		}
+		if err != nil {
+			fail("Can't write certificate "+p.CertPath+".", err)
+		}
+		written[p.CertPath] = true
+		fmt.Println("Written " + p.CertPath + " and " + p.KeyPath + " for " + strings.Join(names, ", "))
+	}
+	if len(written) == 0 {
+		fmt.Println("No protocols with certPath and keyPath found in settings")
+	}
+}
@@ -3,10 +3,12 @@ module proxy
 go 1.14
 
 require (
+	github.com/andybalholm/brotli v1.0.4
 	github.com/elastic/go-elasticsearch/v7 v7.5.1-0.20200617142445-77ff7b9ccefd
 	github.com/gin-gonic/gin v1.6.3
 	github.com/google/logger v1.1.0
+	github.com/klauspost/compress v1.15.1
 	github.com/mitchellh/mapstructure v1.3.2
 	github.com/sirupsen/logrus v1.6.0
-	gopkg.in/go-extras/elogrus.v7 v7.1.0
+	golang.org/x/crypto v0.0.0-20211117183948-ae814b36b871
 )
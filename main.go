@@ -1,17 +1,30 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"github.com/gin-gonic/gin"
 	"io/ioutil"
+	"net/http"
 	"os"
+	"proxy/Acme"
+	"proxy/Admin"
 	"proxy/Authentication"
+	"proxy/Cache"
+	"proxy/Compression"
+	"proxy/Cors"
 	"proxy/Endpoint"
+	"proxy/Forwarding"
+	"proxy/Handoff"
+	"proxy/Headers"
+	"proxy/Layer4"
 	log "proxy/Logger"
 	"proxy/Protocol"
+	"proxy/RequestID"
+	"proxy/Shutdown"
+	"proxy/Tracing"
 	"strconv"
-	"sync"
 )
 
 var settingsFile map[string]interface{}
@@ -22,14 +35,12 @@ var (
 )
 
 
-func readSettingFile() {
-	env := flag.String("env", "", "a string")
-	flag.Parse()
-	if *env != "" {
-		*env = "." + *env
+func readSettingFile(env string) {
+	if env != "" {
+		env = "." + env
 	}
 
-	defaultFileName, envFileName := "settings.json", "settings" + *env + ".json"
+	defaultFileName, envFileName := "settings.json", "settings" + env + ".json"
 
 	defFile, err1 := os.Open(defaultFileName)
 	defer func() { if err1 == nil { defFile.Close()}}()
@@ -37,7 +48,7 @@ func readSettingFile() {
 	defer func() { if err2 == nil { envFile.Close()}}()
 
 	if err1 != nil && err2 != nil {
-		panic("not settings file found under the following environment: " + *env)
+		panic("not settings file found under the following environment: " + env)
 	}
 
 	defSettings := map[string]interface{}{}
@@ -52,7 +63,7 @@ func readSettingFile() {
 	if err2 == nil {
 		envByteVal, _ := ioutil.ReadAll(envFile)
 		if err2 = json.Unmarshal(envByteVal, &envSettings); err2 != nil {
-			panic("Can't unmarshal environment settings file under env: " + *env + " . Error: " + err2.Error())
+			panic("Can't unmarshal environment settings file under env: " + env + " . Error: " + err2.Error())
 		}
 	}
 
@@ -68,7 +79,13 @@ func initLogging() {
 
 		logData := log.ReadLoggerDataFromFile(v)
 		flags, data := log.PrepareInitData(logData)
+		log.InitRedact(logData.Redact)
+		log.InitRotation(logData.Rotation)
+		log.InitElastic(logData.Elastic)
 		log.Init(log.StringLevelToLevel(logData.Level), flags, data)
+		log.InitLevels(logData.Levels)
+		log.InitSinks(logData.Sinks)
+		log.InitAccess(logData.Access)
 	} else {
 
 		log.Init(uint32(log.LInfo), log.UseStdOut, nil)
@@ -101,44 +118,114 @@ func initEndpoint(cl *gin.Engine) {
 }
 
 func main () {
+	// 'gencert' subcommand writes development certificates instead of running the proxy
+	if len(os.Args) > 1 && os.Args[1] == "gencert" {
+		genCert(os.Args[2:])
+		return
+	}
 
-	readSettingFile()
+	env := flag.String("env", "", "a string")
+	flag.Parse()
+	readSettingFile(*env)
 	initLogging()
 	l = log.New("main", 0, map[string]string{})
 
+	Shutdown.InitShutdown(settingsFile)
+	Handoff.InitHandoff(settingsFile)
+	Acme.InitAcme(settingsFile)
 	Protocol.InitProtocols(settingsFile)
+	Forwarding.InitForwarding(settingsFile)
+	RequestID.InitRequestID(settingsFile)
+	Compression.InitCompression(settingsFile)
+	Cache.InitCache(settingsFile)
+	Cors.InitCors(settingsFile)
+	Headers.InitHeaders(settingsFile)
+	Tracing.InitTracing(settingsFile)
 
 	cl := gin.New()
+	// request id is assigned first, so it's known to traces, access log, auth and endpoints.
+	// Trace ids are added to log fields, so tracing goes before logger middleware.
+	// Access log middleware goes before auth to see its decisions and responses of all routes
+	cl.Use(RequestID.Middleware(&RequestID.Global), Tracing.Middleware(), log.Middleware(l), Endpoint.AccessLog(), Endpoint.RequestMetrics())
 	initAuth(cl)
 	initEndpoint(cl)
 
+	admin := Admin.InitAdmin(settingsFile)
+	if admin != nil {
+		Cache.RegisterAdmin(admin.Group("/cache"))
+		log.RegisterAdmin(admin.Group("/log"))
+	}
+
 	if v, exist := settingsFile["Addr"]; exist {
 		v2, ok := v.(string)
 		if ok == false {panic("Can't cast 'Addr' field to string")}
 		Addr = v2
 	}
 
-	wg := sync.WaitGroup{}
-	wg.Add(len(Protocol.Protocols))
-
+	// listeners are created before serving, so all of them are taken over before previous process is told to drain
 	for _, v := range Protocol.Protocols {
-		if v.Type == "https" {
-			go func(p Protocol.Protocol) {
-				defer wg.Done()
-				addr := Addr + ":" + strconv.Itoa(p.Port)
-				err := cl.RunTLS(addr, "TLS/cert.pem", "TLS/key.pem")
-				panic("Unable to run TLS server. Error: " + err.Error())
-			}(v)
+		p := v
+		// Create address from ip, protocol and port
+		addr := Addr + ":" + strconv.Itoa(p.Port)
+		if p.Type == "udp" {
+			conn, err := p.ListenPacket(addr)
+			if err != nil {
+				panic("Unable to listen on " + addr + ". Error: " + err.Error())
+			}
+			// udp sessions have no end to wait for, new datagrams just stop being read
+			Shutdown.OnShutdown(func(ctx context.Context) { conn.Close() })
+			go func() {
+				err := Layer4.ServeUDP(&p, conn)
+				if Shutdown.Draining() {
+					return
+				}
+				panic("Unable to run udp proxy. Error: " + err.Error())
+			}()
 			continue
 		}
 
-		go func(p Protocol.Protocol) {
-			defer wg.Done()
-			// Create address from ip, protocol and port. above the sames
-			addr := Addr + ":" + strconv.Itoa(p.Port)
-			cl.Run(addr)
-		}(v)
+		ln, err := p.Listen(addr)
+		if err != nil {
+			panic("Unable to listen on " + addr + ". Error: " + err.Error())
+		}
+
+		if p.Type == "tcp" {
+			Shutdown.OnShutdown(func(ctx context.Context) { ln.Close() })
+			go func() {
+				err := Layer4.ServeTCP(&p, ln)
+				if Shutdown.Draining() {
+					return
+				}
+				panic("Unable to run tcp proxy. Error: " + err.Error())
+			}()
+			continue
+		}
+		// https listener already terminates TLS, plain http listener also answers ACME HTTP-01 challenges
+		var handler http.Handler = cl
+		if p.Type == "http" {
+			handler = Acme.Handler(cl)
+		}
+		srv := &http.Server{Handler: Shutdown.Track(handler), ErrorLog: p.ErrorLog()}
+		Shutdown.Server(srv)
+		go func() {
+			if err := srv.Serve(ln); err != http.ErrServerClosed {
+				panic("Unable to run server. Error: " + err.Error())
+			}
+		}()
 	}
 
-	wg.Wait()
-}
\ No newline at end of file
+	if admin != nil {
+		ln, err := Admin.Listen()
+		if err != nil {
+			panic("Unable to listen on admin address. Error: " + err.Error())
+		}
+		// admin listener keeps serving during draining, so readiness probe reports it
+		go func() {
+			err := Admin.Serve(ln)
+			panic("Unable to run admin server. Error: " + err.Error())
+		}()
+	}
+
+	Handoff.Ready()
+	Shutdown.Wait()
+}
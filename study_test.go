@@ -7,6 +7,6 @@ import (
 
 func BenchmarkHello(b *testing.B) {
 	for i := 0; i < b.N; i++ {
-		fmt.Sprintf("hello")
+		_ = fmt.Sprintf("hello")
 	}
 }